@@ -0,0 +1,132 @@
+package bloom
+
+import (
+	"math"
+)
+
+// CountingFilter is a bloom filter over a sliding window of items. It counts
+// the items setting each bit, so that items leaving the window can be removed.
+// Its bits are those of a Filter with the same key and sizing holding the
+// items of the window, and are maintained as items are added and removed.
+//
+// Counters saturate, and saturated bits are never cleared. Removing an item
+// which was not added may clear the bits of other items.
+type CountingFilter struct {
+	key     []byte
+	numHash uint64
+	counts  []uint16
+	bits    *BitSet
+	entries uint64
+	dirty   map[uint64]bool // Words of bits changed since the last Sync
+}
+
+// NewCountingFilter creates a CountingFilter with the sizing of
+// NewFilter(key, numItems, fpRate).
+func NewCountingFilter(key []byte, numItems uint64, fpRate float64) (*CountingFilter, error) {
+	f, err := NewFilter(key, numItems, fpRate)
+	if err != nil {
+		return nil, err
+	}
+	return &CountingFilter{
+		key:     f.key,
+		numHash: f.numHash,
+		counts:  make([]uint16, f.numBits),
+		bits:    f.layers[0],
+		dirty:   make(map[uint64]bool),
+	}, nil
+}
+
+// NumHash returns the number of locations set for each item.
+func (f *CountingFilter) NumHash() uint64 {
+	return f.numHash
+}
+
+// KeyID returns the identifier of the key of the filter.
+func (f *CountingFilter) KeyID() uint32 {
+	return KeyID(f.key)
+}
+
+// Entries returns the number of items in the filter.
+func (f *CountingFilter) Entries() uint64 {
+	return f.entries
+}
+
+// Bits returns the bits of the filter. They change as items are added and
+// removed.
+func (f *CountingFilter) Bits() *BitSet {
+	return f.bits
+}
+
+// MarshalVector encodes the bits of the filter with MarshalVector.
+func (f *CountingFilter) MarshalVector(compress bool) ([]byte, error) {
+	return MarshalVector(f.bits, f.numHash, f.KeyID(), compress)
+}
+
+// Locations returns the bit locations of data in the filter.
+func (f *CountingFilter) Locations(data []byte) []uint64 {
+	return GetLocations(f.key, f.numHash, data)
+}
+
+// Test returns true if data may be in the filter.
+func (f *CountingFilter) Test(data []byte) bool {
+	return CheckLocations(f.bits, f.Locations(data))
+}
+
+// Add adds data to the filter, and returns its locations, which are needed
+// to remove it.
+func (f *CountingFilter) Add(data []byte) []uint64 {
+	locations := f.Locations(data)
+	f.AddLocations(locations)
+	return locations
+}
+
+// AddLocations adds an item with the given locations to the filter.
+// Locations beyond the number of hashes of the filter are ignored.
+func (f *CountingFilter) AddLocations(locations []uint64) {
+	for _, loc := range f.truncate(locations) {
+		i := loc % f.bits.Length()
+		if f.counts[i] == math.MaxUint16 {
+			continue
+		}
+		f.counts[i]++
+		if f.counts[i] == 1 {
+			f.bits.Set(i)
+			f.dirty[i>>log2WordSize] = true
+		}
+	}
+	f.entries++
+}
+
+// RemoveLocations removes an item added with the given locations.
+func (f *CountingFilter) RemoveLocations(locations []uint64) {
+	for _, loc := range f.truncate(locations) {
+		i := loc % f.bits.Length()
+		if f.counts[i] == 0 || f.counts[i] == math.MaxUint16 {
+			continue
+		}
+		f.counts[i]--
+		if f.counts[i] == 0 {
+			f.bits.Clear(i)
+			f.dirty[i>>log2WordSize] = true
+		}
+	}
+	if f.entries > 0 {
+		f.entries--
+	}
+}
+
+// Sync updates dst, a copy of the words of the bits of the filter as of the
+// previous call to Sync, to the current bits. Only changed words are copied.
+func (f *CountingFilter) Sync(dst []uint64) {
+	for w := range f.dirty {
+		dst[w] = f.bits.data[w]
+		delete(f.dirty, w)
+	}
+}
+
+func (f *CountingFilter) truncate(locations []uint64) []uint64 {
+	if uint64(len(locations)) > f.numHash {
+		return locations[:f.numHash]
+	}
+	return locations
+}
@@ -0,0 +1,60 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCountingFilter(t *testing.T) {
+	f, err := NewCountingFilter(KeyFromSeed(1), 100, 0.01)
+	if err != nil {
+		t.Fatalf("Error creating filter: %v", err)
+	}
+	layered, _ := NewFilter(KeyFromSeed(1), 100, 0.01)
+
+	locations := make([][]uint64, 0)
+	for i := 0; i < 20; i++ {
+		item := []byte(fmt.Sprintf("item %d", i))
+		locations = append(locations, f.Add(item))
+		if i >= 10 {
+			layered.TestAndSet(item)
+		}
+	}
+	if !f.Test([]byte("item 0")) || f.Entries() != 20 {
+		t.Errorf("Filter doesn't see what was previously added")
+	}
+
+	for _, locs := range locations[:10] {
+		f.RemoveLocations(locs)
+	}
+	if f.Test([]byte("item 0")) {
+		t.Errorf("Filter holds removed item")
+	}
+	if !f.Test([]byte("item 10")) || f.Entries() != 10 {
+		t.Errorf("Filter lost item when removing others")
+	}
+	if !Equal(f.Bits(), layered.Current()) {
+		t.Errorf("Bits differ from a Filter holding the same items")
+	}
+}
+
+func TestCountingFilterSync(t *testing.T) {
+	f, _ := NewCountingFilter(KeyFromSeed(1), 100, 0.01)
+	snapshot := make([]uint64, len(f.Bits().Bytes()))
+
+	locs := f.Add([]byte("a"))
+	f.Sync(snapshot)
+	if !Equal(From(f.Bits().Length(), snapshot), f.Bits()) {
+		t.Errorf("Sync missed added item")
+	}
+
+	f.RemoveLocations(locs)
+	f.Add([]byte("b"))
+	f.Sync(snapshot)
+	if !Equal(From(f.Bits().Length(), snapshot), f.Bits()) {
+		t.Errorf("Sync missed changes")
+	}
+	if CheckLocations(From(f.Bits().Length(), snapshot), locs) {
+		t.Errorf("Sync kept removed item")
+	}
+}
@@ -0,0 +1,127 @@
+package bloom
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+/**
+ * Wire format of interest vectors, as exchanged between replicas, the
+ * frontend, the coordinator and clients:
+ *   version (1 byte) | flags (1 byte) | numBits (8 bytes) | numHash (4 bytes) |
+ *   keyID (4 bytes) | words
+ * Integers are little endian. The words of the BitSet are little endian, and
+ * are DEFLATE compressed when flagCompressed is set.
+ */
+
+// vectorVersion is the version of the wire format of interest vectors.
+const vectorVersion byte = 1
+
+const (
+	flagCompressed byte = 1 << iota
+)
+
+// vectorHeaderSize is the length of the header of an encoded vector.
+const vectorHeaderSize = 1 + 1 + 8 + 4 + 4
+
+// VectorHeader describes an encoded interest vector.
+type VectorHeader struct {
+	Version    byte
+	Compressed bool
+	NumBits    uint64
+	NumHash    uint64
+	// Identifies the key hashing items into the vector, without revealing it.
+	KeyID uint32
+}
+
+// KeyID returns the identifier of a key in the header of encoded vectors.
+func KeyID(key []byte) uint32 {
+	sum := sha256.Sum256(append([]byte("talek interest key id "), key...))
+	return binary.LittleEndian.Uint32(sum[:])
+}
+
+// MarshalVector encodes b as an interest vector of numHash hashes keyed on
+// the key with keyID. The words are compressed if compress is set.
+func MarshalVector(b *BitSet, numHash uint64, keyID uint32, compress bool) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(vectorVersion)
+	var flags byte
+	if compress {
+		flags |= flagCompressed
+	}
+	buf.WriteByte(flags)
+	var header [16]byte
+	binary.LittleEndian.PutUint64(header[:], b.Length())
+	binary.LittleEndian.PutUint32(header[8:], uint32(numHash))
+	binary.LittleEndian.PutUint32(header[12:], keyID)
+	buf.Write(header[:])
+
+	words := encodeLayer(b)
+	if !compress {
+		buf.Write(words)
+		return buf.Bytes(), nil
+	}
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(words); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseVectorHeader decodes the header of an interest vector encoded by
+// MarshalVector.
+func ParseVectorHeader(data []byte) (*VectorHeader, error) {
+	if len(data) < vectorHeaderSize {
+		return nil, errors.New("invalid vector length")
+	}
+	if data[0] != vectorVersion {
+		return nil, fmt.Errorf("unknown vector version %d", data[0])
+	}
+	h := &VectorHeader{
+		Version:    data[0],
+		Compressed: data[1]&flagCompressed != 0,
+		NumBits:    binary.LittleEndian.Uint64(data[2:]),
+		NumHash:    uint64(binary.LittleEndian.Uint32(data[10:])),
+		KeyID:      binary.LittleEndian.Uint32(data[14:]),
+	}
+	if h.NumBits == 0 || h.NumBits > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid vector size: %d bits", h.NumBits)
+	}
+	return h, nil
+}
+
+// UnmarshalVector decodes an interest vector encoded by MarshalVector.
+func UnmarshalVector(data []byte) (*VectorHeader, *BitSet, error) {
+	h, err := ParseVectorHeader(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	words := data[vectorHeaderSize:]
+	if h.Compressed {
+		// Read past the expected length only far enough to reject longer input
+		length := int64(wordsNeeded(h.NumBits) * 8)
+		r := flate.NewReader(bytes.NewReader(words))
+		if words, err = ioutil.ReadAll(io.LimitReader(r, length+1)); err != nil {
+			return nil, nil, err
+		}
+	}
+	b, err := decodeLayer(h.NumBits, words)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, b, nil
+}
@@ -0,0 +1,60 @@
+package bloom
+
+import (
+	"testing"
+)
+
+func TestMarshalVector(t *testing.T) {
+	b := SetLocations(NewBitSet(1000), []uint64{1, 64, 999})
+	for _, compress := range []bool{false, true} {
+		data, err := MarshalVector(b, 7, 42, compress)
+		if err != nil {
+			t.Fatalf("Error encoding vector: %v", err)
+		}
+		h, decoded, err := UnmarshalVector(data)
+		if err != nil {
+			t.Fatalf("Error decoding vector: %v", err)
+		}
+		if h.Version != vectorVersion || h.Compressed != compress || h.NumBits != 1000 || h.NumHash != 7 || h.KeyID != 42 {
+			t.Errorf("Decoded header differs: %v", h)
+		}
+		if !Equal(b, decoded) {
+			t.Errorf("Decoded vector differs")
+		}
+		if _, _, err := UnmarshalVector(data[:len(data)-1]); err == nil {
+			t.Errorf("Decoded truncated vector")
+		}
+	}
+
+	data, _ := MarshalVector(b, 7, 42, false)
+	data[0] = vectorVersion + 1
+	if _, err := ParseVectorHeader(data); err == nil {
+		t.Errorf("Parsed vector of unknown version")
+	}
+}
+
+func TestFilterReset(t *testing.T) {
+	f, _ := NewCountingFilter(KeyFromSeed(1), 100, 0.01)
+	f.Add([]byte("a"))
+	vector, err := f.MarshalVector(true)
+	if err != nil {
+		t.Fatalf("Error encoding vector: %v", err)
+	}
+
+	client, _ := NewFilter(KeyFromSeed(1), 100, 0.01)
+	if err := client.Reset(vector); err != nil {
+		t.Fatalf("Error resetting filter: %v", err)
+	}
+	if !client.Test([]byte("a")) {
+		t.Errorf("Reset filter missing item")
+	}
+
+	other, _ := NewFilter(KeyFromSeed(2), 100, 0.01)
+	if err := other.Reset(vector); err == nil {
+		t.Errorf("Reset filter with vector of another key")
+	}
+	smaller, _ := NewFilter(KeyFromSeed(1), 50, 0.01)
+	if err := smaller.Import(vector); err == nil {
+		t.Errorf("Imported vector of another size")
+	}
+}
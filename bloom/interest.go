@@ -0,0 +1,288 @@
+package bloom
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// KeyLength is the length of the keys hashing items into a Filter.
+const KeyLength = 16
+
+// filterVersion is the version of the binary encoding of a Filter.
+const filterVersion byte = 1
+
+// Filter is a layered bloom filter holding the interest vector of recent
+// writes. Items are added to the newest layer. Delta starts a new layer and
+// returns the previous one, which clients add to their own filter with Import.
+// Once the layers hold more items than the filter is sized for, the oldest
+// layers are dropped.
+//
+// All parties hashing items with the same key and sizing compute the same
+// bits, so a layer exported by a replica can be tested by clients, and the
+// locations of an item can be sent in place of the item.
+type Filter struct {
+	key        []byte
+	numBits    uint64
+	numHash    uint64
+	maxEntries uint64
+	layers     []*BitSet // Newest first
+	entries    []uint64
+}
+
+// KeyFromSeed derives the key of a Filter from a shared seed, such as the
+// InterestSeed of the common configuration.
+func KeyFromSeed(seed int64) []byte {
+	buf := []byte("talek interest vector ")
+	buf = append(buf, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(buf[len(buf)-8:], uint64(seed))
+	sum := sha256.Sum256(buf)
+	return sum[:KeyLength]
+}
+
+// NewFilter creates a Filter holding numItems with a false positive rate of
+// fpRate, hashing items with GetLocations keyed on key.
+func NewFilter(key []byte, numItems uint64, fpRate float64) (*Filter, error) {
+	if len(key) != KeyLength {
+		return nil, fmt.Errorf("invalid key length: %d", len(key))
+	}
+	if numItems == 0 || fpRate <= 0 || fpRate >= 1 {
+		return nil, fmt.Errorf("invalid filter parameters: %d items, %v false positive rate", numItems, fpRate)
+	}
+	numBits, numHash := EstimateParameters(numItems, fpRate)
+	layer := NewBitSet(numBits)
+	if layer == nil {
+		return nil, fmt.Errorf("requested filter too large: %d bits", numBits)
+	}
+	return &Filter{
+		key:        append([]byte{}, key...),
+		numBits:    numBits,
+		numHash:    numHash,
+		maxEntries: numItems,
+		layers:     []*BitSet{layer},
+		entries:    []uint64{0},
+	}, nil
+}
+
+// NumBits returns the number of bits of each layer.
+func (f *Filter) NumBits() uint64 {
+	return f.numBits
+}
+
+// NumHash returns the number of locations set for each item.
+func (f *Filter) NumHash() uint64 {
+	return f.numHash
+}
+
+// Entries returns the number of items held in all layers.
+func (f *Filter) Entries() uint64 {
+	var entries uint64
+	for _, e := range f.entries {
+		entries += e
+	}
+	return entries
+}
+
+// Locations returns the bit locations of data in the filter.
+func (f *Filter) Locations(data []byte) []uint64 {
+	return GetLocations(f.key, f.numHash, data)
+}
+
+// Test returns true if data may have been added to any layer.
+func (f *Filter) Test(data []byte) bool {
+	return f.TestLocations(f.Locations(data))
+}
+
+// TestLocations returns true if all locations are set in any layer.
+func (f *Filter) TestLocations(locations []uint64) bool {
+	for _, layer := range f.layers {
+		if CheckLocations(layer, locations) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestAndSet adds data to the newest layer, unless it is already held.
+// It returns whether data was held before.
+func (f *Filter) TestAndSet(data []byte) bool {
+	locations := f.Locations(data)
+	if f.TestLocations(locations) {
+		return true
+	}
+	f.AddLocations(locations)
+	return false
+}
+
+// AddLocations adds an item with the given locations to the newest layer.
+// Locations beyond the number of hashes of the filter are ignored.
+func (f *Filter) AddLocations(locations []uint64) {
+	if uint64(len(locations)) > f.numHash {
+		locations = locations[:f.numHash]
+	}
+	SetLocations(f.layers[0], locations)
+	f.entries[0]++
+}
+
+// Current returns the newest layer.
+func (f *Filter) Current() *BitSet {
+	return f.layers[0]
+}
+
+// KeyID returns the identifier of the key of the filter.
+func (f *Filter) KeyID() uint32 {
+	return KeyID(f.key)
+}
+
+// Delta starts a new layer, and returns the previous one, encoded by
+// MarshalVector.
+func (f *Filter) Delta() []byte {
+	f.layers = append([]*BitSet{NewBitSet(f.numBits)}, f.layers...)
+	f.entries = append([]uint64{0}, f.entries...)
+	delta, _ := MarshalVector(f.layers[1], f.numHash, f.KeyID(), false)
+	f.expire()
+	return delta
+}
+
+// Import adds a layer returned by Delta of a filter with the same key and
+// sizing. The layer is held as the newest layer.
+func (f *Filter) Import(delta []byte) error {
+	layer, err := f.decode(delta)
+	if err != nil {
+		return err
+	}
+	f.layers = append([]*BitSet{layer}, f.layers...)
+	f.entries = append([]uint64{countBits(layer) / f.numHash}, f.entries...)
+	f.expire()
+	return nil
+}
+
+// Reset replaces all layers with a single vector encoded by MarshalVector.
+// It is used to take up a complete vector, such as the bits of a
+// CountingFilter with the same key and sizing.
+func (f *Filter) Reset(vector []byte) error {
+	layer, err := f.decode(vector)
+	if err != nil {
+		return err
+	}
+	f.layers = []*BitSet{layer}
+	f.entries = []uint64{countBits(layer) / f.numHash}
+	return nil
+}
+
+// decode decodes a vector encoded by MarshalVector, after checking that it
+// was computed with the key and sizing of the filter.
+func (f *Filter) decode(vector []byte) (*BitSet, error) {
+	h, err := ParseVectorHeader(vector)
+	if err != nil {
+		return nil, err
+	}
+	if h.NumBits != f.numBits || h.NumHash != f.numHash || h.KeyID != f.KeyID() {
+		return nil, fmt.Errorf("vector parameters differ: %d bits, %d hashes, key %x", h.NumBits, h.NumHash, h.KeyID)
+	}
+	_, layer, err := UnmarshalVector(vector)
+	return layer, err
+}
+
+// expire drops the oldest layers while the filter holds more items than it
+// is sized for. The newest layer is always kept.
+func (f *Filter) expire() {
+	for len(f.layers) > 1 && f.Entries() > f.maxEntries {
+		f.layers = f.layers[:len(f.layers)-1]
+		f.entries = f.entries[:len(f.entries)-1]
+	}
+}
+
+// MarshalBinary encodes the key, sizing and layers of the filter.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(filterVersion)
+	buf.Write(f.key)
+	header := []uint64{f.numBits, f.numHash, f.maxEntries, uint64(len(f.layers))}
+	if err := binary.Write(&buf, binary.LittleEndian, header); err != nil {
+		return nil, err
+	}
+	for i, layer := range f.layers {
+		binary.Write(&buf, binary.LittleEndian, f.entries[i])
+		buf.Write(encodeLayer(layer))
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary restores a filter encoded by MarshalBinary.
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if version, err := r.ReadByte(); err != nil || version != filterVersion {
+		return errors.New("UnmarshalBinary: unknown filter encoding")
+	}
+	key := make([]byte, KeyLength)
+	header := make([]uint64, 4)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return errors.New("UnmarshalBinary: invalid length")
+	}
+	if err := binary.Read(r, binary.LittleEndian, header); err != nil {
+		return errors.New("UnmarshalBinary: invalid length")
+	}
+	numBits, numHash, numLayers := header[0], header[1], header[3]
+	if numBits == 0 || numHash == 0 {
+		return errors.New("UnmarshalBinary: invalid filter parameters")
+	}
+	// Sizes are bounded by the length of data before they are multiplied.
+	length := uint64(r.Len())
+	if numBits > length*8 {
+		return errors.New("UnmarshalBinary: invalid length")
+	}
+	layerLength := wordsNeeded(numBits) * 8
+	if numLayers == 0 || numLayers > length/(8+layerLength) || length != numLayers*(8+layerLength) {
+		return errors.New("UnmarshalBinary: invalid length")
+	}
+
+	layers := make([]*BitSet, numLayers)
+	entries := make([]uint64, numLayers)
+	for i := range layers {
+		binary.Read(r, binary.LittleEndian, &entries[i])
+		delta := make([]byte, layerLength)
+		r.Read(delta)
+		layer, err := decodeLayer(numBits, delta)
+		if err != nil {
+			return err
+		}
+		layers[i] = layer
+	}
+	*f = Filter{key, numBits, numHash, header[2], layers, entries}
+	return nil
+}
+
+// encodeLayer encodes the words of a layer in little endian order.
+func encodeLayer(b *BitSet) []byte {
+	buf := make([]byte, len(b.data)*8)
+	for i, w := range b.data {
+		binary.LittleEndian.PutUint64(buf[i*8:], w)
+	}
+	return buf
+}
+
+// decodeLayer restores a layer of numBits bits encoded by encodeLayer.
+func decodeLayer(numBits uint64, buf []byte) (*BitSet, error) {
+	words := wordsNeeded(numBits)
+	if uint64(len(buf)) != words*8 {
+		return nil, errors.New("invalid layer size")
+	}
+	data := make([]uint64, words)
+	for i := range data {
+		data[i] = binary.LittleEndian.Uint64(buf[i*8:])
+	}
+	return From(numBits, data), nil
+}
+
+func countBits(b *BitSet) uint64 {
+	var count int
+	for _, w := range b.data {
+		count += bits.OnesCount64(w)
+	}
+	return uint64(count)
+}
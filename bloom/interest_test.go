@@ -0,0 +1,107 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestFilterDelta(t *testing.T) {
+	replica, err := NewFilter(KeyFromSeed(1), 100, 0.01)
+	if err != nil {
+		t.Fatalf("Error creating filter: %v", err)
+	}
+	client, _ := NewFilter(KeyFromSeed(1), 100, 0.01)
+
+	if replica.TestAndSet([]byte("a")) {
+		t.Errorf("Fresh filter holds item")
+	}
+	if !replica.TestAndSet([]byte("a")) {
+		t.Errorf("Filter doesn't see what was previously set")
+	}
+	if replica.Entries() != 1 {
+		t.Errorf("Unexpected entries: %d", replica.Entries())
+	}
+
+	if err := client.Import(replica.Delta()); err != nil {
+		t.Fatalf("Error importing delta: %v", err)
+	}
+	if !client.Test([]byte("a")) {
+		t.Errorf("Imported delta missing item")
+	}
+	if client.Test([]byte("b")) {
+		t.Errorf("Imported delta has extraneous item")
+	}
+	if !CheckLocations(SetLocations(NewBitSet(client.NumBits()), client.Locations([]byte("a"))), replica.Locations([]byte("a"))) {
+		t.Errorf("Filters with the same key hash differently")
+	}
+
+	other, _ := NewFilter(KeyFromSeed(2), 100, 0.01)
+	if err := other.Import(make([]byte, 3)); err == nil {
+		t.Errorf("Imported delta of a different size")
+	}
+}
+
+func TestFilterExpiry(t *testing.T) {
+	f, _ := NewFilter(KeyFromSeed(1), 10, 0.01)
+	for i := 0; i < 8; i++ {
+		f.TestAndSet([]byte(fmt.Sprintf("old %d", i)))
+	}
+	f.Delta()
+	for i := 0; i < 8; i++ {
+		f.TestAndSet([]byte(fmt.Sprintf("new %d", i)))
+	}
+	f.Delta()
+	if f.Entries() != 8 {
+		t.Errorf("Old layer not expired: %d entries", f.Entries())
+	}
+	if !f.Test([]byte("new 0")) {
+		t.Errorf("Recent item expired")
+	}
+}
+
+func TestFilterMarshalBinary(t *testing.T) {
+	f, _ := NewFilter(KeyFromSeed(1), 100, 0.01)
+	f.TestAndSet([]byte("a"))
+	f.Delta()
+	f.TestAndSet([]byte("b"))
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Error marshaling filter: %v", err)
+	}
+	restored := &Filter{}
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Error unmarshaling filter: %v", err)
+	}
+	if !restored.Test([]byte("a")) || !restored.Test([]byte("b")) || restored.Entries() != 2 {
+		t.Errorf("Restored filter differs")
+	}
+	again, _ := restored.MarshalBinary()
+	if !bytes.Equal(data, again) {
+		t.Errorf("Encoding of restored filter differs")
+	}
+	if err := restored.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Errorf("Unmarshaled truncated filter")
+	}
+
+	// The header follows the version and key, as bits, hashes, capacity and
+	// the number of layers.
+	header := 1 + KeyLength
+	noHash := append([]byte{}, data...)
+	binary.LittleEndian.PutUint64(noHash[header+8:], 0)
+	if err := restored.UnmarshalBinary(noHash); err == nil {
+		t.Errorf("Unmarshaled filter without hashes")
+	}
+	layers := append([]byte{}, data...)
+	binary.LittleEndian.PutUint64(layers[header+24:], 1<<62)
+	if err := restored.UnmarshalBinary(layers); err == nil {
+		t.Errorf("Unmarshaled filter with an overflowing number of layers")
+	}
+	huge := append([]byte{}, data...)
+	binary.LittleEndian.PutUint64(huge[header:], 1<<63)
+	if err := restored.UnmarshalBinary(huge); err == nil {
+		t.Errorf("Unmarshaled filter with an overflowing number of bits")
+	}
+}
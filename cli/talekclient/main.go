@@ -73,7 +73,11 @@ func main() {
 	}
 
 	// Client-connected activity below.
-	frontendRPC := common.NewFrontendRPC("RPC", config.FrontendAddr)
+	frontendRPC, err := config.NewFrontendRPC("RPC")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot connect to frontend: %v\n", err)
+		os.Exit(1)
+	}
 
 	client := libtalek.NewClient("Client", *config, frontendRPC)
 	if client == nil {
@@ -37,6 +37,10 @@ func main() {
 	serverConfig := server.ConfigFromFile(*commonPath, config.Config)
 
 	f := server.NewFrontendServer("Talek Frontend", serverConfig, config.TrustDomains)
+	if f == nil {
+		log.Printf("Couldn't start frontend.\n")
+		return
+	}
 	f.Frontend.Verbose = *verbose
 	listener, err := f.Run(*listen)
 	if err != nil {
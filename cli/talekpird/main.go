@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/coreos/etcd/pkg/flags"
+	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/pir"
+	"github.com/spf13/pflag"
+)
+
+// Starts a PIR daemon, computing PIR responses for replicas configured with
+// a backing of "socket:" followed by the socket path. The daemon needs no
+// network access or configuration files, and can run as an unprivileged user
+// sharing only the socket with the replica.
+func main() {
+	log.Println("------------------------")
+	log.Println("--- Talek PIR Daemon ---")
+	log.Println("------------------------")
+
+	// Support setting flags from either command-line arguments or environment variables
+	// command-line arguments take priority
+	socket := pflag.StringP("socket", "s", "pir.socket", "Unix socket to listen on (env TALEK_SOCKET)")
+	backing := pflag.StringP("backing", "b", "cpu.0", "PIR computation method (env TALEK_BACKING)")
+	err := flags.SetPflagsFromEnv(common.EnvPrefix, pflag.CommandLine)
+	if err != nil {
+		log.Printf("Error reading environment variables, %v\n", err)
+		return
+	}
+	pflag.Parse()
+
+	log.Printf("Arguments:\n")
+	log.Printf("socket=%v\n", *socket)
+	log.Printf("backing=%v\n", *backing)
+
+	d, err := pir.NewDaemon("talekpird", *backing)
+	if err != nil {
+		log.Printf("Could not start daemon: %v\n", err)
+		return
+	}
+
+	// Remove a socket left behind by a previous run.
+	if err = os.Remove(*socket); err != nil && !os.IsNotExist(err) {
+		log.Printf("Could not remove %s: %v\n", *socket, err)
+		return
+	}
+	listener, err := net.Listen("unix", *socket)
+	if err != nil {
+		log.Printf("Couldn't listen to socket: %v\n", err)
+		return
+	}
+	if err = os.Chmod(*socket, 0660); err != nil {
+		log.Printf("Couldn't restrict socket permissions: %v\n", err)
+		listener.Close()
+		return
+	}
+	go d.Serve(listener)
+
+	log.Println("Running.")
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+	listener.Close()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/coreos/etcd/pkg/flags"
+	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/server"
+	"github.com/spf13/pflag"
+)
+
+// Starts a shard server holding one bucket range of a distributed trust domain.
+// The replica configuration is shared with the replica acting as head.
+func main() {
+	log.Println("-------------------")
+	log.Println("--- Talek Shard ---")
+	log.Println("-------------------")
+
+	// Support setting flags from either command-line arguments or environment variables
+	// command-line arguments take priority
+	configPath := pflag.StringP("config", "c", "replica.conf", "Talek Replica Configuration (env TALEK_CONFIG)")
+	commonPath := pflag.StringP("common", "f", "common.conf", "Talek Common Configuration (env TALEK_COMMON)")
+	backing := pflag.StringP("backing", "b", "cpu.0", "PIR daemon method (env TALEK_BACKING)")
+	index := pflag.IntP("shard", "s", 0, "Index of this shard in the replica ShardAddresses (env TALEK_SHARD)")
+	listen := pflag.StringP("listen", "l", ":8080", "Listening Address")
+	err := flags.SetPflagsFromEnv(common.EnvPrefix, pflag.CommandLine)
+	if err != nil {
+		log.Printf("Error reading environment variables, %v\n", err)
+		return
+	}
+	pflag.Parse()
+
+	log.Printf("Arguments:\n")
+	log.Printf("config=%v\n", *configPath)
+	log.Printf("backing=%v\n", *backing)
+	log.Printf("shard=%v\n", *index)
+
+	configString, err := ioutil.ReadFile(*configPath)
+	if err != nil {
+		log.Printf("Could not read %s!\n", *configPath)
+		return
+	}
+	commonString, err := ioutil.ReadFile(*commonPath)
+	if err != nil {
+		log.Printf("Could not read %s!\n", *commonPath)
+		return
+	}
+
+	serverConfig := server.Config{
+		Config:           &common.Config{},
+		WriteInterval:    time.Second,
+		ReadInterval:     time.Second,
+		ReadBatch:        8,
+		TrustDomain:      &common.TrustDomainConfig{},
+		TrustDomainIndex: 0,
+	}
+	if err = json.Unmarshal(configString, &serverConfig); err != nil {
+		log.Printf("Could not parse %s: %v\n", *configPath, err)
+		return
+	}
+	if err = json.Unmarshal(commonString, serverConfig.Config); err != nil {
+		log.Printf("Could not parse %s: %v\n", *commonPath, err)
+		return
+	}
+
+	s, err := server.NewShardServer(serverConfig.TrustDomain.Name, *backing, serverConfig, *index)
+	if err != nil {
+		log.Printf("Could not start shard: %v\n", err)
+		return
+	}
+	listener, err := s.Run(*listen)
+	if err != nil {
+		log.Printf("Couldn't listen to shard address: %v\n", err)
+		return
+	}
+
+	log.Println("Running.")
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+	s.Shard.Close()
+	listener.Close()
+}
@@ -34,6 +34,7 @@ func main() {
 	outfile := pflag.String("outfile", "talek.json", "Save configuration to file.")
 	private := pflag.Bool("private", false, "Include private key configuration.")
 	trustdomains := pflag.String("trustdomains", "talek.json", "Comma separated list of trust domains.")
+	frontend := pflag.String("frontend", "", "Trust domain of the frontend, whose certificate is pinned.")
 	ferr := flags.SetPflagsFromEnv(common.EnvPrefix, pflag.CommandLine)
 	if ferr != nil {
 		fmt.Printf("Error reading environment variables, %v\n", ferr)
@@ -53,6 +54,7 @@ func main() {
 			InterestSeed:       int64(rand.Uint64()),
 			MaxLoadFactor:      0.95,
 			LoadFactorStep:     0.05,
+			RequestEncoding:    common.EncodingVector,
 		}
 		sc := server.Config{
 			ReadBatch:     8,
@@ -80,7 +82,7 @@ func main() {
 	}
 
 	if *outputClient {
-		clientUtil(*infile, *outfile, *trustdomains)
+		clientUtil(*infile, *outfile, *trustdomains, *frontend)
 		return
 	}
 
@@ -130,6 +132,19 @@ func main() {
 	tdc.Address = *address
 	tdc.IsValid = true
 	sc.TrustDomainIndex = *index
+	if *private && len(tdc.Certificate) == 0 {
+		// Configurations from before TLS support
+		if err = tdc.GenerateCertificate(); err != nil {
+			fmt.Printf("Could not generate certificate: %v\n", err)
+			return
+		}
+	}
+	if len(*frontend) > 0 {
+		if sc.Frontend, err = readTrustDomain(*frontend); err != nil {
+			fmt.Printf("Could not read frontend: %v\n", err)
+			return
+		}
+	}
 
 	var tdb []byte
 	if *private {
@@ -199,8 +214,21 @@ func main() {
 	}
 }
 
+// readTrustDomain loads a trust domain configuration from a file.
+func readTrustDomain(path string) (*common.TrustDomainConfig, error) {
+	tdString, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	td := new(common.TrustDomainConfig)
+	if err := json.Unmarshal(tdString, td); err != nil {
+		return nil, err
+	}
+	return td, nil
+}
+
 // update/create client configuration with an explicit set of server trust domains.
-func clientUtil(infile string, outfile string, trustfiles string) {
+func clientUtil(infile string, outfile string, trustfiles string, frontend string) {
 	domainPaths := strings.Split(trustfiles, ",")
 	trustDomains := make([]*common.TrustDomainConfig, len(domainPaths))
 	for i, path := range domainPaths {
@@ -233,6 +261,15 @@ func clientUtil(infile string, outfile string, trustfiles string) {
 	}
 
 	clientconf.TrustDomains = trustDomains
+	if len(frontend) > 0 {
+		fe, err := readTrustDomain(frontend)
+		if err != nil {
+			fmt.Printf("Could not read frontend: %v\n", err)
+			return
+		}
+		clientconf.FrontendAddr = fe.Address
+		clientconf.FrontendCertificate = fe.Certificate
+	}
 	bytes, err := json.MarshalIndent(clientconf, "", "  ")
 	if err != nil {
 		fmt.Printf("Failed to export config: %v\n", err)
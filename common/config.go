@@ -26,12 +26,51 @@ type Config struct {
 	InterestSeed int64
 	// Max fraction of DB capacity that can store messages
 	MaxLoadFactor float64
+	// How many candidate buckets does each item have? More choices allow a
+	// higher MaxLoadFactor, at the cost of a read per choice in each poll.
+	// 0 means 2, and at most MaxBucketChoices are supported.
+	BucketChoices uint64
+	// How many buckets of items that could not be placed in the cuckoo table
+	// does a replica stash? Every read reply carries the stash after the bucket.
+	StashBuckets uint64
+	// How clients encode the PIR request for each trust domain in reads.
+	// One of the Encoding constants. Empty means EncodingVector.
+	RequestEncoding string
 
 	/** @todo remove below **/
 	// What fraction of items should be removed from the DB when items are removed?
 	LoadFactorStep float64
 }
 
+const (
+	// EncodingVector sends a full request vector of NumBuckets bits to each trust domain.
+	EncodingVector = "vector"
+	// EncodingDPF sends a distributed point function key to each of exactly two
+	// trust domains, which they expand into request vectors.
+	EncodingDPF = "dpf"
+	// EncodingSeed sends a full request vector to the first trust domain, and a
+	// seed to each other trust domain, which they expand with drbg.Overlay.
+	EncodingSeed = "seed"
+	// EncodingLWE sends an lwe query to a single trust domain, which must use
+	// the lwe PIR backing. Clients decode responses with the hint of the
+	// database, fetched each write interval.
+	EncodingLWE = "lwe"
+)
+
+// MaxBucketChoices is the largest supported BucketChoices.
+const MaxBucketChoices = 6
+
+// Choices is the number of candidate buckets of each item.
+func (cc *Config) Choices() int {
+	if cc.BucketChoices < 2 {
+		return 2
+	}
+	if cc.BucketChoices > MaxBucketChoices {
+		return MaxBucketChoices
+	}
+	return int(cc.BucketChoices)
+}
+
 // WindowSize is a computed property of Config for how many items are available at a time
 func (cc *Config) WindowSize() uint64 {
 	return uint64(float64(cc.NumBuckets*cc.BucketDepth) * cc.MaxLoadFactor)
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+
+	"github.com/agl/ed25519"
+)
+
+// FrontendAuth authenticates a request of the frontend to the replicas. It
+// signs the request with the sign key of the trust domain of the frontend.
+// Replicas accept each Sequence number once.
+type FrontendAuth struct {
+	Sequence  uint64
+	Signature [ed25519.SignatureSize]byte
+}
+
+// authDigest hashes the fields of a request in a canonical form, independent
+// of the codec it was sent in.
+type authDigest struct {
+	hash.Hash
+}
+
+func newAuthDigest(kind string, seq uint64) authDigest {
+	d := authDigest{sha256.New()}
+	d.bytes([]byte("talek frontend " + kind))
+	d.uint64(seq)
+	return d
+}
+
+func (d authDigest) uint64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	d.Write(b[:])
+}
+
+func (d authDigest) bytes(b []byte) {
+	d.uint64(uint64(len(b)))
+	d.Write(b)
+}
+
+func (d authDigest) bool(v bool) {
+	if v {
+		d.Write([]byte{1})
+	} else {
+		d.Write([]byte{0})
+	}
+}
+
+func (d authDigest) uint64s(v []uint64) {
+	d.uint64(uint64(len(v)))
+	for _, x := range v {
+		d.uint64(x)
+	}
+}
+
+// CanSign is true if the configuration holds the private sign key of the
+// trust domain.
+func (td *TrustDomainConfig) CanSign() bool {
+	return td.signPrivateKey != [64]byte{}
+}
+
+func (td *TrustDomainConfig) sign(d authDigest) [ed25519.SignatureSize]byte {
+	return *ed25519.Sign(&td.signPrivateKey, d.Sum(nil))
+}
+
+func (td *TrustDomainConfig) verify(d authDigest, sig *[ed25519.SignatureSize]byte) bool {
+	return ed25519.Verify(&td.SignPublicKey, d.Sum(nil), sig)
+}
+
+func (a *ReplicaWriteArgs) digest(seq uint64) authDigest {
+	d := newAuthDigest("write", seq)
+	d.uint64(a.Bucket1)
+	d.uint64(a.Bucket2)
+	d.uint64s(a.Buckets)
+	d.bytes(a.Data)
+	d.bytes(a.InterestVector)
+	d.uint64(a.GlobalSeqNo)
+	d.bool(a.EpochFlag)
+	d.bool(a.InterestFlag)
+	return d
+}
+
+// Sign authenticates the write as sent by the frontend of trust domain td,
+// with sequence number seq.
+func (a *ReplicaWriteArgs) Sign(td *TrustDomainConfig, seq uint64) {
+	a.Auth.Sequence = seq
+	a.Auth.Signature = td.sign(a.digest(seq))
+}
+
+// Verify checks that the write was signed by the frontend of trust domain td.
+func (a *ReplicaWriteArgs) Verify(td *TrustDomainConfig) bool {
+	return td.verify(a.digest(a.Auth.Sequence), &a.Auth.Signature)
+}
+
+func (b *BatchReadRequest) digest(seq uint64) authDigest {
+	d := newAuthDigest("read", seq)
+	d.uint64(uint64(len(b.Args)))
+	for _, arg := range b.Args {
+		d.uint64(uint64(arg.Version))
+		d.Write(arg.ClientKey[:])
+		d.Write(arg.Nonce[:])
+		d.uint64(uint64(len(arg.PirArgs)))
+		for _, pir := range arg.PirArgs {
+			d.bytes(pir)
+		}
+	}
+	d.uint64(b.SeqNoRange.Start)
+	d.uint64(b.SeqNoRange.End)
+	d.uint64s(b.SeqNoRange.Aborted)
+	return d
+}
+
+// Sign authenticates the batch as sent by the frontend of trust domain td,
+// with sequence number seq.
+func (b *BatchReadRequest) Sign(td *TrustDomainConfig, seq uint64) {
+	b.Auth.Sequence = seq
+	b.Auth.Signature = td.sign(b.digest(seq))
+}
+
+// Verify checks that the batch was signed by the frontend of trust domain td.
+func (b *BatchReadRequest) Verify(td *TrustDomainConfig) bool {
+	return td.verify(b.digest(b.Auth.Sequence), &b.Auth.Signature)
+}
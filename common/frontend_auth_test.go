@@ -0,0 +1,58 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestReplicaWriteSignature(t *testing.T) {
+	frontend := NewTrustDomainConfig("frontend", "localhost:8999", true, false)
+	other := NewTrustDomainConfig("other", "localhost:8999", true, false)
+	args := &ReplicaWriteArgs{WriteArgs: WriteArgs{Bucket1: 1, Bucket2: 2, Data: []byte("data"), GlobalSeqNo: 9}}
+	args.Sign(frontend, 42)
+	if !args.Verify(frontend) {
+		t.Fatalf("Signed write failed to verify")
+	}
+	if args.Verify(other) {
+		t.Errorf("Write verified with another trust domain")
+	}
+
+	// Signatures are independent of the codec.
+	message, _ := json.Marshal(args)
+	fromJSON := &ReplicaWriteArgs{}
+	json.Unmarshal(message, fromJSON)
+	if !fromJSON.Verify(frontend) {
+		t.Errorf("Write failed to verify after JSON encoding")
+	}
+
+	modified := *args
+	modified.EpochFlag = true
+	if modified.Verify(frontend) {
+		t.Errorf("Modified write verified")
+	}
+	modified = *args
+	modified.Auth.Sequence++
+	if modified.Verify(frontend) {
+		t.Errorf("Write verified with another sequence number")
+	}
+}
+
+func TestBatchReadSignature(t *testing.T) {
+	frontend := NewTrustDomainConfig("frontend", "localhost:8999", true, false)
+	batch := testBatch(2)
+	batch.Sign(frontend, 7)
+	if !batch.Verify(frontend) {
+		t.Fatalf("Signed batch failed to verify")
+	}
+	batch.Args[1].PirArgs[0][0] ^= 1
+	if batch.Verify(frontend) {
+		t.Errorf("Modified batch verified")
+	}
+
+	public := new(TrustDomainConfig)
+	message, _ := json.Marshal(frontend)
+	json.Unmarshal(message, public)
+	if public.CanSign() || !frontend.CanSign() {
+		t.Errorf("CanSign does not reflect the private key")
+	}
+}
@@ -1,5 +1,9 @@
 package common
 
+import (
+	"context"
+)
+
 // FrontendInterface is the interface between libtalek and the frontend
 type FrontendInterface interface {
 	GetName(args *interface{}, reply *string) error
@@ -7,4 +11,20 @@ type FrontendInterface interface {
 	Write(args *WriteArgs, reply *WriteReply) error
 	Read(args *EncodedReadArgs, reply *ReadReply) error
 	GetUpdates(args *GetUpdatesArgs, reply *GetUpdatesReply) error
+	GetHint(args *GetHintArgs, reply *GetHintReply) error
+	GetTokenKey(args *GetTokenKeyArgs, reply *GetTokenKeyReply) error
+	IssueTokens(args *IssueTokensArgs, reply *IssueTokensReply) error
+}
+
+// FrontendContextInterface is a FrontendInterface whose calls can also be
+// bounded by a context, ending the wait for the frontend with the context.
+type FrontendContextInterface interface {
+	FrontendInterface
+	GetConfigContext(ctx context.Context, args *interface{}, reply *Config) error
+	WriteContext(ctx context.Context, args *WriteArgs, reply *WriteReply) error
+	ReadContext(ctx context.Context, args *EncodedReadArgs, reply *ReadReply) error
+	GetUpdatesContext(ctx context.Context, args *GetUpdatesArgs, reply *GetUpdatesReply) error
+	GetHintContext(ctx context.Context, args *GetHintArgs, reply *GetHintReply) error
+	GetTokenKeyContext(ctx context.Context, args *GetTokenKeyArgs, reply *GetTokenKeyReply) error
+	IssueTokensContext(ctx context.Context, args *IssueTokensArgs, reply *IssueTokensReply) error
 }
@@ -15,8 +15,10 @@ type Error string
 type WriteArgs struct {
 	Bucket1        uint64
 	Bucket2        uint64
+	Buckets        []uint64 // Further candidate buckets, for Config.BucketChoices above 2
 	Data           []byte
-	InterestVector []byte // sha256 hash - expect 32bytes
+	InterestVector []byte      // sha256 hash - expect 32bytes
+	Token          *WriteToken // Admits the write, if the frontend requires tokens
 	//Internal
 	GlobalSeqNo uint64
 	ReplyChan   chan *WriteReply `json:"-"`
@@ -24,23 +26,43 @@ type WriteArgs struct {
 
 // WriteReply contain return status of writes
 type WriteReply struct {
-	Err         string
-	GlobalSeqNo uint64
+	Err           string
+	GlobalSeqNo   uint64
+	TokenRejected bool // The write was not admitted by its token
 }
 
 // PirArgs have the actual PIR for shards to perform.
+// Depending on the RequestEncoding of the Config, the request is either a
+// RequestVector, or a DPFKey or RequestSeed the trust domain expands into one.
+// For EncodingLWE, the RequestVector holds an lwe query.
 type PirArgs struct {
 	RequestVector []byte
 	PadSeed       []byte
+	DPFKey        []byte
+	RequestSeed   []byte
 }
 
 // ReadArgs have the ReadArgs for each trust domain in unencrypted form.
 type ReadArgs struct {
 	TD []PirArgs
+	// Client state to decode the response to an EncodingLWE read. Never sent.
+	Secret []byte `json:"-"`
+	Hint   []byte `json:"-"`
 }
 
+// Versions of the read protocol, identifying the fields clients may set in PirArgs.
+const (
+	// ReadVersionVector requests carry a RequestVector or DPFKey for each trust domain.
+	ReadVersionVector uint8 = 0
+	// ReadVersionSeed requests may also carry a RequestSeed in place of a RequestVector.
+	ReadVersionSeed uint8 = 1
+	// ReadVersionLatest is the newest version of the read protocol understood.
+	ReadVersionLatest = ReadVersionSeed
+)
+
 // EncodedReadArgs are a trust-domain-encrypted form of ReadArgs
 type EncodedReadArgs struct {
+	Version   uint8
 	ClientKey [32]byte
 	Nonce     [24]byte
 	PirArgs   [][]byte //An encrypted PirArgs for each trust domain
@@ -52,6 +74,7 @@ type ReadReply struct {
 	Data           []byte
 	GlobalSeqNo    Range
 	LastInterestSN uint64
+	Epoch          uint64 // Of the database read, to match its GetHintReply
 }
 
 // Combine xors two partial read replies together
@@ -85,6 +108,21 @@ type GetUpdatesArgs struct {
 // GetUpdatesReply has the interestvector response for a getupdates call
 type GetUpdatesReply struct {
 	Err            string
-	InterestVector []byte
+	InterestVector []byte // Encoded by bloom.MarshalVector
 	Signature      [][32]byte
 }
+
+// GetHintArgs asks for the hint of the current database. The hint is left
+// out of the reply if the database is still in Epoch, of the hint the client
+// already holds.
+type GetHintArgs struct {
+	Epoch uint64
+}
+
+// GetHintReply has the hint of the current database, which clients need to
+// decode responses to EncodingLWE reads.
+type GetHintReply struct {
+	Err   string
+	Hint  []byte
+	Epoch uint64 // Of the database, which responses are decoded with the hint of
+}
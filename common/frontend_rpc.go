@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"log"
 	"os"
 )
@@ -11,6 +12,8 @@ type FrontendRPC struct {
 	name         string
 	address      string
 	methodPrefix string
+	codec        Codec
+	transport    *Transport
 }
 
 // NewFrontendRPC instantiates a LeaderRPC stub
@@ -20,6 +23,8 @@ func NewFrontendRPC(name string, address string) *FrontendRPC {
 	f.name = name
 	f.address = address
 	f.methodPrefix = "Frontend"
+	f.codec = GobCodec
+	f.transport = DefaultTransport
 
 	return f
 }
@@ -31,27 +36,96 @@ func (f *FrontendRPC) GetName(_ *interface{}, reply *string) error {
 }
 
 // GetConfig tells the client about current config.
-func (f *FrontendRPC) GetConfig(_ *interface{}, reply *Config) error {
+func (f *FrontendRPC) GetConfig(args *interface{}, reply *Config) error {
+	return f.GetConfigContext(context.Background(), args, reply)
+}
+
+// GetConfigContext is GetConfig bounded by ctx. It is retried on failures to
+// reach the frontend.
+func (f *FrontendRPC) GetConfigContext(ctx context.Context, _ *interface{}, reply *Config) error {
 	var args interface{}
-	err := RPCCall(f.address, f.methodPrefix+".GetConfig", &args, reply)
+	err := f.transport.CallIdempotent(ctx, f.codec, f.address, f.methodPrefix+".GetConfig", &args, reply)
 	return err
 }
 
 func (f *FrontendRPC) Write(args *WriteArgs, reply *WriteReply) error {
+	return f.WriteContext(context.Background(), args, reply)
+}
+
+// WriteContext is Write bounded by ctx.
+func (f *FrontendRPC) WriteContext(ctx context.Context, args *WriteArgs, reply *WriteReply) error {
 	//l.log.Printf("Write: enter\n")
-	err := RPCCall(f.address, f.methodPrefix+".Write", args, reply)
+	err := f.transport.Call(ctx, f.codec, f.address, f.methodPrefix+".Write", args, reply)
 	return err
 }
 
 func (f *FrontendRPC) Read(args *EncodedReadArgs, reply *ReadReply) error {
+	return f.ReadContext(context.Background(), args, reply)
+}
+
+// ReadContext is Read bounded by ctx.
+func (f *FrontendRPC) ReadContext(ctx context.Context, args *EncodedReadArgs, reply *ReadReply) error {
 	//l.log.Printf("Read: enter\n")
-	err := RPCCall(f.address, f.methodPrefix+".Read", args, reply)
+	err := f.transport.Call(ctx, f.codec, f.address, f.methodPrefix+".Read", args, reply)
 	return err
 }
 
 // GetUpdates provides the global interest vector.
 func (f *FrontendRPC) GetUpdates(args *GetUpdatesArgs, reply *GetUpdatesReply) error {
+	return f.GetUpdatesContext(context.Background(), args, reply)
+}
+
+// GetUpdatesContext is GetUpdates bounded by ctx. It is retried on failures to
+// reach the frontend.
+func (f *FrontendRPC) GetUpdatesContext(ctx context.Context, args *GetUpdatesArgs, reply *GetUpdatesReply) error {
 	//l.log.Printf("GetUpdates: enter\n")
-	err := RPCCall(f.address, f.methodPrefix+".GetUpdates", args, reply)
+	err := f.transport.CallIdempotent(ctx, f.codec, f.address, f.methodPrefix+".GetUpdates", args, reply)
 	return err
 }
+
+// GetHint provides the hint of the current database.
+func (f *FrontendRPC) GetHint(args *GetHintArgs, reply *GetHintReply) error {
+	return f.GetHintContext(context.Background(), args, reply)
+}
+
+// GetHintContext is GetHint bounded by ctx. It is retried on failures to reach
+// the frontend.
+func (f *FrontendRPC) GetHintContext(ctx context.Context, args *GetHintArgs, reply *GetHintReply) error {
+	err := f.transport.CallIdempotent(ctx, f.codec, f.address, f.methodPrefix+".GetHint", args, reply)
+	return err
+}
+
+// GetTokenKey describes the write tokens of the current epoch.
+func (f *FrontendRPC) GetTokenKey(args *GetTokenKeyArgs, reply *GetTokenKeyReply) error {
+	return f.GetTokenKeyContext(context.Background(), args, reply)
+}
+
+// GetTokenKeyContext is GetTokenKey bounded by ctx. It is retried on failures
+// to reach the frontend.
+func (f *FrontendRPC) GetTokenKeyContext(ctx context.Context, args *GetTokenKeyArgs, reply *GetTokenKeyReply) error {
+	err := f.transport.CallIdempotent(ctx, f.codec, f.address, f.methodPrefix+".GetTokenKey", args, reply)
+	return err
+}
+
+// IssueTokens requests signatures of blinded write tokens.
+func (f *FrontendRPC) IssueTokens(args *IssueTokensArgs, reply *IssueTokensReply) error {
+	return f.IssueTokensContext(context.Background(), args, reply)
+}
+
+// IssueTokensContext is IssueTokens bounded by ctx. It is not retried, as the
+// frontend counts the tokens it issued.
+func (f *FrontendRPC) IssueTokensContext(ctx context.Context, args *IssueTokensArgs, reply *IssueTokensReply) error {
+	err := f.transport.Call(ctx, f.codec, f.address, "Issuer.IssueTokens", args, reply)
+	return err
+}
+
+// SetCodec sets the codec of calls to the frontend, GobCodec by default.
+func (f *FrontendRPC) SetCodec(codec Codec) {
+	f.codec = codec
+}
+
+// SetTransport sets the transport of calls to the frontend, DefaultTransport
+// by default.
+func (f *FrontendRPC) SetTransport(transport *Transport) {
+	f.transport = transport
+}
@@ -10,7 +10,9 @@ import (
 	"crypto/rand"
 	"encoding/gob"
 	"errors"
+	"fmt"
 
+	"github.com/privacylab/talek/drbg"
 	"golang.org/x/crypto/nacl/box"
 )
 
@@ -27,6 +29,12 @@ func (r *ReadArgs) Encode(trustDomains []*TrustDomainConfig) (out EncodedReadArg
 	}
 
 	// Allocate memory
+	out.Version = ReadVersionVector
+	for i := 0; i < len(r.TD); i++ {
+		if len(r.TD[i].RequestSeed) > 0 {
+			out.Version = ReadVersionSeed
+		}
+	}
 	copy(out.ClientKey[:], pubKey[:])
 	copy(out.Nonce[:], nonce)
 
@@ -51,8 +59,18 @@ func (r *ReadArgs) Encode(trustDomains []*TrustDomainConfig) (out EncodedReadArg
 func (r *ReadArgs) Bucket() int {
 	finalvec := make([]byte, len(r.TD[0].RequestVector))
 	for i := 0; i < len(r.TD); i++ {
+		vec := r.TD[i].RequestVector
+		if len(r.TD[i].RequestSeed) > 0 {
+			vec = make([]byte, len(finalvec))
+			if drbg.Overlay(r.TD[i].RequestSeed, vec) != nil {
+				return -1
+			}
+		}
+		if len(vec) != len(finalvec) {
+			return -1
+		}
 		for j := 0; j < len(finalvec); j++ {
-			finalvec[j] ^= r.TD[i].RequestVector[j]
+			finalvec[j] ^= vec[j]
 		}
 	}
 
@@ -72,6 +90,10 @@ func (r *ReadArgs) Bucket() int {
 
 // Decode decrypts a specific trust domain of encoded args to recover the pad and request vector.
 func (r *EncodedReadArgs) Decode(id int, trustDomain *TrustDomainConfig) (out PirArgs, err error) {
+	if r.Version > ReadVersionLatest {
+		err = fmt.Errorf("unsupported read protocol version %d", r.Version)
+		return
+	}
 	if len(r.PirArgs[id]) < box.Overhead {
 		err = errors.New("Attempted Decoding of invalid Trust Domain")
 		return
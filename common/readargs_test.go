@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"testing"
 
+	"github.com/privacylab/talek/drbg"
 	"golang.org/x/crypto/nacl/box"
 )
 
@@ -39,3 +40,43 @@ func TestEncodeDecode(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodeDecodeSeeded(t *testing.T) {
+	msg := &ReadArgs{}
+	msg.TD = make([]PirArgs, 2)
+	config := make([]*TrustDomainConfig, 2)
+
+	for i := 0; i < 2; i++ {
+		config[i] = &TrustDomainConfig{}
+		msg.TD[i].PadSeed = make([]byte, 4)
+		serverPub, serverPri, _ := box.GenerateKey(rand.Reader)
+		copy(config[i].PublicKey[:], serverPub[:])
+		copy(config[i].privateKey[:], serverPri[:])
+	}
+	seed, _ := drbg.NewSeed()
+	msg.TD[1].RequestSeed, _ = seed.MarshalBinary()
+	msg.TD[0].RequestVector = make([]byte, 32)
+	drbg.Overlay(msg.TD[1].RequestSeed, msg.TD[0].RequestVector)
+	msg.TD[0].RequestVector[2] ^= 1 << 3
+
+	if msg.Bucket() != 19 {
+		t.Fatalf("Seeded request should be for bucket 19, not %d", msg.Bucket())
+	}
+
+	encodedArgs, err := msg.Encode(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encodedArgs.Version != ReadVersionSeed {
+		t.Fatalf("Seeded request encoded with version %d", encodedArgs.Version)
+	}
+	pir, err := encodedArgs.Decode(1, config[1])
+	if err != nil || !bytes.Equal(pir.RequestSeed, msg.TD[1].RequestSeed) {
+		t.Fatalf("Decryption of request seed failed: %v", err)
+	}
+
+	encodedArgs.Version = ReadVersionLatest + 1
+	if _, err = encodedArgs.Decode(1, config[1]); err == nil {
+		t.Fatalf("Decoding should fail for unknown protocol versions")
+	}
+}
@@ -1,8 +1,47 @@
 package common
 
+import (
+	"context"
+)
+
 // ReplicaInterface dictates the methods used for server-server communication
 // in the Talek system
 type ReplicaInterface interface {
 	Write(args *ReplicaWriteArgs, reply *ReplicaWriteReply) error
 	BatchRead(args *BatchReadRequest, reply *BatchReadReply) error
+	GetHint(args *GetHintArgs, reply *GetHintReply) error
+}
+
+// ReplicaContextInterface is a ReplicaInterface whose calls can also be
+// bounded by a context, ending the wait for the replica with the context.
+type ReplicaContextInterface interface {
+	ReplicaInterface
+	WriteContext(ctx context.Context, args *ReplicaWriteArgs, reply *ReplicaWriteReply) error
+	BatchReadContext(ctx context.Context, args *BatchReadRequest, reply *BatchReadReply) error
+	GetHintContext(ctx context.Context, args *GetHintArgs, reply *GetHintReply) error
+}
+
+// ReplicaWithContext returns r if it is a ReplicaContextInterface. Otherwise,
+// as for replicas in the same process, calls ignore their context.
+func ReplicaWithContext(r ReplicaInterface) ReplicaContextInterface {
+	if rc, ok := r.(ReplicaContextInterface); ok {
+		return rc
+	}
+	return replicaWithoutContext{r}
+}
+
+type replicaWithoutContext struct {
+	ReplicaInterface
+}
+
+func (r replicaWithoutContext) WriteContext(_ context.Context, args *ReplicaWriteArgs, reply *ReplicaWriteReply) error {
+	return r.Write(args, reply)
+}
+
+func (r replicaWithoutContext) BatchReadContext(_ context.Context, args *BatchReadRequest, reply *BatchReadReply) error {
+	return r.BatchRead(args, reply)
+}
+
+func (r replicaWithoutContext) GetHintContext(_ context.Context, args *GetHintArgs, reply *GetHintReply) error {
+	return r.GetHint(args, reply)
 }
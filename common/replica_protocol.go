@@ -5,6 +5,7 @@ type ReplicaWriteArgs struct {
 	WriteArgs
 	EpochFlag    bool
 	InterestFlag bool
+	Auth         FrontendAuth
 }
 
 // ReplicaWriteReply contain return status of writes
@@ -19,6 +20,7 @@ type ReplicaWriteReply struct {
 type BatchReadRequest struct {
 	Args       []EncodedReadArgs // Set of Read requests
 	SeqNoRange Range
+	Auth       FrontendAuth
 	ReplyChan  chan *BatchReadReply `json:"-"`
 }
 
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"log"
 	"os"
 )
@@ -11,6 +12,8 @@ type ReplicaRPC struct {
 	name         string
 	address      string
 	methodPrefix string
+	codec        Codec
+	transport    *Transport
 }
 
 // NewReplicaRPC creates a new ReplicaRPC
@@ -25,19 +28,54 @@ func NewReplicaRPC(name string, config *TrustDomainConfig) *ReplicaRPC {
 		return nil
 	}
 	r.methodPrefix = "Replica"
+	r.codec = GobCodec
+	r.transport = DefaultTransport
 
 	return r
 }
 
 func (r *ReplicaRPC) Write(args *ReplicaWriteArgs, reply *ReplicaWriteReply) error {
+	return r.WriteContext(context.Background(), args, reply)
+}
+
+// WriteContext is Write bounded by ctx.
+func (r *ReplicaRPC) WriteContext(ctx context.Context, args *ReplicaWriteArgs, reply *ReplicaWriteReply) error {
 	//f.log.Printf("Write: enter\n")
-	err := RPCCall(r.address, r.methodPrefix+".Write", args, reply)
+	err := r.transport.Call(ctx, r.codec, r.address, r.methodPrefix+".Write", args, reply)
 	return err
 }
 
 // BatchRead performs a set of PIR reads.
 func (r *ReplicaRPC) BatchRead(args *BatchReadRequest, reply *BatchReadReply) error {
+	return r.BatchReadContext(context.Background(), args, reply)
+}
+
+// BatchReadContext is BatchRead bounded by ctx.
+func (r *ReplicaRPC) BatchReadContext(ctx context.Context, args *BatchReadRequest, reply *BatchReadReply) error {
 	//f.log.Printf("BatchRead: enter\n")
-	err := RPCCall(r.address, r.methodPrefix+".BatchRead", args, reply)
+	err := r.transport.Call(ctx, r.codec, r.address, r.methodPrefix+".BatchRead", args, reply)
 	return err
 }
+
+// GetHint provides the hint of the current database.
+func (r *ReplicaRPC) GetHint(args *GetHintArgs, reply *GetHintReply) error {
+	return r.GetHintContext(context.Background(), args, reply)
+}
+
+// GetHintContext is GetHint bounded by ctx. It is retried on failures to reach
+// the replica.
+func (r *ReplicaRPC) GetHintContext(ctx context.Context, args *GetHintArgs, reply *GetHintReply) error {
+	err := r.transport.CallIdempotent(ctx, r.codec, r.address, r.methodPrefix+".GetHint", args, reply)
+	return err
+}
+
+// SetCodec sets the codec of calls to the replica, GobCodec by default.
+func (r *ReplicaRPC) SetCodec(codec Codec) {
+	r.codec = codec
+}
+
+// SetTransport sets the transport of calls to the replica, DefaultTransport
+// by default.
+func (r *ReplicaRPC) SetTransport(transport *Transport) {
+	r.transport = transport
+}
@@ -0,0 +1,153 @@
+package common
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/rpc"
+	"github.com/gorilla/rpc/json"
+)
+
+// Content types identifying the codec of an RPC request.
+const (
+	JSONContentType = "application/json"
+	GobContentType  = "application/x-gob"
+)
+
+// Codec encodes the client side of RPC calls. Servers accept requests in
+// either codec, so clients may move to GobCodec independently of them.
+type Codec interface {
+	// ContentType identifies the codec to servers.
+	ContentType() string
+	EncodeRequest(w io.Writer, method string, args interface{}) error
+	DecodeResponse(r io.Reader, reply interface{}) error
+}
+
+// JSONCodec is the JSON-RPC encoding of calls. Byte slices are sent as base64.
+var JSONCodec Codec = jsonCodec{}
+
+// GobCodec is a binary encoding of calls, sending byte slices unencoded.
+var GobCodec Codec = gobCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return JSONContentType
+}
+
+func (jsonCodec) EncodeRequest(w io.Writer, method string, args interface{}) error {
+	message, err := json.EncodeClientRequest(method, args)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(message)
+	return err
+}
+
+func (jsonCodec) DecodeResponse(r io.Reader, reply interface{}) error {
+	return json.DecodeClientResponse(r, reply)
+}
+
+// gobRequest precedes the arguments of a call in the gob codec.
+type gobRequest struct {
+	Method string
+}
+
+// gobResponse precedes the reply to a call in the gob codec. The reply
+// follows only if Err is empty.
+type gobResponse struct {
+	Err string
+}
+
+// hasArgs is false for the *interface{} arguments of calls without any, which
+// gob cannot encode. They are left out of requests.
+func hasArgs(args interface{}) bool {
+	_, empty := args.(*interface{})
+	return !empty
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string {
+	return GobContentType
+}
+
+func (gobCodec) EncodeRequest(w io.Writer, method string, args interface{}) error {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(&gobRequest{method}); err != nil {
+		return err
+	}
+	if !hasArgs(args) {
+		return nil
+	}
+	return enc.Encode(args)
+}
+
+func (gobCodec) DecodeResponse(r io.Reader, reply interface{}) error {
+	dec := gob.NewDecoder(r)
+	res := gobResponse{}
+	if err := dec.Decode(&res); err != nil {
+		return err
+	}
+	if res.Err != "" {
+		return errors.New(res.Err)
+	}
+	return dec.Decode(reply)
+}
+
+// GobServerCodec is the server side of GobCodec for gorilla RPC servers.
+type GobServerCodec struct{}
+
+// NewGobServerCodec creates a GobServerCodec.
+func NewGobServerCodec() *GobServerCodec {
+	return &GobServerCodec{}
+}
+
+// NewRequest starts decoding a request.
+func (c *GobServerCodec) NewRequest(r *http.Request) rpc.CodecRequest {
+	req := &gobCodecRequest{dec: gob.NewDecoder(r.Body), body: r.Body}
+	req.err = req.dec.Decode(&req.request)
+	return req
+}
+
+type gobCodecRequest struct {
+	dec     *gob.Decoder
+	body    io.ReadCloser
+	request gobRequest
+	err     error
+}
+
+func (c *gobCodecRequest) Method() (string, error) {
+	return c.request.Method, c.err
+}
+
+func (c *gobCodecRequest) ReadRequest(args interface{}) error {
+	if c.err == nil && hasArgs(args) {
+		c.err = c.dec.Decode(args)
+	}
+	c.body.Close()
+	return c.err
+}
+
+func (c *gobCodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}, methodErr error) error {
+	if c.err != nil {
+		return c.err
+	}
+	w.Header().Set("Content-Type", GobContentType)
+	enc := gob.NewEncoder(w)
+	if methodErr != nil {
+		return enc.Encode(&gobResponse{Err: methodErr.Error()})
+	}
+	if err := enc.Encode(&gobResponse{}); err != nil {
+		return err
+	}
+	return enc.Encode(reply)
+}
+
+// RegisterCodecs registers the server side of JSONCodec and GobCodec.
+func RegisterCodecs(s *rpc.Server) {
+	s.RegisterCodec(json.NewCodec(), JSONContentType)
+	s.RegisterCodec(NewGobServerCodec(), GobContentType)
+}
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/rpc"
+)
+
+var codecs = map[string]Codec{"json": JSONCodec, "gob": GobCodec}
+
+type testService struct{}
+
+func (testService) Echo(args *BatchReadRequest, reply *BatchReadReply) error {
+	for _, a := range args.Args {
+		reply.Replies = append(reply.Replies, ReadReply{Data: a.PirArgs[0], GlobalSeqNo: args.SeqNoRange})
+	}
+	return nil
+}
+
+func (testService) GetConfig(args *interface{}, reply *Config) error {
+	reply.NumBuckets = 42
+	return nil
+}
+
+func (testService) Fail(args *GetHintArgs, reply *GetHintReply) error {
+	return errors.New("failed")
+}
+
+func newTestHandler() *rpc.Server {
+	s := rpc.NewServer()
+	RegisterCodecs(s)
+	s.RegisterTCPService(testService{}, "Test")
+	return s
+}
+
+func newTestServer() *httptest.Server {
+	return httptest.NewServer(newTestHandler())
+}
+
+// testBatch is a batch of n reads of a 4KB payload.
+func testBatch(n int) *BatchReadRequest {
+	args := &BatchReadRequest{SeqNoRange: Range{Start: 1, End: 9, Aborted: []uint64{3}}}
+	for i := 0; i < n; i++ {
+		data := bytes.Repeat([]byte{byte(i), 0xff}, 2048)
+		args.Args = append(args.Args, EncodedReadArgs{PirArgs: [][]byte{data}})
+	}
+	return args
+}
+
+func TestRPCCodecs(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	for name, codec := range codecs {
+		args := testBatch(3)
+		reply := &BatchReadReply{}
+		if err := RPCCallCodec(codec, server.URL, "Test.Echo", args, reply); err != nil {
+			t.Fatalf("%s call failed: %v", name, err)
+		}
+		if len(reply.Replies) != 3 || !bytes.Equal(reply.Replies[2].Data, args.Args[2].PirArgs[0]) ||
+			!reply.Replies[0].GlobalSeqNo.Equals(args.SeqNoRange) {
+			t.Errorf("%s reply differs from request", name)
+		}
+
+		var noArgs interface{}
+		conf := &Config{}
+		if err := RPCCallCodec(codec, server.URL, "Test.GetConfig", &noArgs, conf); err != nil || conf.NumBuckets != 42 {
+			t.Errorf("%s call without arguments failed: %v", name, err)
+		}
+
+		if err := RPCCallCodec(codec, server.URL, "Test.Fail", &GetHintArgs{}, &GetHintReply{}); err == nil || err.Error() != "failed" {
+			t.Errorf("%s call returned wrong error: %v", name, err)
+		}
+		if err := RPCCallCodec(codec, server.URL, "Test.Missing", &GetHintArgs{}, &GetHintReply{}); err == nil {
+			t.Errorf("%s call of unknown method succeeded", name)
+		}
+	}
+}
+
+func TestGobCodecSize(t *testing.T) {
+	args := testBatch(16)
+	sizes := make(map[string]int)
+	for name, codec := range codecs {
+		var buf bytes.Buffer
+		if err := codec.EncodeRequest(&buf, "Test.Echo", args); err != nil {
+			t.Fatal(err)
+		}
+		sizes[name] = buf.Len()
+	}
+	// JSON sends bytes as base64, a third larger.
+	if sizes["gob"]*5 > sizes["json"]*4 {
+		t.Errorf("Gob request of %d bytes not smaller than JSON request of %d bytes", sizes["gob"], sizes["json"])
+	}
+}
+
+func benchmarkRPCCall(b *testing.B, codec Codec) {
+	server := newTestServer()
+	defer server.Close()
+	args := testBatch(64)
+	var buf bytes.Buffer
+	codec.EncodeRequest(&buf, "Test.Echo", args)
+	b.SetBytes(int64(buf.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := RPCCallCodec(codec, server.URL, "Test.Echo", args, &BatchReadReply{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRPCCallJSON(b *testing.B) {
+	benchmarkRPCCall(b, JSONCodec)
+}
+
+func BenchmarkRPCCallGob(b *testing.B) {
+	benchmarkRPCCall(b, GobCodec)
+}
@@ -0,0 +1,8 @@
+package common
+
+// ShardInterface dictates the methods used between the head of a distributed
+// trust domain and the shard servers holding its bucket ranges.
+type ShardInterface interface {
+	Write(args *ReplicaWriteArgs, reply *ReplicaWriteReply) error
+	BatchRead(args *ShardReadArgs, reply *BatchReadReply) error
+}
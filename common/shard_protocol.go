@@ -0,0 +1,8 @@
+package common
+
+// ShardReadArgs are a batch of decoded request vectors sent from the head of
+// a distributed trust domain to a shard server. Each vector only covers the
+// bucket range held by that shard.
+type ShardReadArgs struct {
+	RequestVectors [][]byte
+}
@@ -0,0 +1,44 @@
+package common
+
+import (
+	"log"
+	"os"
+)
+
+// ShardRPC is a stub for the shard server RPC interface
+type ShardRPC struct {
+	log          *log.Logger
+	name         string
+	address      string
+	methodPrefix string
+	codec        Codec
+}
+
+// NewShardRPC creates a new ShardRPC
+func NewShardRPC(name string, address string) *ShardRPC {
+	s := &ShardRPC{}
+	s.log = log.New(os.Stdout, "[ShardRPC:"+name+"] ", log.Ldate|log.Ltime|log.Lshortfile)
+	s.name = name
+	s.address = address
+	s.methodPrefix = "Shard"
+	s.codec = GobCodec
+
+	return s
+}
+
+// Write forwards a write or epoch advance to the shard.
+func (s *ShardRPC) Write(args *ReplicaWriteArgs, reply *ReplicaWriteReply) error {
+	err := RPCCallCodec(s.codec, s.address, s.methodPrefix+".Write", args, reply)
+	return err
+}
+
+// BatchRead performs a set of PIR reads over the bucket range of the shard.
+func (s *ShardRPC) BatchRead(args *ShardReadArgs, reply *BatchReadReply) error {
+	err := RPCCallCodec(s.codec, s.address, s.methodPrefix+".BatchRead", args, reply)
+	return err
+}
+
+// SetCodec sets the codec of calls to the shard, GobCodec by default.
+func (s *ShardRPC) SetCodec(codec Codec) {
+	s.codec = codec
+}
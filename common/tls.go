@@ -0,0 +1,165 @@
+package common
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// certificateLifetime is the validity of generated certificates. Peers pin
+// certificates rather than checking their dates, so it only matters to tools.
+const certificateLifetime = 10 * 365 * 24 * time.Hour
+
+// GenerateCertificate creates a self-signed certificate and its private key
+// for serving the trust domain over TLS. The previous certificate is replaced,
+// and must be replaced in the configuration of peers pinning it.
+func (td *TrustDomainConfig) GenerateCertificate() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: td.Name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(certificateLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if host := addressHost(td.Address); host != "" {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = []net.IP{ip}
+		} else {
+			template.DNSNames = []string{host}
+		}
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	priv, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	td.Certificate = cert
+	td.tlsKey = priv
+	return nil
+}
+
+// addressHost is the host of an address, with or without a scheme.
+func addressHost(address string) string {
+	if !strings.Contains(address, "://") {
+		address = "//" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// UsesTLS is true if the trust domain is reached over TLS, as set by the https
+// scheme of its address.
+func (td *TrustDomainConfig) UsesTLS() bool {
+	return strings.HasPrefix(td.Address, "https://")
+}
+
+// TLSCertificate is the certificate of the trust domain with its private key.
+// It is only available from private configurations.
+func (td *TrustDomainConfig) TLSCertificate() (tls.Certificate, error) {
+	if len(td.Certificate) == 0 || len(td.tlsKey) == 0 {
+		return tls.Certificate{}, errors.New("trust domain has no TLS certificate and key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(td.tlsKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{td.Certificate}, PrivateKey: key}, nil
+}
+
+// ServerTLSConfig is the configuration serving the trust domain over TLS. If
+// clients are given, only clients authenticated by one of their certificates
+// are accepted.
+func (td *TrustDomainConfig) ServerTLSConfig(clients ...*TrustDomainConfig) (*tls.Config, error) {
+	cert, err := td.TLSCertificate()
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(clients) > 0 {
+		pins := make([][]byte, len(clients))
+		for i, c := range clients {
+			if len(c.Certificate) == 0 {
+				return nil, errors.New("client " + c.Name + " has no TLS certificate")
+			}
+			pins[i] = c.Certificate
+		}
+		config.ClientAuth = tls.RequireAnyClientCert
+		config.VerifyPeerCertificate = verifyPinned(pins)
+	}
+	return config, nil
+}
+
+// ClientTLSConfig is the configuration of connections to the trust domain,
+// accepting only its pinned certificate. If identity is not nil, its
+// certificate authenticates the client.
+func (td *TrustDomainConfig) ClientTLSConfig(identity *TrustDomainConfig) (*tls.Config, error) {
+	config, err := PinnedTLSConfig(td.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		cert, err := identity.TLSCertificate()
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// PinnedTLSConfig is the configuration of connections to a server accepting
+// only the server certificate cert, in DER form.
+func PinnedTLSConfig(cert []byte) (*tls.Config, error) {
+	if len(cert) == 0 {
+		return nil, errors.New("no certificate to pin")
+	}
+	return &tls.Config{
+		// Chains and names are not verified, as the certificate is pinned.
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verifyPinned([][]byte{cert}),
+		MinVersion:            tls.VersionTLS12,
+	}, nil
+}
+
+// verifyPinned accepts peers presenting one of the pinned certificates.
+func verifyPinned(pins [][]byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("peer presented no certificate")
+		}
+		for _, pin := range pins {
+			if bytes.Equal(rawCerts[0], pin) {
+				return nil
+			}
+		}
+		return errors.New("peer certificate is not pinned")
+	}
+}
@@ -0,0 +1,86 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTLSTestServer serves the test service over TLS as td, accepting clients.
+func newTLSTestServer(t *testing.T, td *TrustDomainConfig, clients ...*TrustDomainConfig) *httptest.Server {
+	tlsConfig, err := td.ServerTLSConfig(clients...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(newTestHandler())
+	server.TLS = tlsConfig
+	server.StartTLS()
+	return server
+}
+
+func TestPinnedTLS(t *testing.T) {
+	replica := NewTrustDomainConfig("replica", "https://127.0.0.1:9000", true, false)
+	frontend := NewTrustDomainConfig("frontend", "https://127.0.0.1:8999", true, false)
+	other := NewTrustDomainConfig("other", "https://127.0.0.1:9001", true, false)
+	if !replica.IsValid || !replica.UsesTLS() {
+		t.Fatal("Failed to make trust domain with certificate.")
+	}
+	server := newTLSTestServer(t, replica, frontend)
+	defer server.Close()
+
+	call := func(td *TrustDomainConfig, identity *TrustDomainConfig) error {
+		tlsConfig, err := td.ClientTLSConfig(identity)
+		if err != nil {
+			return err
+		}
+		transport := NewTLSTransport(time.Second, tlsConfig)
+		return transport.Call(context.Background(), GobCodec, server.URL, "Test.Echo", testBatch(1), &BatchReadReply{})
+	}
+	if err := call(replica, frontend); err != nil {
+		t.Errorf("Pinned call of the frontend failed: %v", err)
+	}
+	if err := call(replica, other); err == nil {
+		t.Errorf("Replica accepted client with another certificate")
+	}
+	if err := call(replica, nil); err == nil {
+		t.Errorf("Replica accepted client without certificate")
+	}
+	if err := call(other, frontend); err == nil {
+		t.Errorf("Client accepted replica with another certificate")
+	}
+
+	// Clients without identities, as for frontends.
+	open := newTLSTestServer(t, frontend)
+	defer open.Close()
+	tlsConfig, _ := PinnedTLSConfig(frontend.Certificate)
+	transport := NewTLSTransport(time.Second, tlsConfig)
+	if err := transport.Call(context.Background(), GobCodec, open.URL, "Test.Echo", testBatch(1), &BatchReadReply{}); err != nil {
+		t.Errorf("Anonymous pinned call failed: %v", err)
+	}
+}
+
+func TestTrustDomainCertificateMarshaling(t *testing.T) {
+	tdc := NewTrustDomainConfig("testing", "https://localhost:9000", true, false)
+	publicBytes, _ := json.Marshal(tdc)
+	publicDomain := new(TrustDomainConfig)
+	if err := json.Unmarshal(publicBytes, publicDomain); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := publicDomain.TLSCertificate(); err == nil {
+		t.Errorf("Serialization should not re-create TLS private key")
+	}
+	if _, err := publicDomain.ClientTLSConfig(nil); err != nil {
+		t.Errorf("Serialization should re-create certificate: %v", err)
+	}
+
+	privateBytes, _ := json.Marshal(tdc.Private())
+	privateDomain := new(TrustDomainConfig)
+	if err := json.Unmarshal(privateBytes, privateDomain); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := privateDomain.ServerTLSConfig(publicDomain); err != nil {
+		t.Errorf("Serialization of private() should re-create TLS key: %v", err)
+	}
+}
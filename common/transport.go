@@ -0,0 +1,133 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultCallTimeout bounds the calls of DefaultTransport.
+const DefaultCallTimeout = time.Minute
+
+// DefaultTransport is the Transport of RPCCall and of RPC stubs not given
+// another one.
+var DefaultTransport = NewTransport(DefaultCallTimeout)
+
+// Transport makes RPC calls over a pool of persistent connections, shared by
+// all stubs using the Transport.
+type Transport struct {
+	// Timeout bounds each attempt of a call, unless its context ends sooner.
+	// Attempts are unbounded when zero.
+	Timeout time.Duration
+	// Retries is the number of further attempts of an idempotent call after
+	// failures to reach the server.
+	Retries int
+	// Backoff is the wait before the first retry, doubling for each further one.
+	Backoff time.Duration
+
+	client *http.Client
+}
+
+// NewTransport creates a Transport bounding call attempts by timeout, which
+// retries idempotent calls twice.
+func NewTransport(timeout time.Duration) *Transport {
+	return NewTLSTransport(timeout, nil)
+}
+
+// NewTLSTransport creates a Transport whose https connections are configured
+// by tlsConfig.
+func NewTLSTransport(timeout time.Duration, tlsConfig *tls.Config) *Transport {
+	t := &Transport{}
+	t.Timeout = timeout
+	t.Retries = 2
+	t.Backoff = 100 * time.Millisecond
+	t.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 32,
+			IdleConnTimeout:     90 * time.Second,
+			TLSClientConfig:     tlsConfig,
+		},
+	}
+	return t
+}
+
+// Call makes an RPC call encoded by codec.
+func (t *Transport) Call(ctx context.Context, codec Codec, address string, methodName string, args interface{}, reply interface{}) error {
+	_, err := t.attempt(ctx, codec, address, methodName, args, reply)
+	return err
+}
+
+// CallIdempotent makes an RPC call encoded by codec, which is retried when the
+// server cannot be reached. Only calls without side effects may be retried.
+func (t *Transport) CallIdempotent(ctx context.Context, codec Codec, address string, methodName string, args interface{}, reply interface{}) error {
+	backoff := t.Backoff
+	for i := 0; ; i++ {
+		retry, err := t.attempt(ctx, codec, address, methodName, args, reply)
+		if err == nil || !retry || i >= t.Retries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// attempt makes one attempt of a call. retry is set if the attempt failed to
+// reach the server and the context of the call has not ended.
+func (t *Transport) attempt(ctx context.Context, codec Codec, address string, methodName string, args interface{}, reply interface{}) (retry bool, err error) {
+	// Encode arguments
+	var message bytes.Buffer
+	if err = codec.EncodeRequest(&message, methodName, args); err != nil {
+		return false, err
+	}
+
+	// Construct request
+	req, err := http.NewRequest("POST", address, &message)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", codec.ContentType())
+	attemptCtx := ctx
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+
+	// Do RPC
+	resp, err := t.client.Do(req.WithContext(attemptCtx))
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+
+	// Drain the body, so the connection is reused by later calls
+	defer resp.Body.Close()
+	defer io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+		return resp.StatusCode >= 500 && ctx.Err() == nil, err
+	}
+
+	if err = codec.DecodeResponse(resp.Body, reply); err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
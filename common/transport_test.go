@@ -0,0 +1,106 @@
+package common
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportTimeout(t *testing.T) {
+	hang := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	transport := NewTransport(20 * time.Millisecond)
+	start := time.Now()
+	if err := transport.Call(context.Background(), GobCodec, server.URL, "Test.Echo", &GetHintArgs{}, &GetHintReply{}); err == nil {
+		t.Errorf("call to hung server succeeded")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("call to hung server not abandoned at its timeout")
+	}
+
+	// The deadline of the context applies when it is sooner
+	transport.Timeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := transport.CallIdempotent(ctx, GobCodec, server.URL, "Test.Echo", &GetHintArgs{}, &GetHintReply{}); err == nil {
+		t.Errorf("call to hung server succeeded")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("call to hung server not abandoned at the deadline of its context")
+	}
+}
+
+func TestTransportRetries(t *testing.T) {
+	s := newTestHandler()
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1)%3 != 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	transport := NewTransport(time.Second)
+	transport.Backoff = time.Millisecond
+	var noArgs interface{}
+	conf := &Config{}
+	if err := transport.CallIdempotent(context.Background(), GobCodec, server.URL, "Test.GetConfig", &noArgs, conf); err != nil || conf.NumBuckets != 42 {
+		t.Errorf("idempotent call not retried: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("idempotent call attempted %d times instead of 3", attempts)
+	}
+
+	attempts = 0
+	if err := transport.Call(context.Background(), GobCodec, server.URL, "Test.GetConfig", &noArgs, conf); err == nil {
+		t.Errorf("call succeeded without retries")
+	}
+	if attempts != 1 {
+		t.Errorf("call attempted %d times", attempts)
+	}
+
+	// Errors of the method are not retried
+	attempts = 2
+	if err := transport.CallIdempotent(context.Background(), GobCodec, server.URL, "Test.Fail", &GetHintArgs{}, &GetHintReply{}); err == nil {
+		t.Errorf("failing call succeeded")
+	}
+	if attempts != 3 {
+		t.Errorf("failing call attempted %d times", attempts-2)
+	}
+}
+
+func TestTransportReusesConnections(t *testing.T) {
+	server := httptest.NewUnstartedServer(newTestHandler())
+	var conns int32
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	transport := NewTransport(time.Second)
+	for _, codec := range codecs {
+		for i := 0; i < 5; i++ {
+			if err := transport.Call(context.Background(), codec, server.URL, "Test.Echo", testBatch(2), &BatchReadReply{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if conns != 1 {
+		t.Errorf("sequential calls made %d connections", conns)
+	}
+}
@@ -16,8 +16,10 @@ type TrustDomainConfig struct {
 	IsDistributed  bool
 	PublicKey      [32]byte // For PIR Encryption
 	SignPublicKey  [32]byte // For Signing Interest Vectors
+	Certificate    []byte   // DER certificate pinned by TLS peers
 	privateKey     [32]byte
 	signPrivateKey [64]byte
+	tlsKey         []byte // PKCS #8 private key of the Certificate
 }
 
 // PrivateTrustDomainConfig allows export of the trust domain Private Key.
@@ -25,6 +27,7 @@ type PrivateTrustDomainConfig struct {
 	*TrustDomainConfig
 	PrivateKey     [32]byte
 	SignPrivateKey [64]byte
+	TLSPrivateKey  []byte
 }
 
 // NewTrustDomainConfig creates a TrustDomainConfig with a freshly generated keypair.
@@ -51,6 +54,10 @@ func NewTrustDomainConfig(name string, address string, isValid bool,
 	copy(td.SignPublicKey[:], spubKey[:])
 	copy(td.signPrivateKey[:], spriKey[:])
 
+	if err := td.GenerateCertificate(); err != nil {
+		td.IsValid = false
+	}
+
 	return td
 }
 
@@ -65,6 +72,8 @@ func (td *TrustDomainConfig) UnmarshalJSON(marshaled []byte) error {
 		PrivateKey     [32]byte
 		SignPublicKey  [32]byte
 		SignPrivateKey [64]byte
+		Certificate    []byte
+		TLSPrivateKey  []byte
 		Name           string
 		Address        string
 		IsValid        bool
@@ -79,6 +88,8 @@ func (td *TrustDomainConfig) UnmarshalJSON(marshaled []byte) error {
 	copy(td.PublicKey[:], config.PublicKey[:])
 	copy(td.signPrivateKey[:], config.SignPrivateKey[:])
 	copy(td.SignPublicKey[:], config.SignPublicKey[:])
+	td.Certificate = config.Certificate
+	td.tlsKey = config.TLSPrivateKey
 	td.Name = config.Name
 	td.Address = config.Address
 	td.IsValid = config.IsValid
@@ -94,6 +105,7 @@ func (td *TrustDomainConfig) Private() *PrivateTrustDomainConfig {
 	PTDC.TrustDomainConfig = td
 	copy(PTDC.PrivateKey[:], td.privateKey[:])
 	copy(PTDC.SignPrivateKey[:], td.signPrivateKey[:])
+	PTDC.TLSPrivateKey = td.tlsKey
 	return PTDC
 }
 
@@ -0,0 +1,168 @@
+package common
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"time"
+)
+
+// Write tokens are RSA blind signatures on a full domain hash of a random
+// serial number and the epoch of the token. The frontend signs blinded
+// serials, so it cannot link the tokens spent with writes to the clients it
+// issued them to.
+
+// TokenSerialLength is the number of random bytes identifying a WriteToken.
+const TokenSerialLength = 32
+
+// WriteToken admits writes to the frontend during its epoch.
+type WriteToken struct {
+	Epoch     uint64
+	Serial    [TokenSerialLength]byte
+	Signature []byte
+}
+
+// GetTokenKeyArgs is the empty pointer fullfilling the RPC calling convention.
+type GetTokenKeyArgs struct {
+}
+
+// GetTokenKeyReply describes the write tokens issued by the frontend in the
+// current epoch. Tokens is 0 if the frontend admits writes without tokens.
+type GetTokenKeyReply struct {
+	Err            string
+	Epoch          uint64
+	Expires        time.Time // End of the epoch
+	Modulus        []byte    // Public key of the token signatures
+	Exponent       int
+	Tokens         int // Number of tokens issued to each client per epoch
+	WritesPerToken int
+}
+
+// PublicKey is the key verifying the tokens of the epoch.
+func (r *GetTokenKeyReply) PublicKey() *rsa.PublicKey {
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(r.Modulus), E: r.Exponent}
+}
+
+// IssueTokensArgs requests signatures of blinded tokens.
+type IssueTokensArgs struct {
+	Epoch   uint64
+	Blinded [][]byte
+}
+
+// IssueTokensReply holds the blind signatures of the requested tokens.
+type IssueTokensReply struct {
+	Err        string
+	Signatures [][]byte
+}
+
+// tokenHash is the full domain hash of a token, an integer modulo n.
+func tokenHash(n *big.Int, epoch uint64, serial []byte) *big.Int {
+	// Expanded by 128 bits beyond the modulus, so the reduction is unbiased.
+	length := (n.BitLen()+7)/8 + 16
+	out := make([]byte, 0, length+sha256.Size)
+	var header [12]byte
+	binary.LittleEndian.PutUint64(header[:8], epoch)
+	for i := uint32(0); len(out) < length; i++ {
+		binary.LittleEndian.PutUint32(header[8:], i)
+		h := sha256.New()
+		h.Write([]byte("talek write token"))
+		h.Write(header[:])
+		h.Write(serial)
+		out = h.Sum(out)
+	}
+	m := new(big.Int).SetBytes(out[:length])
+	return m.Mod(m, n)
+}
+
+// VerifyToken checks that a token was signed by key.
+func VerifyToken(key *rsa.PublicKey, token *WriteToken) bool {
+	s := new(big.Int).SetBytes(token.Signature)
+	if s.Sign() == 0 || s.Cmp(key.N) >= 0 {
+		return false
+	}
+	m := tokenHash(key.N, token.Epoch, token.Serial[:])
+	s.Exp(s, big.NewInt(int64(key.E)), key.N)
+	return subtle.ConstantTimeCompare(s.Bytes(), m.Bytes()) == 1
+}
+
+// SignBlindedToken signs a blinded token with key.
+func SignBlindedToken(key *rsa.PrivateKey, blinded []byte) ([]byte, error) {
+	c := new(big.Int).SetBytes(blinded)
+	if c.Sign() == 0 || c.Cmp(key.N) >= 0 {
+		return nil, errors.New("blinded token out of range")
+	}
+	if len(key.Primes) != 2 || key.Precomputed.Dp == nil {
+		return new(big.Int).Exp(c, key.D, key.N).Bytes(), nil
+	}
+	// By the Chinese remainder theorem, as in rsa.DecryptPKCS1v15.
+	p, q := key.Primes[0], key.Primes[1]
+	m := new(big.Int).Exp(c, key.Precomputed.Dp, p)
+	m2 := new(big.Int).Exp(c, key.Precomputed.Dq, q)
+	m.Sub(m, m2)
+	m.Mul(m, key.Precomputed.Qinv)
+	m.Mod(m, p)
+	m.Mul(m, q)
+	m.Add(m, m2)
+	return m.Bytes(), nil
+}
+
+// BlindedToken is a WriteToken before its signature is unblinded.
+type BlindedToken struct {
+	key     *rsa.PublicKey
+	token   WriteToken
+	unblind *big.Int // Inverse of the blinding factor
+	Blinded []byte   // Sent to the frontend for signing
+}
+
+// BlindToken creates a token with a random serial for the epoch, and blinds
+// it for signing by key.
+func BlindToken(key *rsa.PublicKey, epoch uint64, rand io.Reader) (*BlindedToken, error) {
+	b := &BlindedToken{key: key}
+	b.token.Epoch = epoch
+	if _, err := io.ReadFull(rand, b.token.Serial[:]); err != nil {
+		return nil, err
+	}
+	// A blinding factor invertible modulo N
+	var r *big.Int
+	for b.unblind == nil {
+		var err error
+		if r, err = randInt(rand, key.N); err != nil {
+			return nil, err
+		}
+		b.unblind = new(big.Int).ModInverse(r, key.N)
+	}
+	m := tokenHash(key.N, epoch, b.token.Serial[:])
+	r.Exp(r, big.NewInt(int64(key.E)), key.N)
+	m.Mul(m, r)
+	m.Mod(m, key.N)
+	b.Blinded = m.Bytes()
+	return b, nil
+}
+
+// randInt is a uniform random integer in [1, n).
+func randInt(rand io.Reader, n *big.Int) (*big.Int, error) {
+	buf := make([]byte, (n.BitLen()+7)/8+16)
+	if _, err := io.ReadFull(rand, buf); err != nil {
+		return nil, err
+	}
+	r := new(big.Int).SetBytes(buf)
+	r.Mod(r, new(big.Int).Sub(n, big.NewInt(1)))
+	return r.Add(r, big.NewInt(1)), nil
+}
+
+// Unblind completes the token with the blind signature of the frontend.
+func (b *BlindedToken) Unblind(signature []byte) (*WriteToken, error) {
+	s := new(big.Int).SetBytes(signature)
+	s.Mul(s, b.unblind)
+	s.Mod(s, b.key.N)
+	token := b.token
+	token.Signature = s.Bytes()
+	if !VerifyToken(b.key, &token) {
+		return nil, errors.New("invalid token signature")
+	}
+	return &token, nil
+}
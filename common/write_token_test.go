@@ -0,0 +1,55 @@
+package common
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestWriteTokenBlindSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Precompute()
+	reply := &GetTokenKeyReply{Epoch: 5, Modulus: key.N.Bytes(), Exponent: key.E}
+
+	blinded, err := BlindToken(reply.PublicKey(), reply.Epoch, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := SignBlindedToken(key, blinded.Blinded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := blinded.Unblind(sig)
+	if err != nil {
+		t.Fatalf("Failed to unblind token: %v", err)
+	}
+	if !VerifyToken(&key.PublicKey, token) {
+		t.Fatalf("Unblinded token failed to verify")
+	}
+	if token.Epoch != 5 {
+		t.Errorf("Token has epoch %d, expected 5", token.Epoch)
+	}
+
+	modified := *token
+	modified.Epoch++
+	if VerifyToken(&key.PublicKey, &modified) {
+		t.Errorf("Token verified in another epoch")
+	}
+	modified = *token
+	modified.Serial[0] ^= 1
+	if VerifyToken(&key.PublicKey, &modified) {
+		t.Errorf("Token verified with another serial")
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 1024)
+	if VerifyToken(&other.PublicKey, token) {
+		t.Errorf("Token verified with another key")
+	}
+	otherSig, _ := SignBlindedToken(other, blinded.Blinded)
+	if _, err := blinded.Unblind(otherSig); err == nil {
+		t.Errorf("Unblinded signature of another key")
+	}
+}
@@ -6,6 +6,8 @@ type Item struct {
 	Data    []byte
 	Bucket1 uint64
 	Bucket2 uint64
+	// Further candidate buckets, for tables with more than two choices.
+	Buckets []uint64
 }
 
 // Copy duplicates an Item.
@@ -15,6 +17,9 @@ func (i Item) Copy() *Item {
 	other.Data = make([]byte, len(i.Data))
 	other.Bucket1 = i.Bucket1
 	other.Bucket2 = i.Bucket2
+	if i.Buckets != nil {
+		other.Buckets = append([]uint64{}, i.Buckets...)
+	}
 	copy(other.Data, i.Data)
 	return other
 }
@@ -26,5 +31,23 @@ func (i *Item) Equals(other *Item) bool {
 	}
 	return i.Bucket1 == other.Bucket1 &&
 		i.Bucket2 == other.Bucket2 &&
+		equalBuckets(i.Buckets, other.Buckets) &&
 		i.ID == other.ID
 }
+
+// Candidates returns all buckets the item may be placed in.
+func (i *Item) Candidates() []uint64 {
+	return append([]uint64{i.Bucket1, i.Bucket2}, i.Buckets...)
+}
+
+func equalBuckets(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for j := range a {
+		if a[j] != b[j] {
+			return false
+		}
+	}
+	return true
+}
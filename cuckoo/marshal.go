@@ -0,0 +1,151 @@
+package cuckoo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/privacylab/talek/common"
+)
+
+// tableVersion is the version of the binary encoding of a Table.
+const tableVersion byte = 3
+
+// seededSource is a rand.Source which remembers its seed, so that its state
+// can be encoded while it is freshly seeded.
+type seededSource struct {
+	rand.Source64
+	seed int64
+}
+
+func newSeededSource(seed int64) *seededSource {
+	return &seededSource{rand.NewSource(seed).(rand.Source64), seed}
+}
+
+func (s *seededSource) Seed(seed int64) {
+	s.Source64.Seed(seed)
+	s.seed = seed
+}
+
+// MarshalBinary encodes the state of the table: its sizing, the placement of
+// items, the seed of its PRNG and the data of the stash. The data of items in
+// buckets is held in the memory area of the table, and is not included.
+// The PRNG is reseeded with its next value, which is encoded, so marshaling
+// is one of the operations determining the state of the table.
+func (t *Table) MarshalBinary() ([]byte, error) {
+	t.source.Seed(t.source.Int63())
+
+	var buf bytes.Buffer
+	buf.WriteByte(tableVersion)
+	header := []uint64{t.numBuckets, t.bucketDepth, t.itemSize, t.stashBuckets, uint64(t.source.seed)}
+	if err := binary.Write(&buf, binary.LittleEndian, header); err != nil {
+		return nil, err
+	}
+	t.writeLayout(&buf)
+	buf.Write(t.stash)
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary restores the state encoded by MarshalBinary. A table with
+// a memory area must have the same sizing as the encoded table, while a zero
+// Table allocates one. Insert statistics of the table are kept. The table is
+// left unchanged if data can't be decoded.
+func (t *Table) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if version, err := r.ReadByte(); err != nil || version != tableVersion {
+		return errors.New("UnmarshalBinary: unknown table encoding")
+	}
+	header := make([]uint64, 5)
+	if err := binary.Read(r, binary.LittleEndian, header); err != nil {
+		return fmt.Errorf("UnmarshalBinary: %v", err)
+	}
+	numBuckets, bucketDepth, itemSize, stashBuckets := header[0], header[1], header[2], header[3]
+	length := uint64(r.Len())
+	if numBuckets == 0 || bucketDepth == 0 || numBuckets > length || stashBuckets > length || bucketDepth > length {
+		return errors.New("UnmarshalBinary: invalid sizing")
+	}
+	slots := (numBuckets + stashBuckets) * bucketDepth
+	stashSlots := stashBuckets * bucketDepth
+	if slots > length/(2+3*8) || (stashSlots > 0 && itemSize > (length-slots*(2+3*8))/stashSlots) {
+		return errors.New("UnmarshalBinary: invalid length")
+	}
+	if t.data != nil && (numBuckets != t.numBuckets || bucketDepth != t.bucketDepth || itemSize != t.itemSize || stashBuckets != t.stashBuckets) {
+		return errors.New("UnmarshalBinary: table sizing differs")
+	}
+
+	index := make([]ItemLocation, slots)
+	location := make([]uint64, 3)
+	for i := range index {
+		filled, _ := r.ReadByte()
+		binary.Read(r, binary.LittleEndian, location)
+		index[i] = ItemLocation{id: location[0], filled: filled != 0, bucket1: location[1], bucket2: location[2]}
+		extra, err := r.ReadByte()
+		if err != nil {
+			return errors.New("UnmarshalBinary: invalid length")
+		}
+		if int(extra) > common.MaxBucketChoices-2 {
+			return errors.New("UnmarshalBinary: too many bucket choices")
+		}
+		if extra > 0 {
+			index[i].buckets = make([]uint64, extra)
+			if err = binary.Read(r, binary.LittleEndian, index[i].buckets); err != nil {
+				return errors.New("UnmarshalBinary: invalid length")
+			}
+		}
+		// Items are only ever placed in their candidate buckets.
+		if index[i].filled {
+			for _, bucket := range append([]uint64{index[i].bucket1, index[i].bucket2}, index[i].buckets...) {
+				if bucket >= numBuckets {
+					return errors.New("UnmarshalBinary: bucket out of range")
+				}
+			}
+		}
+	}
+	if uint64(r.Len()) != stashBuckets*bucketDepth*itemSize {
+		return errors.New("UnmarshalBinary: invalid length")
+	}
+
+	if t.data == nil {
+		*t = *NewStashTable(t.name, numBuckets, bucketDepth, itemSize, nil, 0, stashBuckets)
+	}
+	for i, loc := range index {
+		t.setFilled(uint64(i), loc.filled)
+		t.index[i] = loc
+	}
+	r.Read(t.stash)
+	t.source.Seed(int64(header[4]))
+	return nil
+}
+
+// Digest returns a hash of the sizing of the table and the placement of
+// items, which is equal for tables with identical layouts.
+func (t *Table) Digest() [sha256.Size]byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint64{t.numBuckets, t.bucketDepth, t.itemSize, t.stashBuckets})
+	t.writeLayout(&buf)
+	return sha256.Sum256(buf.Bytes())
+}
+
+// writeLayout encodes the index, as a filled flag, the id and first two
+// buckets, and the count and further candidate buckets of each item slot.
+// Empty slots are encoded as zeros.
+func (t *Table) writeLayout(buf *bytes.Buffer) {
+	location := make([]byte, 3*8)
+	empty := make([]byte, 2+3*8)
+	for _, loc := range t.index {
+		if !loc.filled {
+			buf.Write(empty)
+			continue
+		}
+		buf.WriteByte(1)
+		binary.LittleEndian.PutUint64(location, loc.id)
+		binary.LittleEndian.PutUint64(location[8:], loc.bucket1)
+		binary.LittleEndian.PutUint64(location[16:], loc.bucket2)
+		buf.Write(location)
+		buf.WriteByte(byte(len(loc.buckets)))
+		binary.Write(buf, binary.LittleEndian, loc.buckets)
+	}
+}
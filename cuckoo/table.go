@@ -17,6 +17,7 @@ type ItemLocation struct {
 	filled  bool
 	bucket1 uint64
 	bucket2 uint64
+	buckets []uint64
 }
 
 // Table is a cuckoo table managing placement of Items, where the data is a byte array.
@@ -27,8 +28,40 @@ type Table struct {
 	itemSize    uint64 // Number of bytes in an item. Must be fixed globally
 	data        []byte // Serialized cuckoo table data of all items {bucket1, bucket2, ...}
 	rand        *rand.Rand
+	source      *seededSource // Source of rand, remembering its seed
 	log         *common.Logger
-	index       []ItemLocation // Meta data of each item's bucket locations and ID
+	index       []ItemLocation           // Meta data of each item's bucket locations and ID
+	onWrite     func(bucketIndex uint64) // Called when data of a bucket is written
+
+	// The stash holds items that could not be placed within MaxEvictions.
+	// It consists of stashBuckets buckets following the regular buckets,
+	// whose data is held by the table rather than in data.
+	stashBuckets uint64
+	stash        []byte
+
+	// Live statistics
+	numElements    uint64   // Filled item slots, including the stash
+	bucketLoad     []uint64 // Filled item slots of each bucket
+	occupancy      []uint64 // Number of buckets holding each number of items
+	evictionChains []uint64 // Number of inserts evicting each number of items
+	inserts        uint64
+	failures       uint64
+}
+
+// Stats is a snapshot of the occupancy of a Table and the cost of its inserts.
+type Stats struct {
+	Elements   uint64  // Items in the table, including the stash
+	Capacity   uint64  // Item slots in buckets, excluding the stash
+	LoadFactor float64 // Items in buckets / Capacity
+	Stashed    uint64  // Items in the stash
+	// BucketOccupancy[k] is the number of buckets holding k items.
+	BucketOccupancy []uint64
+	// EvictionChains[k] is the number of inserts evicting k items. Inserts
+	// reaching MaxEvictions are counted at MaxEvictions, whether the last
+	// evicted item was stashed or the insert failed.
+	EvictionChains []uint64
+	Inserts        uint64 // Valid calls to Insert
+	Failures       uint64 // Inserts failing with a full stash
 }
 
 // NewTable creates a new cuckoo table optionaly backed by a pre-allocated memory area.
@@ -40,14 +73,28 @@ type Table struct {
 // randSeed = seed for PRNG
 func NewTable(name string, numBuckets uint64, bucketDepth uint64, itemSize uint64,
 	data []byte, randSeed int64) *Table {
-	t := &Table{name, numBuckets, bucketDepth, itemSize, nil, nil, nil, nil}
+	return NewStashTable(name, numBuckets, bucketDepth, itemSize, data, randSeed, 0)
+}
+
+// NewStashTable creates a new cuckoo table with a stash of stashBuckets
+// buckets, holding items which can't be placed in their buckets, rather than
+// failing their insertion.
+func NewStashTable(name string, numBuckets uint64, bucketDepth uint64, itemSize uint64,
+	data []byte, randSeed int64, stashBuckets uint64) *Table {
+	t := &Table{name: name, numBuckets: numBuckets, bucketDepth: bucketDepth, itemSize: itemSize, stashBuckets: stashBuckets}
 	if data == nil {
 		data = make([]byte, numBuckets*bucketDepth*itemSize)
 	}
 	t.data = data
-	t.rand = rand.New(rand.NewSource(randSeed))
+	t.stash = make([]byte, stashBuckets*bucketDepth*itemSize)
+	t.source = newSeededSource(randSeed)
+	t.rand = rand.New(t.source)
 	t.log = common.NewLogger(name)
-	t.index = make([]ItemLocation, numBuckets*bucketDepth)
+	t.index = make([]ItemLocation, (numBuckets+stashBuckets)*bucketDepth)
+	t.bucketLoad = make([]uint64, numBuckets+stashBuckets)
+	t.occupancy = make([]uint64, bucketDepth+1)
+	t.occupancy[0] = numBuckets
+	t.evictionChains = make([]uint64, MaxEvictions+1)
 
 	if uint64(len(data)) != numBuckets*bucketDepth*itemSize {
 		t.log.Error.Printf("NewTable(%v) failed: len(data)=%v is not equal to numBuckets*bucketDepth*itemSize (%v,%v,%v)", name, len(data), numBuckets, bucketDepth, itemSize)
@@ -61,7 +108,8 @@ func NewTable(name string, numBuckets uint64, bucketDepth uint64, itemSize uint6
  * PUBLIC METHODS
  ********************/
 
-// GetCapacity returns the total capacity of the table (numBuckets * depth)
+// GetCapacity returns the total capacity of the table (numBuckets * depth),
+// excluding the stash
 func (t *Table) GetCapacity() uint64 {
 	return t.numBuckets * t.bucketDepth
 }
@@ -69,51 +117,75 @@ func (t *Table) GetCapacity() uint64 {
 // GetNumElements returns the number of elements stored in the table
 // Load factor = GetNumElements() / GetCapacity()
 func (t *Table) GetNumElements() uint64 {
-	result := uint64(0)
-	for _, itemLocation := range t.index {
-		if itemLocation.filled {
-			result++
-		}
-	}
+	return t.numElements
+}
 
-	return result
+// Stats returns a snapshot of the occupancy of the table and the eviction
+// chains of inserts so far.
+func (t *Table) Stats() Stats {
+	stats := Stats{
+		Elements:        t.numElements,
+		Capacity:        t.GetCapacity(),
+		BucketOccupancy: append([]uint64{}, t.occupancy...),
+		EvictionChains:  append([]uint64{}, t.evictionChains...),
+		Inserts:         t.inserts,
+		Failures:        t.failures,
+	}
+	for b := t.numBuckets; b < t.numBuckets+t.stashBuckets; b++ {
+		stats.Stashed += t.bucketLoad[b]
+	}
+	if stats.Capacity > 0 {
+		stats.LoadFactor = float64(stats.Elements-stats.Stashed) / float64(stats.Capacity)
+	}
+	return stats
 }
 
 // Bucket returns the bucket in a table that the Item is in, if it is in the table.
 // an invalid bucket number and an error, otherwise
 func (t *Table) Bucket(item *Item) (uint64, error) {
-	if t.isInBucket(item.Bucket1, item) {
-		return item.Bucket1, nil
-	} else if t.isInBucket(item.Bucket2, item) {
-		return item.Bucket2, nil
+	for _, b := range item.Candidates() {
+		if b < t.numBuckets && t.isInBucket(b, item) {
+			return b, nil
+		}
+	}
+	for b := t.numBuckets; b < t.numBuckets+t.stashBuckets; b++ {
+		if t.isInBucket(b, item) {
+			return b, nil
+		}
 	}
-	return t.numBuckets + 1, fmt.Errorf("%v.Bucket(%v): item not in table", t.name, item)
+	return t.numBuckets + t.stashBuckets + 1, fmt.Errorf("%v.Bucket(%v): item not in table", t.name, item)
 }
 
 // Contains checks if value exists in specified buckets
-// the value must have been inserted with the same bucket1 and bucket2 values
+// the value must have been inserted with the same candidate buckets
 // Returns:
-// - true if the item is in either bucket
-// - false if either bucket is out of range
-// - false if value not in either bucket
+// - true if the item is in any candidate bucket, or the stash
+// - false if any bucket is out of range
+// - false if value not in any bucket
 func (t *Table) Contains(item *Item) bool {
-	if item.Bucket1 >= t.numBuckets || item.Bucket2 >= t.numBuckets {
+	if !t.inRange(item) {
 		return false
 	}
 
-	return t.isInBucket(item.Bucket1, item) || t.isInBucket(item.Bucket2, item)
+	for _, b := range item.Candidates() {
+		if t.isInBucket(b, item) {
+			return true
+		}
+	}
+	return t.isInStash(item)
 }
 
 // Insert adds item into the cuckoo table, even if a duplicate value already
 // exists in table. Returns:
 // - true on success, false on failure
 // - false if item.Data is not equal to t.itemSize
-// - false if either bucket is out of range
-// - false if insertion cannot complete because reached MAX_EVICTIONS
+// - false if any bucket is out of range
+// - false if insertion cannot complete because reached MAX_EVICTIONS, and
+//   the stash is full
 func (t *Table) Insert(item *Item) (bool, *Item) {
 	var nextBucket uint64
-	if item.Bucket1 >= t.numBuckets || item.Bucket2 >= t.numBuckets {
-		t.log.Error.Printf("Insert: invalid buckets=(%v,%v)\n", item.Bucket1, item.Bucket2)
+	if !t.inRange(item) {
+		t.log.Error.Printf("Insert: invalid buckets=%v\n", item.Candidates())
 		return false, nil
 	}
 
@@ -123,49 +195,60 @@ func (t *Table) Insert(item *Item) (bool, *Item) {
 		return false, nil
 	}
 
+	t.inserts++
+
 	// Randomly select 1 bucket first
-	coin := t.rand.Int() % 2 // Coin can be 0 or 1
-	if coin == 0 {
-		if t.tryInsertToBucket(item.Bucket1, item) {
-			return true, nil
+	candidates := item.Candidates()
+	start := t.rand.Int() % len(candidates)
+	for i := range candidates {
+		// The bucket following the first is tried by the eviction loop.
+		if i == 1 {
+			continue
 		}
-		nextBucket = item.Bucket2
-	} else {
-		if t.tryInsertToBucket(item.Bucket2, item) {
+		if t.tryInsertToBucket(candidates[(start+i)%len(candidates)], item) {
+			t.evictionChains[0]++
 			return true, nil
 		}
-		nextBucket = item.Bucket1
 	}
+	nextBucket = candidates[(start+1)%len(candidates)]
 
-	// Then try the other bucket, starting the eviction loop
+	// Then try the next bucket, starting the eviction loop
 	var ok bool
 	for i := 0; i < MaxEvictions; i++ {
 		if ok, item = t.insertAndEvict(nextBucket, item); !ok {
 			t.log.Error.Fatalf("Lost item. Evicted, but was unable to add.")
 			return false, item
 		} else if item == nil {
+			t.evictionChains[i]++
 			return true, nil
-		} else if item.Bucket1 == nextBucket {
-			nextBucket = item.Bucket2
-		} else {
-			nextBucket = item.Bucket1
 		}
+		nextBucket = t.nextChoice(item, nextBucket)
 	}
 
+	t.evictionChains[MaxEvictions]++
+
+	// Keep the remaining item in the stash
+	for b := t.numBuckets; b < t.numBuckets+t.stashBuckets; b++ {
+		if t.tryInsertToBucket(b, item) {
+			return true, nil
+		}
+	}
+
+	t.failures++
 	t.log.Error.Printf("Insert: max %v evictions\n", MaxEvictions)
 	return false, item
 }
 
-// Remove deletes item from the cuckoo table, looking in only 2 specified buckets
-// Only matches if the value was previously inserted with the same {bucket1, bucket2} values
+// Remove deletes item from the cuckoo table, looking in only its candidate buckets
+// Only matches if the value was previously inserted with the same candidate buckets
 // If the incorrect buckets were specified, it won't go searching for you
 // If the value exists in the table multiple times, it will only remove one
 // Returns:
-// - true if a value was removed from either bucket, false if not
-// - fails if either bucket is out of range
+// - true if a value was removed from any bucket, false if not
+// - fails if any bucket is out of range
 func (t *Table) Remove(item *Item) bool {
-	if item.Bucket1 >= t.numBuckets || item.Bucket2 >= t.numBuckets {
-		t.log.Error.Printf("Remove: invalid buckets (%v, %v)\n", item.Bucket1, item.Bucket2)
+	if !t.inRange(item) {
+		t.log.Error.Printf("Remove: invalid buckets %v\n", item.Candidates())
 		return false
 	}
 
@@ -180,7 +263,45 @@ func (t *Table) Remove(item *Item) bool {
 		nextBucket = item.Bucket1
 	}
 
-	return result || t.removeFromBucket(nextBucket, item)
+	result = result || t.removeFromBucket(nextBucket, item)
+	for _, b := range item.Buckets {
+		result = result || t.removeFromBucket(b, item)
+	}
+	for b := t.numBuckets; !result && b < t.numBuckets+t.stashBuckets; b++ {
+		result = t.removeFromBucket(b, item)
+	}
+	if result {
+		t.drainStash()
+	}
+	return result
+}
+
+// Stash returns the data of the stash, which holds StashSize items. Slots
+// not holding an item are zero.
+func (t *Table) Stash() []byte {
+	return t.stash
+}
+
+// StashSize returns the number of items the stash can hold.
+func (t *Table) StashSize() uint64 {
+	return t.stashBuckets * t.bucketDepth
+}
+
+// SetData replaces the memory area backing the table.
+// data must hold the same contents as the current memory area.
+// Returns: an error if data is not sized for the table
+func (t *Table) SetData(data []byte) error {
+	if uint64(len(data)) != t.numBuckets*t.bucketDepth*t.itemSize {
+		return fmt.Errorf("SetData: len(data)=%v is not equal to numBuckets*bucketDepth*itemSize", len(data))
+	}
+	t.data = data
+	return nil
+}
+
+// OnWrite registers a function called with the index of each bucket whose
+// data is written, allowing the owner of the memory area to track changes.
+func (t *Table) OnWrite(onWrite func(bucketIndex uint64)) {
+	t.onWrite = onWrite
 }
 
 /********************
@@ -197,6 +318,7 @@ func (t *Table) isInBucket(bucketIndex uint64, item *Item) bool {
 		if t.index[idx].filled &&
 			t.index[idx].bucket1 == item.Bucket1 &&
 			t.index[idx].bucket2 == item.Bucket2 &&
+			equalBuckets(t.index[idx].buckets, item.Buckets) &&
 			t.index[idx].id == item.ID {
 			return true
 		}
@@ -204,6 +326,125 @@ func (t *Table) isInBucket(bucketIndex uint64, item *Item) bool {
 	return false
 }
 
+// Checks that all candidate buckets of item are within bounds
+func (t *Table) inRange(item *Item) bool {
+	for _, b := range item.Candidates() {
+		if b >= t.numBuckets {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks if bucket is a candidate bucket of item
+func isCandidate(bucketIndex uint64, item *Item) bool {
+	for _, b := range item.Candidates() {
+		if b == bucketIndex {
+			return true
+		}
+	}
+	return false
+}
+
+// Chooses the bucket to move an item evicted from bucket `from` to. With two
+// choices this is the other bucket. Otherwise it is a candidate with space if
+// there is one, or a random other candidate.
+func (t *Table) nextChoice(item *Item, from uint64) uint64 {
+	if len(item.Buckets) == 0 {
+		if item.Bucket1 == from {
+			return item.Bucket2
+		}
+		return item.Bucket1
+	}
+	others := make([]uint64, 0, len(item.Buckets)+1)
+	skipped := false
+	for _, b := range item.Candidates() {
+		if b == from && !skipped {
+			skipped = true
+			continue
+		}
+		if t.hasSpace(b) {
+			return b
+		}
+		others = append(others, b)
+	}
+	return others[t.rand.Int()%len(others)]
+}
+
+// Checks if a bucket has an empty slot
+func (t *Table) hasSpace(bucketIndex uint64) bool {
+	return t.bucketLoad[bucketIndex] < t.bucketDepth
+}
+
+// Checks if item is in the stash
+func (t *Table) isInStash(item *Item) bool {
+	for b := t.numBuckets; b < t.numBuckets+t.stashBuckets; b++ {
+		if t.isInBucket(b, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the memory holding the data of an item slot
+func (t *Table) slotData(itemIndex uint64) []byte {
+	if itemIndex >= t.numBuckets*t.bucketDepth {
+		itemIndex -= t.numBuckets * t.bucketDepth
+		return t.stash[itemIndex*t.itemSize : (itemIndex+1)*t.itemSize]
+	}
+	return t.data[itemIndex*t.itemSize : (itemIndex+1)*t.itemSize]
+}
+
+// Marks an item slot as filled or empty, updating statistics
+func (t *Table) setFilled(itemIndex uint64, filled bool) {
+	if t.index[itemIndex].filled == filled {
+		return
+	}
+	t.index[itemIndex].filled = filled
+	bucketIndex := itemIndex / t.bucketDepth
+	if bucketIndex < t.numBuckets {
+		t.occupancy[t.bucketLoad[bucketIndex]]--
+	}
+	if filled {
+		t.numElements++
+		t.bucketLoad[bucketIndex]++
+	} else {
+		t.numElements--
+		t.bucketLoad[bucketIndex]--
+	}
+	if bucketIndex < t.numBuckets {
+		t.occupancy[t.bucketLoad[bucketIndex]]++
+	}
+}
+
+// Frees an item slot
+func (t *Table) clearSlot(itemIndex uint64) {
+	t.setFilled(itemIndex, false)
+	if itemIndex >= t.numBuckets*t.bucketDepth {
+		data := t.slotData(itemIndex)
+		for i := range data {
+			data[i] = 0
+		}
+	}
+}
+
+// Moves items from the stash into their buckets, where they have space
+func (t *Table) drainStash() {
+	for i := t.numBuckets * t.bucketDepth; i < uint64(len(t.index)); i++ {
+		item := t.getItem(i)
+		if item == nil {
+			continue
+		}
+		item = item.Copy()
+		for _, b := range item.Candidates() {
+			if t.tryInsertToBucket(b, item) {
+				t.clearSlot(i)
+				break
+			}
+		}
+	}
+}
+
 // Tries to inserts an item into specified bucket
 // If the bucket is already full, no-op
 // Preconditions:
@@ -214,11 +455,18 @@ func (t *Table) tryInsertToBucket(bucketIndex uint64, item *Item) bool {
 	// Search for an empty slot
 	for i := bucketIndex * t.bucketDepth; i < (bucketIndex+1)*t.bucketDepth; i++ {
 		if !t.index[i].filled {
-			copy(t.data[i*t.itemSize:], item.Data)
+			copy(t.slotData(i), item.Data)
+			if t.onWrite != nil && bucketIndex < t.numBuckets {
+				t.onWrite(bucketIndex)
+			}
 			t.index[i].id = item.ID
 			t.index[i].bucket1 = item.Bucket1
 			t.index[i].bucket2 = item.Bucket2
-			t.index[i].filled = true
+			t.index[i].buckets = nil
+			if item.Buckets != nil {
+				t.index[i].buckets = append([]uint64{}, item.Buckets...)
+			}
+			t.setFilled(i, true)
 			return true
 		}
 	}
@@ -235,7 +483,7 @@ func (t *Table) tryInsertToBucket(bucketIndex uint64, item *Item) bool {
 // - false if insertion triggered an eviction
 //   other values contain the evicted item's alternate bucket, BucketLocation pair, and value
 func (t *Table) insertAndEvict(bucketIndex uint64, item *Item) (bool, *Item) {
-	if item.Bucket1 != bucketIndex && item.Bucket2 != bucketIndex {
+	if !isCandidate(bucketIndex, item) {
 		return false, item
 	}
 	if t.tryInsertToBucket(bucketIndex, item) {
@@ -245,7 +493,7 @@ func (t *Table) insertAndEvict(bucketIndex uint64, item *Item) (bool, *Item) {
 	// Eviction
 	itemIndex := bucketIndex*t.bucketDepth + (uint64(t.rand.Int63()) % t.bucketDepth)
 	removedItem := t.getItem(itemIndex).Copy()
-	t.index[itemIndex].filled = false
+	t.setFilled(itemIndex, false)
 
 	if !t.tryInsertToBucket(bucketIndex, item) {
 		t.log.Error.Fatalf("insertAndEvict: no space in bucket after eviction!")
@@ -262,7 +510,7 @@ func (t *Table) insertAndEvict(bucketIndex uint64, item *Item) (bool, *Item) {
 func (t *Table) removeFromBucket(bucketIndex uint64, item *Item) bool {
 	for i := bucketIndex * t.bucketDepth; i < (bucketIndex+1)*t.bucketDepth; i++ {
 		if item != nil && item.Equals(t.getItem(i)) {
-			t.index[i].filled = false
+			t.clearSlot(i)
 			return true
 		}
 	}
@@ -275,7 +523,8 @@ func (t *Table) getItem(itemIndex uint64) *Item {
 	}
 	return &Item{
 		t.index[itemIndex].id,
-		t.data[itemIndex*t.itemSize : (itemIndex+1)*t.itemSize],
+		t.slotData(itemIndex),
 		t.index[itemIndex].bucket1,
-		t.index[itemIndex].bucket2}
+		t.index[itemIndex].bucket2,
+		t.index[itemIndex].buckets}
 }
@@ -5,8 +5,11 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math/rand"
+	"reflect"
 	"strconv"
 	"testing"
+
+	"github.com/privacylab/talek/common"
 )
 
 const testItemSize = uint64(64)
@@ -66,12 +69,12 @@ func TestBasic(t *testing.T) {
 	}
 
 	fmt.Printf("TestBasic: Check contains non-existent value...\n")
-	if table.Contains(&Item{0, GetBytes(""), 0, 1}) {
+	if table.Contains(&Item{0, GetBytes(""), 0, 1, nil}) {
 		t.Fatalf("empty table returned true for Contains()\n")
 	}
 
 	fmt.Printf("TestBasic: remove non-existent value ...\n")
-	if table.Remove(&Item{1, GetBytes("value1"), 0, 1}) {
+	if table.Remove(&Item{1, GetBytes("value1"), 0, 1, nil}) {
 		t.Fatalf("empty table returned true for Remove()\n")
 	}
 
@@ -81,29 +84,29 @@ func TestBasic(t *testing.T) {
 	}
 
 	fmt.Printf("TestBasic: Insert improperly sized value ...\n")
-	ok, itm := table.Insert(&Item{1, []byte{0, 0}, 0, 1})
+	ok, itm := table.Insert(&Item{1, []byte{0, 0}, 0, 1, nil})
 	if itm != nil || ok {
 		t.Fatalf("should have failed inserting a malformed item\n")
 	}
 
 	fmt.Printf("TestBasic: Insert value ...\n")
-	ok, itm = table.Insert(&Item{1, GetBytes("value1"), 0, 1})
+	ok, itm = table.Insert(&Item{1, GetBytes("value1"), 0, 1, nil})
 	if itm != nil || !ok {
 		t.Fatalf("error inserting into table (0, 1, value1)\n")
 	}
 
 	fmt.Printf("TestBasic: Check inserted value...\n")
-	if !table.Contains(&Item{1, GetBytes("value1"), 0, 1}) {
+	if !table.Contains(&Item{1, GetBytes("value1"), 0, 1, nil}) {
 		t.Fatalf("cannot find recently inserted value\n")
 	}
 
 	fmt.Printf("TestBasic: Check inserted value w/o full reference...\n")
-	if !table.Contains(&Item{1, GetBytes(""), 0, 1}) {
+	if !table.Contains(&Item{1, GetBytes(""), 0, 1, nil}) {
 		t.Fatalf("cannot find recently inserted value\n")
 	}
 
 	fmt.Printf("TestBasic: Check non-existent value...\n")
-	if table.Contains(&Item{2, GetBytes("value2"), 0, 1}) {
+	if table.Contains(&Item{2, GetBytes("value2"), 0, 1, nil}) {
 		t.Fatalf("contains a non-existent value\n")
 	}
 
@@ -113,7 +116,7 @@ func TestBasic(t *testing.T) {
 	}
 
 	fmt.Printf("TestBasic: remove existing value ...\n")
-	if !table.Remove(&Item{1, GetBytes("value1"), 0, 1}) {
+	if !table.Remove(&Item{1, GetBytes("value1"), 0, 1, nil}) {
 		t.Fatalf("error removing existing value (0, 1, value1)\n")
 	}
 
@@ -123,7 +126,7 @@ func TestBasic(t *testing.T) {
 	}
 
 	fmt.Printf("TestBasic: remove recently removed value ...\n")
-	if table.Remove(&Item{1, GetBytes("value1"), 0, 1}) {
+	if table.Remove(&Item{1, GetBytes("value1"), 0, 1, nil}) {
 		t.Fatalf("empty table returned true for Remove()\n")
 	}
 
@@ -140,9 +143,9 @@ func TestBucket(t *testing.T) {
 	table := NewTable("t", 10, 2, testItemSize, nil, 0)
 
 	items := []*Item{
-		{1, GetBytes("value1"), 5, 5},
-		{2, GetBytes("value2"), 5, 5},
-		{3, GetBytes("value3"), 5, 6},
+		{1, GetBytes("value1"), 5, 5, nil},
+		{2, GetBytes("value2"), 5, 5, nil},
+		{3, GetBytes("value3"), 5, 6, nil},
 	}
 	for _, v := range items {
 		ok, _ := table.Insert(v)
@@ -164,7 +167,7 @@ func TestBucket(t *testing.T) {
 		t.Fatalf("Table should report expected item position %v", items[2])
 	}
 
-	nonItem := &Item{4, GetBytes("value4"), 1, 1}
+	nonItem := &Item{4, GetBytes("value4"), 1, 1, nil}
 	_, err = table.Bucket(nonItem)
 	if err == nil {
 		t.Fatalf("Table should report expected item position %v", nonItem)
@@ -177,7 +180,7 @@ func TestOutOfBounds(t *testing.T) {
 	table := NewTable("t", 10, 2, testItemSize, nil, 0)
 
 	fmt.Printf("TestOutOfBounds: Insert() out of bounds...\n")
-	ok, itm := table.Insert(&Item{1, GetBytes("value1"), 100, 100})
+	ok, itm := table.Insert(&Item{1, GetBytes("value1"), 100, 100, nil})
 	if ok {
 		t.Fatalf("Insert returned true with out of bound buckets\n")
 	}
@@ -186,12 +189,12 @@ func TestOutOfBounds(t *testing.T) {
 	}
 
 	fmt.Printf("TestOutOfBounds: Contains() out of bounds...\n")
-	if table.Contains(&Item{1, GetBytes("value1"), 100, 100}) {
+	if table.Contains(&Item{1, GetBytes("value1"), 100, 100, nil}) {
 		t.Fatalf("Contains returned true with out of bound buckets\n")
 	}
 
 	fmt.Printf("TestOutOfBounds: Remove() out of bounds...\n")
-	if table.Remove(&Item{1, GetBytes("value1"), 100, 100}) {
+	if table.Remove(&Item{1, GetBytes("value1"), 100, 100, nil}) {
 		t.Fatalf("Remove() returned true with out of bound buckets\n")
 	}
 
@@ -217,12 +220,12 @@ func TestFullTable(t *testing.T) {
 		b2 = randBucket(numBuckets)
 		id := rand.Uint64()
 		val := GetBytes(strconv.Itoa(rand.Int()))
-		entries = append(entries, Item{id, nil, b1, b2})
-		ok, evic = table.Insert(&Item{id, val, b1, b2})
+		entries = append(entries, Item{id, nil, b1, b2, nil})
+		ok, evic = table.Insert(&Item{id, val, b1, b2, nil})
 
 		if ok {
 			count++
-			if !table.Contains(&Item{id, nil, b1, b2}) {
+			if !table.Contains(&Item{id, nil, b1, b2, nil}) {
 				t.Fatalf("Insert() succeeded, but Contains failed\n")
 			}
 			if count != table.GetNumElements() {
@@ -275,30 +278,30 @@ func TestDuplicateValues(t *testing.T) {
 	fmt.Printf("TestDuplicateValues: ...\n")
 	table := NewTable("t", 10, 2, testItemSize, nil, 0)
 
-	ok, itm := table.Insert(&Item{1, GetBytes("v"), 0, 1})
+	ok, itm := table.Insert(&Item{1, GetBytes("v"), 0, 1, nil})
 	if itm != nil || !ok {
 		t.Fatalf("Error inserting value \n")
 	}
 
-	ok, itm = table.Insert(&Item{2, GetBytes("v"), 0, 1})
+	ok, itm = table.Insert(&Item{2, GetBytes("v"), 0, 1, nil})
 	if itm != nil || !ok {
 		t.Fatalf("Error inserting value again \n")
 	}
 
-	ok, itm = table.Insert(&Item{3, GetBytes("v"), 1, 2})
+	ok, itm = table.Insert(&Item{3, GetBytes("v"), 1, 2, nil})
 	if itm != nil || !ok {
 		t.Fatalf("Error inserting value in shifted buckets\n")
 	}
 
-	if !table.Remove(&Item{1, GetBytes("v"), 0, 1}) {
+	if !table.Remove(&Item{1, GetBytes("v"), 0, 1, nil}) {
 		t.Fatalf("Error removing value 1st time\n")
 	}
 
-	if !table.Remove(&Item{2, GetBytes("v"), 0, 1}) {
+	if !table.Remove(&Item{2, GetBytes("v"), 0, 1, nil}) {
 		t.Fatalf("Error removing value 2nd time\n")
 	}
 
-	if !table.Remove(&Item{3, GetBytes("v"), 1, 2}) {
+	if !table.Remove(&Item{3, GetBytes("v"), 1, 2, nil}) {
 		t.Fatalf("Error removing value 3rd time\n")
 	}
 
@@ -317,7 +320,7 @@ func TestLoadFactor(t *testing.T) {
 		for ok {
 			count++
 			val := GetBytes(strconv.Itoa(rand.Int()))
-			ok, _ = table.Insert(&Item{rand.Uint64(), val, randBucket(numBuckets), randBucket(numBuckets)})
+			ok, _ = table.Insert(&Item{rand.Uint64(), val, randBucket(numBuckets), randBucket(numBuckets), nil})
 		}
 
 		if table.GetNumElements() != uint64(count) {
@@ -333,6 +336,285 @@ func TestLoadFactor(t *testing.T) {
 	fmt.Printf("... done\n")
 }
 
+func TestSetDataOnWrite(t *testing.T) {
+	fmt.Printf("TestSetDataOnWrite: ...\n")
+	data := make([]byte, 4*2*testItemSize)
+	table := NewTable("t", 4, 2, testItemSize, data, 0)
+	written := make(map[uint64]bool)
+	table.OnWrite(func(bucket uint64) {
+		written[bucket] = true
+	})
+
+	table.Insert(&Item{1, GetBytes("v1"), 2, 2, nil})
+	if len(written) != 1 || !written[2] {
+		t.Fatalf("OnWrite should report a write to bucket 2, got %v\n", written)
+	}
+
+	if table.SetData(make([]byte, 1)) == nil {
+		t.Fatalf("SetData should fail with a mis-sized memory area\n")
+	}
+	next := make([]byte, len(data))
+	copy(next, data)
+	if err := table.SetData(next); err != nil {
+		t.Fatalf("SetData failed: %v\n", err)
+	}
+	table.Insert(&Item{2, GetBytes("v2"), 3, 3, nil})
+	if !bytes.Equal(next[3*2*testItemSize:][:testItemSize], GetBytes("v2")) {
+		t.Fatalf("Insert after SetData should write to the new memory area\n")
+	}
+	if !bytes.Equal(data[3*2*testItemSize:][:testItemSize], make([]byte, testItemSize)) {
+		t.Fatalf("Insert after SetData should not write to the old memory area\n")
+	}
+
+	fmt.Printf("... done\n")
+}
+
+func TestStash(t *testing.T) {
+	fmt.Printf("TestStash: ...\n")
+	// All items share a single bucket of depth 1, so all but one are stashed.
+	table := NewStashTable("t", 2, 1, testItemSize, nil, 0, 1)
+	written := make([]uint64, 0)
+	table.OnWrite(func(bucketIndex uint64) {
+		written = append(written, bucketIndex)
+	})
+	items := make([]*Item, 3)
+	for i := range items {
+		items[i] = &Item{ID: uint64(i + 1), Data: GetBytes("item" + strconv.Itoa(i)), Bucket1: 0, Bucket2: 0}
+	}
+	if ok, _ := table.Insert(items[0]); !ok {
+		t.Fatalf("failed to insert into empty table\n")
+	}
+	if ok, _ := table.Insert(items[1]); !ok {
+		t.Fatalf("failed to insert into stash\n")
+	}
+	if ok, _ := table.Insert(items[2]); ok {
+		t.Fatalf("insert should fail once the stash is full\n")
+	}
+	stashed := items[1]
+	if bucket, _ := table.Bucket(items[0]); bucket == 2 {
+		stashed = items[0]
+	}
+	if bucket, err := table.Bucket(stashed); err != nil || bucket != 2 {
+		t.Fatalf("stashed item reported in bucket %d: %v\n", bucket, err)
+	}
+	if !bytes.Equal(table.Stash(), stashed.Data) {
+		t.Fatalf("stash does not hold the stashed item\n")
+	}
+	for _, b := range written {
+		if b >= 2 {
+			t.Fatalf("OnWrite called for stash bucket %d\n", b)
+		}
+	}
+
+	// Removing the item in bucket 0 moves the stashed item there.
+	other := items[0]
+	if stashed == items[0] {
+		other = items[1]
+	}
+	if !table.Remove(other) {
+		t.Fatalf("failed to remove item\n")
+	}
+	if bucket, err := table.Bucket(stashed); err != nil || bucket != 0 {
+		t.Fatalf("stashed item not moved to its bucket, in %d: %v\n", bucket, err)
+	}
+	if !bytes.Equal(table.Stash(), make([]byte, testItemSize)) {
+		t.Fatalf("stash not cleared after moving its item\n")
+	}
+	fmt.Printf("... done \n")
+}
+
+func TestMarshalBinary(t *testing.T) {
+	fmt.Printf("TestMarshalBinary: ...\n")
+	numBuckets := uint64(16)
+	table := NewStashTable("t", numBuckets, 2, testItemSize, nil, 3, 1)
+	for i := uint64(0); i < 20; i++ {
+		table.Insert(&Item{ID: i + 1, Data: GetBytes(strconv.Itoa(int(i))), Bucket1: randBucket(numBuckets), Bucket2: randBucket(numBuckets)})
+	}
+	encoded, err := table.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal table: %v\n", err)
+	}
+
+	restored := NewStashTable("r", numBuckets, 2, testItemSize, nil, 0, 1)
+	if err = restored.UnmarshalBinary(encoded); err != nil {
+		t.Fatalf("failed to unmarshal table: %v\n", err)
+	}
+	if restored.Digest() != table.Digest() {
+		t.Fatalf("restored table has a different layout\n")
+	}
+	if !bytes.Equal(restored.Stash(), table.Stash()) {
+		t.Fatalf("restored table has a different stash\n")
+	}
+
+	// Identical operations keep the tables identical.
+	itm := &Item{ID: 100, Data: GetBytes("100"), Bucket1: 1, Bucket2: 2}
+	table.Insert(itm)
+	restored.Insert(itm)
+	if restored.Digest() != table.Digest() {
+		t.Fatalf("restored table diverged after an insert\n")
+	}
+
+	empty := &Table{}
+	if err = empty.UnmarshalBinary(encoded); err != nil || empty.GetCapacity() != table.GetCapacity() {
+		t.Fatalf("failed to unmarshal into an empty table: %v\n", err)
+	}
+	mismatched := NewTable("m", numBuckets, 1, testItemSize, nil, 0)
+	if err = mismatched.UnmarshalBinary(encoded); err == nil {
+		t.Fatalf("unmarshal into a differently sized table should fail\n")
+	}
+	if err = restored.UnmarshalBinary(encoded[:len(encoded)-1]); err == nil {
+		t.Fatalf("unmarshal of a truncated table should fail\n")
+	}
+	digest := restored.Digest()
+	if err = restored.UnmarshalBinary(append(encoded, 0)); err == nil {
+		t.Fatalf("unmarshal of a table with trailing data should fail\n")
+	}
+	if restored.Digest() != digest {
+		t.Fatalf("failed unmarshal modified the table\n")
+	}
+	fmt.Printf("... done \n")
+}
+
+func TestUnmarshalBinaryInvalid(t *testing.T) {
+	numBuckets := uint64(16)
+	table := NewTable("t", numBuckets, 2, testItemSize, nil, 0)
+	table.Insert(&Item{ID: 1, Data: GetBytes("1"), Bucket1: 0, Bucket2: 0})
+	encoded, _ := table.MarshalBinary()
+	// The first slot follows the version and header, with its filled flag, id,
+	// buckets and count of further buckets.
+	slot := 1 + 5*8
+
+	outOfRange := append([]byte{}, encoded...)
+	outOfRange[slot+1+8] = byte(numBuckets)
+	choices := append([]byte{}, encoded...)
+	choices[slot+1+3*8] = common.MaxBucketChoices - 1
+	sizing := append([]byte{}, encoded...)
+	sizing[1] = 0
+
+	restored := NewTable("r", numBuckets, 2, testItemSize, nil, 0)
+	digest := restored.Digest()
+	for name, data := range map[string][]byte{"an out of range bucket": outOfRange, "too many bucket choices": choices} {
+		if err := restored.UnmarshalBinary(data); err == nil {
+			t.Fatalf("unmarshal of a table with %s should fail\n", name)
+		}
+	}
+	if err := (&Table{}).UnmarshalBinary(sizing); err == nil {
+		t.Fatalf("unmarshal of a table without buckets should fail\n")
+	}
+	if restored.Digest() != digest {
+		t.Fatalf("failed unmarshal modified the table\n")
+	}
+}
+
+func TestStats(t *testing.T) {
+	fmt.Printf("TestStats: ...\n")
+	table := NewStashTable("t", 4, 2, testItemSize, nil, 0, 1)
+	items := make([]*Item, 0)
+	for i := uint64(0); i < 4; i++ {
+		itm := &Item{ID: i + 1, Data: GetBytes(strconv.Itoa(int(i))), Bucket1: 0, Bucket2: 1}
+		items = append(items, itm)
+		if ok, _ := table.Insert(itm); !ok {
+			t.Fatalf("failed to insert item %d\n", i)
+		}
+	}
+	// Buckets 0 and 1 are full, so further items go to the stash or fail.
+	for i := uint64(4); i < 7; i++ {
+		table.Insert(&Item{ID: i + 1, Data: GetBytes(strconv.Itoa(int(i))), Bucket1: 0, Bucket2: 1})
+	}
+
+	stats := table.Stats()
+	if stats.Elements != 6 || table.GetNumElements() != 6 || stats.Stashed != 2 {
+		t.Fatalf("unexpected element counts %+v\n", stats)
+	}
+	if stats.LoadFactor != 0.5 || stats.Inserts != 7 || stats.Failures != 1 {
+		t.Fatalf("unexpected load or insert counts %+v\n", stats)
+	}
+	if stats.BucketOccupancy[0] != 2 || stats.BucketOccupancy[2] != 2 {
+		t.Fatalf("unexpected bucket occupancy %v\n", stats.BucketOccupancy)
+	}
+	chains := make([]uint64, MaxEvictions+1)
+	chains[0], chains[MaxEvictions] = 4, 3
+	if !reflect.DeepEqual(stats.EvictionChains, chains) {
+		t.Fatalf("unexpected eviction chains %v\n", stats.EvictionChains)
+	}
+
+	table.Remove(items[0])
+	stats = table.Stats()
+	if stats.Elements != 5 || stats.Stashed != 1 || stats.BucketOccupancy[2] != 2 {
+		t.Fatalf("unexpected stats after remove %+v\n", stats)
+	}
+	fmt.Printf("... done \n")
+}
+
+func TestEvictionChains(t *testing.T) {
+	fmt.Printf("TestEvictionChains: ...\n")
+	table := NewTable("t", 4, 1, testItemSize, nil, 0)
+	blocker := &Item{ID: 1, Data: GetBytes("1"), Bucket1: 3, Bucket2: 3}
+	evicted := &Item{ID: 2, Data: GetBytes("2"), Bucket1: 0, Bucket2: 3}
+	evicting := &Item{ID: 3, Data: GetBytes("3"), Bucket1: 0, Bucket2: 0}
+
+	// The blocker keeps the second item out of bucket 3, until it is removed.
+	table.Insert(blocker)
+	table.Insert(evicted)
+	table.Remove(blocker)
+	// The third item can only be placed by moving the second to bucket 3.
+	if ok, _ := table.Insert(evicting); !ok {
+		t.Fatalf("failed to insert evicting item\n")
+	}
+	if bucket, _ := table.Bucket(evicted); bucket != 3 {
+		t.Fatalf("evicted item in bucket %d rather than 3\n", bucket)
+	}
+
+	chains := make([]uint64, MaxEvictions+1)
+	chains[0], chains[1] = 2, 1
+	if stats := table.Stats(); !reflect.DeepEqual(stats.EvictionChains, chains) {
+		t.Fatalf("unexpected eviction chains %v\n", stats.EvictionChains)
+	}
+	fmt.Printf("... done \n")
+}
+
+func TestChoices(t *testing.T) {
+	fmt.Printf("TestChoices: ...\n")
+	numBuckets := uint64(1024)
+	table := NewTable("t", numBuckets, 1, testItemSize, nil, 0)
+	items := make([]*Item, 0)
+	// Three choices place items well beyond the load factor of two choices.
+	for i := uint64(0); i < numBuckets*8/10; i++ {
+		itm := &Item{ID: i + 1, Data: GetBytes(strconv.Itoa(int(i))),
+			Bucket1: randBucket(numBuckets), Bucket2: randBucket(numBuckets),
+			Buckets: []uint64{randBucket(numBuckets)}}
+		if ok, _ := table.Insert(itm); !ok {
+			t.Fatalf("failed to insert item %d with three choices\n", i)
+		}
+		items = append(items, itm)
+	}
+	for _, itm := range items {
+		bucket, err := table.Bucket(itm)
+		if err != nil || !isCandidate(bucket, itm) {
+			t.Fatalf("item %d placed in %d, not a candidate: %v\n", itm.ID, bucket, err)
+		}
+	}
+	other := *items[0]
+	other.Buckets = []uint64{(items[0].Buckets[0] + 1) % numBuckets}
+	if table.Contains(&other) {
+		t.Fatalf("items with other candidates should not match\n")
+	}
+
+	encoded, _ := table.MarshalBinary()
+	restored := &Table{}
+	if err := restored.UnmarshalBinary(encoded); err != nil || restored.Digest() != table.Digest() {
+		t.Fatalf("failed to restore table with three choices: %v\n", err)
+	}
+	if !restored.Remove(items[0]) || restored.Contains(items[0]) {
+		t.Fatalf("failed to remove item with three choices\n")
+	}
+	outOfRange := &Item{ID: 0, Data: GetBytes(""), Bucket1: 0, Bucket2: 0, Buckets: []uint64{numBuckets}}
+	if ok, _ := table.Insert(outOfRange); ok {
+		t.Fatalf("items with out of range candidates should not be inserted\n")
+	}
+	fmt.Printf("... done \n")
+}
+
 func BenchmarkInserts(b *testing.B) {
 	//numMessages := uint64(1073741824) //2^30
 	numMessages := uint64(268435456) //2^28
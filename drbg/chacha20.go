@@ -0,0 +1,102 @@
+package drbg
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// chacha20 is the ChaCha20 stream cipher of RFC 8439, with a 32 byte key, a
+// 12 byte nonce and a 32 bit block counter. It implements cipher.Stream.
+type chacha20 struct {
+	state  [16]uint32
+	block  [64]byte
+	offset int // Bytes of block already used
+}
+
+// chachaConstants are the first words of the state, "expand 32-byte k".
+var chachaConstants = [4]uint32{0x61707865, 0x3320646e, 0x79622d32, 0x6b206574}
+
+func newChaCha20(key []byte, nonce []byte, counter uint32) *chacha20 {
+	c := &chacha20{}
+	c.reset(key, nonce, counter)
+	return c
+}
+
+// reset sets the key, nonce and block counter of the key stream.
+func (c *chacha20) reset(key []byte, nonce []byte, counter uint32) {
+	copy(c.state[:4], chachaConstants[:])
+	for i := 0; i < 8; i++ {
+		c.state[4+i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	c.state[12] = counter
+	for i := 0; i < 3; i++ {
+		c.state[13+i] = binary.LittleEndian.Uint32(nonce[i*4:])
+	}
+	c.offset = len(c.block)
+}
+
+func quarterRound(a, b, c, d uint32) (uint32, uint32, uint32, uint32) {
+	a += b
+	d = bits.RotateLeft32(d^a, 16)
+	c += d
+	b = bits.RotateLeft32(b^c, 12)
+	a += b
+	d = bits.RotateLeft32(d^a, 8)
+	c += d
+	b = bits.RotateLeft32(b^c, 7)
+	return a, b, c, d
+}
+
+// refill computes the block of the current counter into the buffered block.
+func (c *chacha20) refill() {
+	c.core(c.block[:])
+	c.offset = 0
+}
+
+// core computes the block of the current counter into the first 64 bytes of
+// out, and advances it.
+func (c *chacha20) core(out []byte) {
+	x := c.state
+	for i := 0; i < 10; i++ {
+		x[0], x[4], x[8], x[12] = quarterRound(x[0], x[4], x[8], x[12])
+		x[1], x[5], x[9], x[13] = quarterRound(x[1], x[5], x[9], x[13])
+		x[2], x[6], x[10], x[14] = quarterRound(x[2], x[6], x[10], x[14])
+		x[3], x[7], x[11], x[15] = quarterRound(x[3], x[7], x[11], x[15])
+		x[0], x[5], x[10], x[15] = quarterRound(x[0], x[5], x[10], x[15])
+		x[1], x[6], x[11], x[12] = quarterRound(x[1], x[6], x[11], x[12])
+		x[2], x[7], x[8], x[13] = quarterRound(x[2], x[7], x[8], x[13])
+		x[3], x[4], x[9], x[14] = quarterRound(x[3], x[4], x[9], x[14])
+	}
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[i*4:], x[i]+c.state[i])
+	}
+	c.state[12]++
+}
+
+// keyStream fills dst with the key stream. Whole blocks are computed in place.
+func (c *chacha20) keyStream(dst []byte) {
+	if c.offset < len(c.block) {
+		n := copy(dst, c.block[c.offset:])
+		c.offset += n
+		dst = dst[n:]
+	}
+	for len(dst) >= len(c.block) {
+		c.core(dst)
+		dst = dst[len(c.block):]
+	}
+	if len(dst) > 0 {
+		c.refill()
+		c.offset = copy(dst, c.block[:])
+	}
+}
+
+// XORKeyStream xors src with the key stream into dst.
+func (c *chacha20) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.offset == len(c.block) {
+			c.refill()
+		}
+		dst[i] = src[i] ^ c.block[c.offset]
+		c.offset++
+	}
+}
@@ -0,0 +1,78 @@
+package drbg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// Drbg is a deterministic random bit generator, expanding a Seed into a
+// pseudorandom sequence.
+type Drbg interface {
+	// FillBytes fills a byte slice from the random sequence.
+	FillBytes(b []byte)
+	// XORBytes xors a byte slice with the random sequence.
+	XORBytes(b []byte)
+	// RandomUint32 provides the next 4 bytes of the sequence as an integer.
+	RandomUint32() uint32
+	// RandomUint64 provides the next 8 bytes of the sequence as an integer.
+	RandomUint64() uint64
+}
+
+// NewDrbg creates the generator of the algorithm of seed. A new Seed for the
+// DefaultAlgorithm is used when seed is nil.
+func NewDrbg(seed *Seed) (Drbg, error) {
+	if seed == nil {
+		newSeed, err := NewSeed()
+		if err != nil {
+			return nil, err
+		}
+		seed = newSeed
+	}
+	switch seed.Algorithm() {
+	case SipHash:
+		return NewHashDrbg(seed)
+	case AESCTR:
+		block, err := aes.NewCipher(seed.keyMaterial())
+		if err != nil {
+			return nil, err
+		}
+		return &streamDrbg{stream: cipher.NewCTR(block, make([]byte, aes.BlockSize))}, nil
+	case ChaCha20:
+		return &streamDrbg{stream: newChaCha20(seed.keyMaterial(), make([]byte, 12), 0)}, nil
+	}
+	return nil, errors.New("unknown DRBG algorithm")
+}
+
+// streamDrbg is a Drbg producing the key stream of a stream cipher.
+type streamDrbg struct {
+	mu     sync.Mutex
+	stream cipher.Stream
+}
+
+func (d *streamDrbg) XORBytes(b []byte) {
+	d.mu.Lock()
+	d.stream.XORKeyStream(b, b)
+	d.mu.Unlock()
+}
+
+func (d *streamDrbg) FillBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	d.XORBytes(b)
+}
+
+func (d *streamDrbg) RandomUint32() uint32 {
+	var b [4]byte
+	d.FillBytes(b[:])
+	return binary.LittleEndian.Uint32(b[:])
+}
+
+func (d *streamDrbg) RandomUint64() uint64 {
+	var b [8]byte
+	d.FillBytes(b[:])
+	return binary.LittleEndian.Uint64(b[:])
+}
@@ -0,0 +1,115 @@
+package drbg
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const katKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// Known answers for the first 64 bytes generated from a seed of katKey.
+var knownAnswers = map[Algorithm]string{
+	// SipHash uses the first 24 bytes of the key
+	SipHash: "28b572b88c1ab776a8de197d8e7de4b34d3a58549e36707c9c0614afb5a7a147",
+	// AES-256-CTR with a zero IV
+	AESCTR: "f29000b62a499fd0a9f39a6add2e7780f05d76ae4ab99fe5a6f69b3148c2363d" +
+		"0ebcb5deb52c83bd08a8a935182c9199d24356532881602f809eb383c5ff5d56",
+	// ChaCha20 with a zero nonce and counter
+	ChaCha20: "39fd2b7dd9c5196a8dbd0377b8dc4a498a35d86fbcde6accb2cc7d4cd8ea2492" +
+		"2b23cce7a26023ab3f0eef693ac87f64258235eab1f7a32dc22762a0485b410c",
+}
+
+func TestKnownAnswers(t *testing.T) {
+	key, _ := hex.DecodeString(katKey)
+	for alg, answer := range knownAnswers {
+		seed, err := NewSeedFromKey(alg, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewDrbg(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := hex.DecodeString(answer)
+		out := make([]byte, len(expected))
+		d.FillBytes(out[:len(out)/2])
+		d.FillBytes(out[len(out)/2:])
+		if alg != SipHash && !bytes.Equal(out, expected) {
+			t.Errorf("Unexpected output of algorithm %d: %x", alg, out)
+		}
+		// HashDrbg discards the rest of a block after FillBytes
+		if alg == SipHash && !bytes.Equal(out[:16], expected[:16]) {
+			t.Errorf("Unexpected output of algorithm %d: %x", alg, out)
+		}
+	}
+}
+
+func TestChaCha20(t *testing.T) {
+	// RFC 8439, section 2.4.2
+	key, _ := hex.DecodeString(katKey)
+	nonce, _ := hex.DecodeString("00000000000000000000004a00000000")
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+	expected, _ := hex.DecodeString("6e2e359a2568f98041ba0728dd0d6981e97e7aec1d4360c20a27afccfd9fae0b" +
+		"f91b65c5524733ab8f593dabcd62b3571639d624e65152ab8f530c359f0861d8" +
+		"07ca0dbf500d6a6156a38e088a22b65e52bc514d16ccf806818ce91ab7793736" +
+		"5af90bbf74a35be6b40b8eedf2785e42874d")
+	out := make([]byte, len(plaintext))
+	newChaCha20(key, nonce[4:], 1).XORKeyStream(out, plaintext)
+	if !bytes.Equal(out, expected) {
+		t.Errorf("Unexpected ChaCha20 ciphertext: %x", out)
+	}
+
+	// ChaCha20-Poly1305 encrypts with the key stream from block 1.
+	aead, _ := chacha20poly1305.New(key)
+	sealed := aead.Seal(nil, nonce[4:], plaintext, nil)
+	if !bytes.Equal(out, sealed[:len(plaintext)]) {
+		t.Errorf("ChaCha20 differs from ChaCha20-Poly1305")
+	}
+}
+
+func TestOverlayAlgorithms(t *testing.T) {
+	for _, alg := range []Algorithm{SipHash, AESCTR, ChaCha20} {
+		s, err := NewSeedFor(alg, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seed, _ := s.MarshalBinary()
+		buffer := make([]byte, 100)
+		if err := Overlay(seed, buffer); err != nil {
+			t.Fatal(err)
+		}
+		d, _ := NewDrbg(s)
+		expected := make([]byte, 100)
+		d.XORBytes(expected)
+		if !bytes.Equal(buffer, expected) {
+			t.Errorf("Overlay of algorithm %d differs from its Drbg", alg)
+		}
+	}
+
+	seed := make([]byte, SeedLength)
+	seed[0] = byte(ChaCha20) + 1
+	if Overlay(seed, make([]byte, 8)) == nil {
+		t.Errorf("Overlay accepted seed of unknown algorithm")
+	}
+}
+
+func TestLegacySeed(t *testing.T) {
+	legacy, _ := hex.DecodeString(katKey[:2*legacySeedLength])
+	s := Seed{}
+	if err := s.UnmarshalBinary(legacy); err != nil {
+		t.Fatal(err)
+	}
+	if s.Algorithm() != SipHash || !bytes.Equal(s.Key(), legacy[:16]) || !bytes.Equal(s.InitVec(), legacy[16:]) {
+		t.Errorf("Legacy seed restored incorrectly")
+	}
+
+	text, _ := s.MarshalText()
+	restored := Seed{}
+	if err := restored.UnmarshalText(text); err != nil || !Equal(&s, &restored) {
+		t.Errorf("Seed text encoding failed to round trip: %v", err)
+	}
+}
@@ -2,7 +2,6 @@ package drbg
 
 import (
 	"encoding/binary"
-	"errors"
 	"hash"
 	"sync"
 
@@ -69,12 +68,12 @@ func (d *HashDrbg) RandomUint64() uint64 {
 	return ret
 }
 
-// FillBytes fills a byte slice from the random number sequence.
-func (d *HashDrbg) FillBytes(b []byte) {
+// XORBytes xors a byte slice with the random number sequence.
+func (d *HashDrbg) XORBytes(b []byte) {
 	randBytes := d.Next()
 
 	for i := 0; i < len(b); i++ {
-		b[i] = randBytes[0]
+		b[i] ^= randBytes[0]
 		if len(randBytes) < 2 {
 			randBytes = d.Next()
 		} else {
@@ -83,29 +82,16 @@ func (d *HashDrbg) FillBytes(b []byte) {
 	}
 }
 
-// Overlay is a static method for xoring a byte array with the deterministic
-// random sequence generated from a provided seed.
-func Overlay(seed, data []byte) error {
-	if len(seed) < SeedLength {
-		return errors.New("invalid seed provided")
-	}
-	s := Seed{}
-	if err := s.UnmarshalBinary(seed); err != nil {
-		return err
-	}
-	d, err := NewHashDrbg(&s)
-	if err != nil {
-		return err
-	}
+// FillBytes fills a byte slice from the random number sequence.
+func (d *HashDrbg) FillBytes(b []byte) {
+	randBytes := d.Next()
 
-	dbytes := d.Next()
-	for i := 0; i < len(data); i++ {
-		data[i] ^= dbytes[0]
-		if len(dbytes) < 2 {
-			dbytes = d.Next()
+	for i := 0; i < len(b); i++ {
+		b[i] = randBytes[0]
+		if len(randBytes) < 2 {
+			randBytes = d.Next()
 		} else {
-			dbytes = dbytes[1:]
+			randBytes = randBytes[1:]
 		}
 	}
-	return nil
 }
@@ -0,0 +1,117 @@
+package drbg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"sync"
+
+	"github.com/dchest/siphash"
+	"github.com/privacylab/talek/pir/xor"
+)
+
+// padBlockSize is the number of bytes of key stream a Pad generates at a time.
+const padBlockSize = 4096
+
+// Pad applies the key stream of a seed to buffers, generating it a block at a
+// time into memory owned by the Pad. A Pad is reused for other seeds with
+// Reset, so pads are applied without allocations for each block. Only the
+// key schedule of AES-CTR seeds is allocated, by Reset.
+// The key stream is that of the Drbg of the seed.
+type Pad struct {
+	alg   Algorithm
+	block [padBlockSize]byte
+
+	// SipHash state
+	sip  hash.Hash64
+	ofb  [siphash.Size]byte
+	used int // Bytes of ofb already used
+
+	chacha chacha20
+	stream cipher.Stream // AES-CTR state
+	iv     [aes.BlockSize]byte
+}
+
+// padPool holds the Pads used by Overlay.
+var padPool = sync.Pool{New: func() interface{} { return new(Pad) }}
+
+// Reset starts the key stream of seed, a Seed in its binary encoding.
+func (p *Pad) Reset(seed []byte) error {
+	s := Seed{}
+	if err := s.UnmarshalBinary(seed); err != nil {
+		return err
+	}
+	p.alg = s.Algorithm()
+	switch p.alg {
+	case SipHash:
+		p.sip = siphash.New(s.Key())
+		copy(p.ofb[:], s.InitVec())
+		p.used = len(p.ofb)
+	case AESCTR:
+		block, err := aes.NewCipher(s.keyMaterial())
+		if err != nil {
+			return err
+		}
+		p.stream = cipher.NewCTR(block, p.iv[:])
+	case ChaCha20:
+		var nonce [12]byte
+		p.chacha.reset(s.keyMaterial(), nonce[:], 0)
+	default:
+		return errors.New("unknown DRBG algorithm")
+	}
+	return nil
+}
+
+// XOR xors data with the next len(data) bytes of the key stream.
+func (p *Pad) XOR(data []byte) {
+	if p.alg == AESCTR {
+		p.stream.XORKeyStream(data, data)
+		return
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > len(p.block) {
+			n = len(p.block)
+		}
+		p.fill(p.block[:n])
+		words := n &^ 7
+		xor.Words(data[:words], data[:words], p.block[:words])
+		for i := words; i < n; i++ {
+			data[i] ^= p.block[i]
+		}
+		data = data[n:]
+	}
+}
+
+// fill writes the next len(buf) bytes of the key stream to buf.
+func (p *Pad) fill(buf []byte) {
+	if p.alg == ChaCha20 {
+		p.chacha.keyStream(buf)
+		return
+	}
+	for len(buf) > 0 {
+		if p.used == len(p.ofb) {
+			// The OFB chain of HashDrbg.Next
+			p.sip.Write(p.ofb[:])
+			binary.LittleEndian.PutUint64(p.ofb[:], p.sip.Sum64())
+			p.used = 0
+		}
+		n := copy(buf, p.ofb[p.used:])
+		p.used += n
+		buf = buf[n:]
+	}
+}
+
+// Overlay is a static method for xoring a byte array with the deterministic
+// random sequence generated from a provided seed.
+func Overlay(seed, data []byte) error {
+	p := padPool.Get().(*Pad)
+	defer padPool.Put(p)
+	if err := p.Reset(seed); err != nil {
+		return err
+	}
+	p.XOR(data)
+	return nil
+}
@@ -0,0 +1,74 @@
+package drbg
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+var algorithms = []Algorithm{SipHash, AESCTR, ChaCha20}
+
+func TestPad(t *testing.T) {
+	for _, alg := range algorithms {
+		s, _ := NewSeedFor(alg, rand.Reader)
+		seed, _ := s.MarshalBinary()
+		d, _ := NewDrbg(s)
+		expected := make([]byte, 3*padBlockSize+13)
+		d.XORBytes(expected)
+
+		// Streamed in pieces, across block boundaries
+		p := &Pad{}
+		if err := p.Reset(seed); err != nil {
+			t.Fatal(err)
+		}
+		out := make([]byte, len(expected))
+		rest := out
+		for _, piece := range []int{5, 64, padBlockSize + 3, len(rest)} {
+			if piece > len(rest) {
+				piece = len(rest)
+			}
+			p.XOR(rest[:piece])
+			rest = rest[piece:]
+		}
+		if !bytes.Equal(out, expected) {
+			t.Errorf("Pad of algorithm %d differs from its Drbg", alg)
+		}
+	}
+}
+
+func TestPadAllocations(t *testing.T) {
+	for _, alg := range []Algorithm{SipHash, ChaCha20} {
+		s, _ := NewSeedFor(alg, rand.Reader)
+		seed, _ := s.MarshalBinary()
+		p := &Pad{}
+		p.Reset(seed)
+		data := make([]byte, 3*padBlockSize)
+		if allocs := testing.AllocsPerRun(10, func() { p.XOR(data) }); allocs != 0 {
+			t.Errorf("Pad of algorithm %d allocates %v times", alg, allocs)
+		}
+	}
+}
+
+func benchmarkOverlay(b *testing.B, alg Algorithm, size int) {
+	s, _ := NewSeedFor(alg, rand.Reader)
+	seed, _ := s.MarshalBinary()
+	data := make([]byte, size)
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = Overlay(seed, data)
+	}
+}
+
+func BenchmarkOverlaySipHash(b *testing.B) {
+	benchmarkOverlay(b, SipHash, 8192)
+}
+
+func BenchmarkOverlayAESCTR(b *testing.B) {
+	benchmarkOverlay(b, AESCTR, 8192)
+}
+
+func BenchmarkOverlayChaCha20(b *testing.B) {
+	benchmarkOverlay(b, ChaCha20, 8192)
+}
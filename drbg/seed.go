@@ -6,26 +6,61 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"io"
 
 	"github.com/dchest/siphash"
 )
 
+// Algorithm identifies the generator expanding a Seed.
+type Algorithm byte
+
+const (
+	// SipHash is SipHash-2-4 in OFB mode, expanded by HashDrbg.
+	SipHash Algorithm = iota
+	// AESCTR is AES-256 in counter mode.
+	AESCTR
+	// ChaCha20 is the ChaCha20 stream cipher of RFC 8439.
+	ChaCha20
+)
+
+// DefaultAlgorithm is the algorithm of seeds created by NewSeed.
+const DefaultAlgorithm = AESCTR
+
+// KeyLength is the number of bytes of key material in a seed.
+const KeyLength = 32
+
 // SeedLength is the number of bytes a drbg seed takes in memory.
-const SeedLength = 16 + siphash.Size
+const SeedLength = 1 + KeyLength
+
+// legacySeedLength is the length of seeds which do not record their
+// algorithm, and are SipHash seeds.
+const legacySeedLength = 16 + siphash.Size
 
-// Seed holds the state of a deterministic HashDrbg.
-// - SipHash-2-4 keys: key0 and key1
-// - 8 byte nonce (initialization vector)
+// Seed holds the state of a deterministic random bit generator.
+//   - The Algorithm of the generator (1 byte)
+//   - 32 bytes of key material. SipHash uses the first 16 bytes as
+//     SipHash-2-4 keys key0 and key1, and the next 8 bytes as nonce
+//     (initialization vector). AESCTR and ChaCha20 use all 32 bytes as key,
+//     with a zero nonce.
 type Seed struct {
-	value []byte // Key (first 16 bytes) + InitVec (8 bytes)
+	value []byte // Algorithm (1 byte) + key material (32 bytes)
 }
 
-// NewSeed creates a new Seed
+// NewSeed creates a new Seed for the DefaultAlgorithm
 func NewSeed() (*Seed, error) {
+	return NewSeedFor(DefaultAlgorithm, rand.Reader)
+}
+
+// NewSeedFor creates a new Seed for alg with key material read from rand.
+func NewSeedFor(alg Algorithm, rand io.Reader) (*Seed, error) {
+	if !alg.valid() {
+		return nil, errors.New("unknown DRBG algorithm")
+	}
 	seed := &Seed{}
 
 	seed.value = make([]byte, SeedLength)
-	_, err := rand.Read(seed.value)
+	seed.value[0] = byte(alg)
+	_, err := io.ReadFull(rand, seed.value[1:])
 	if err != nil {
 		return nil, err
 	}
@@ -33,11 +68,33 @@ func NewSeed() (*Seed, error) {
 	return seed, nil
 }
 
+// NewSeedFromKey creates a Seed for alg from the first KeyLength bytes of key.
+func NewSeedFromKey(alg Algorithm, key []byte) (*Seed, error) {
+	if len(key) < KeyLength {
+		return nil, errors.New("invalid DRBG key. Too few bytes")
+	}
+	return NewSeedFor(alg, bytes.NewReader(key))
+}
+
+func (a Algorithm) valid() bool {
+	return a <= ChaCha20
+}
+
 // UnmarshalBinary reconstructs a Seed from a binary implementation, implementing the interface.
+// Seeds of legacySeedLength bytes are restored as SipHash seeds.
 func (s *Seed) UnmarshalBinary(data []byte) error {
+	if len(data) == legacySeedLength {
+		s.value = make([]byte, SeedLength)
+		s.value[0] = byte(SipHash)
+		copy(s.value[1:], data)
+		return nil
+	}
 	if len(data) < SeedLength {
 		return errors.New("invalid DRBG seed. Too few bytes")
 	}
+	if !Algorithm(data[0]).valid() {
+		return errors.New("invalid DRBG seed. Unknown algorithm")
+	}
 	s.value = data
 	return nil
 }
@@ -54,13 +111,14 @@ func (s *Seed) MarshalText() ([]byte, error) {
 
 // UnmarshalText restores the seed from a Text representation.
 func (s *Seed) UnmarshalText(data []byte) error {
-	if err := json.Unmarshal(data, &s.value); err != nil {
+	var value []byte
+	if err := json.Unmarshal(data, &value); err != nil {
 		return err
 	}
-	if len(s.value) != SeedLength {
+	if len(value) != SeedLength && len(value) != legacySeedLength {
 		return errors.New("invalid drbg seed length")
 	}
-	return nil
+	return s.UnmarshalBinary(value)
 }
 
 // Equal is used to test equality of two drbg seeds.
@@ -68,19 +126,29 @@ func Equal(a, b *Seed) bool {
 	return bytes.Equal(a.value, b.value)
 }
 
-// Key provides the byte representation of the underlying key for the Seed
+// Algorithm provides the algorithm of the generator the Seed is for.
+func (s *Seed) Algorithm() Algorithm {
+	return Algorithm(s.value[0])
+}
+
+// Key provides the byte representation of the underlying SipHash key for the Seed
 func (s *Seed) Key() []byte {
-	return s.value[:16]
+	return s.value[1:17]
 }
 
-// KeyUint128 provides a representation of the underlying key as two 64bit ints.
+// KeyUint128 provides a representation of the underlying SipHash key as two 64bit ints.
 func (s *Seed) KeyUint128() (uint64, uint64) {
-	s1, _ := binary.Uvarint(s.value[0:8])
-	s2, _ := binary.Uvarint(s.value[8:16])
+	s1, _ := binary.Uvarint(s.value[1:9])
+	s2, _ := binary.Uvarint(s.value[9:17])
 	return s1, s2
 }
 
-// InitVec provides the initialization vector of the seed.
+// InitVec provides the SipHash initialization vector of the seed.
 func (s *Seed) InitVec() []byte {
-	return s.value[16:]
+	return s.value[17:25]
+}
+
+// keyMaterial provides the key material of the seed.
+func (s *Seed) keyMaterial() []byte {
+	return s.value[1:SeedLength]
 }
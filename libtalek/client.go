@@ -1,21 +1,18 @@
 package libtalek
 
 import (
-	"bufio"
-	"bytes"
-	"compress/flate"
 	"crypto/rand"
 	"errors"
+	"fmt"
 	"io"
-	"math"
 	"math/big"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/privacylab/talek/bloom"
 	"github.com/privacylab/talek/common"
 	"github.com/privacylab/talek/drbg"
-	"github.com/willscott/bloom"
 )
 
 // Client represents a connection to the Talek system. Typically created with
@@ -33,6 +30,7 @@ type Client struct {
 	writeCount     int
 	writeMutex     sync.Mutex
 	writeWaiters   *sync.Cond
+	wallet         tokenWallet
 
 	pendingReads chan request
 	handleMutex  sync.Mutex
@@ -43,6 +41,9 @@ type Client struct {
 	// Used to synchronize fetches of global interest vector.
 	lastInterestSN uint64
 
+	// Hint of the database for EncodingLWE reads.
+	hint atomic.Value // *common.GetHintReply
+
 	// for debugging / testing
 	Verbose bool
 	Rand    io.Reader
@@ -69,8 +70,7 @@ func NewClient(name string, config ClientConfig, leader common.FrontendInterface
 	c.pendingWrites = make(chan *common.WriteArgs, 5)
 	c.pendingUpdates = make(chan bool, 5)
 
-	bfSize := math.Ceil(math.Log2(float64(config.NumBuckets)))
-	iv, err := bloom.New(rand.Reader, int(bfSize), config.BloomFalsePositive)
+	iv, err := bloom.NewFilter(bloom.KeyFromSeed(config.InterestSeed), config.WindowSize(), config.BloomFalsePositive)
 	if err != nil {
 		c.log.Error.Printf("Failed to initialize interest vector: %v", err)
 		return nil
@@ -83,6 +83,7 @@ func NewClient(name string, config ClientConfig, leader common.FrontendInterface
 	go c.readPeriodic()
 	go c.writePeriodic()
 	go c.updatePeriodic()
+	go c.hintPeriodic()
 
 	return c
 }
@@ -143,6 +144,23 @@ func (c *Client) Publish(handle *Topic, data []byte) error {
 	return nil
 }
 
+// PublishKeyword publishes the record of a keyword topic, replacing the
+// records published before it. Records stay readable for a window of the
+// database, so publishers republish them each window.
+func (c *Client) PublishKeyword(topic *KeywordTopic, value []byte) error {
+	config := c.config.Load().(ClientConfig)
+	writeArgs, err := topic.GeneratePublish(config.Config, value)
+	if err != nil {
+		return err
+	}
+
+	c.writeMutex.Lock()
+	c.writeCount++
+	c.writeMutex.Unlock()
+	c.pendingWrites <- writeArgs
+	return nil
+}
+
 // Flush blocks until the the client has finished in-progress reads and writes.
 func (c *Client) Flush() {
 	c.writeMutex.Lock()
@@ -227,10 +245,18 @@ func (c *Client) writePeriodic() {
 		default:
 			req = c.generateRandomWrite(conf)
 		}
+		if req != nil {
+			req.Token = c.nextToken(&conf)
+		}
 		err := c.leader.Write(req, &reply)
 		if err != nil {
 			reply.Err = err.Error()
 		}
+		if reply.TokenRejected {
+			// Keys other than the pinned key are refused, so tokens are
+			// kept until they expire.
+			c.log.Warn.Printf("Write token rejected: %s\n", reply.Err)
+		}
 		if reply.GlobalSeqNo > c.lastSeqNo {
 			c.lastSeqNo = reply.GlobalSeqNo
 		}
@@ -266,6 +292,13 @@ func (c *Client) readPeriodic() {
 				reply.Err = err.Error()
 			}
 		}
+		// Responses to lwe reads are decoded with the hint of the database read.
+		if len(req.ReadArgs.Secret) > 0 && reply.Err == "" {
+			req.ReadArgs.Hint, err = c.hintOf(reply.Epoch)
+			if err != nil {
+				c.log.Warn.Printf("Dropping lwe read: %v\n", err)
+			}
+		}
 		if reply.GlobalSeqNo.End > c.lastSeqNo {
 			c.lastSeqNo = reply.GlobalSeqNo.End
 		}
@@ -298,26 +331,76 @@ func (c *Client) updatePeriodic() {
 		reply := common.GetUpdatesReply{}
 		c.leader.GetUpdates(&req, &reply)
 
-		// Decompress.
-		var decompressedInterest bytes.Buffer
-		writer := bufio.NewWriter(&decompressedInterest)
-		reader := flate.NewReader(bytes.NewReader(reply.InterestVector))
-		if _, err := io.Copy(writer, reader); err != nil {
-			c.log.Warn.Printf("Failed to decompress interest update: %v\n", err)
-			continue
-		}
-
-		// signatures are on the uncompressed data.
+		// signatures are on the encoded vector.
 		//if err := reply.Validate(conf.TrustDomains); err != nil {
 		//	c.log.Warn.Printf("Failed to retrieve interest update: %v\n", err)
 		//	continue
 		//}
 
-		c.interestVector.Import(decompressedInterest.Bytes())
+		if err := c.interestVector.Reset(reply.InterestVector); err != nil {
+			c.log.Warn.Printf("Failed to import interest update: %v\n", err)
+			continue
+		}
 		c.prioritizeRequests()
 	}
 }
 
+// hintPeriodic fetches the hint of the database every write interval, when
+// reads use the EncodingLWE request encoding.
+func (c *Client) hintPeriodic() {
+	for atomic.LoadInt32(&c.dead) == 0 {
+		conf := c.config.Load().(ClientConfig)
+		if conf.RequestEncoding == common.EncodingLWE {
+			if err := c.fetchHint(); err != nil {
+				c.log.Warn.Printf("Failed to fetch database hint: %v\n", err)
+			}
+		}
+		time.Sleep(conf.WriteInterval)
+	}
+}
+
+// fetchHint fetches the hint of the current database, unless the database is
+// still in the epoch of the hint held.
+func (c *Client) fetchHint() error {
+	req := &common.GetHintArgs{}
+	held, _ := c.hint.Load().(*common.GetHintReply)
+	if held != nil {
+		req.Epoch = held.Epoch
+	}
+	reply := &common.GetHintReply{}
+	err := c.leader.GetHint(req, reply)
+	if err == nil && reply.Err != "" {
+		err = errors.New(reply.Err)
+	}
+	if err != nil {
+		return err
+	}
+	if len(reply.Hint) == 0 {
+		if held == nil || reply.Epoch != held.Epoch {
+			return errors.New("frontend sent no hint")
+		}
+		return nil
+	}
+	c.hint.Store(reply)
+	return nil
+}
+
+// hintOf returns the hint of the database in epoch, fetching the hint again
+// if the database changed since it was last fetched.
+func (c *Client) hintOf(epoch uint64) ([]byte, error) {
+	hint, _ := c.hint.Load().(*common.GetHintReply)
+	if hint == nil || hint.Epoch != epoch {
+		if err := c.fetchHint(); err != nil {
+			return nil, err
+		}
+		hint = c.hint.Load().(*common.GetHintReply)
+	}
+	if hint.Epoch != epoch {
+		return nil, fmt.Errorf("database changed from epoch %d to %d since the read", epoch, hint.Epoch)
+	}
+	return hint.Hint, nil
+}
+
 func (c *Client) generateRandomWrite(config ClientConfig) *common.WriteArgs {
 	args := &common.WriteArgs{}
 	var max big.Int
@@ -325,6 +408,10 @@ func (c *Client) generateRandomWrite(config ClientConfig) *common.WriteArgs {
 	b2, _ := rand.Int(c.Rand, max.SetUint64(config.NumBuckets))
 	args.Bucket1 = b1.Uint64()
 	args.Bucket2 = b2.Uint64()
+	for i := 2; i < config.Choices(); i++ {
+		b, _ := rand.Int(c.Rand, max.SetUint64(config.NumBuckets))
+		args.Buckets = append(args.Buckets, b.Uint64())
+	}
 	args.Data = make([]byte, config.Config.DataSize, config.Config.DataSize)
 	if _, err := c.Rand.Read(args.Data); err != nil {
 		return nil
@@ -333,6 +420,17 @@ func (c *Client) generateRandomWrite(config ClientConfig) *common.WriteArgs {
 }
 
 func (c *Client) generateRandomRead(config *ClientConfig) *common.ReadArgs {
+	// DPF keys, seeded requests and lwe queries of random reads must be well formed to look like real reads.
+	if config.Config.RequestEncoding == common.EncodingDPF || config.Config.RequestEncoding == common.EncodingSeed ||
+		config.Config.RequestEncoding == common.EncodingLWE {
+		var max big.Int
+		bucket, _ := rand.Int(c.Rand, max.SetUint64(config.Config.NumBuckets))
+		args := makeReadArg(config, bucket.Uint64(), c.Rand)
+		if args == nil {
+			c.log.Error.Fatalf("Failed to generate %s encoded read for %d trust domains.\n", config.Config.RequestEncoding, len(config.TrustDomains))
+		}
+		return args
+	}
 	args := &common.ReadArgs{}
 	vectorSize := uint32((config.Config.NumBuckets+7)/8 + 1)
 	args.TD = make([]common.PirArgs, len(config.TrustDomains), len(config.TrustDomains))
@@ -376,15 +474,18 @@ func (c *Client) nextRequest(config *ClientConfig) request {
 		c.handles = c.handles[1:]
 		c.handles = append(c.handles, nextTopic)
 
-		ra1, ra2, err := nextTopic.generatePoll(config, c.Rand)
+		args, err := nextTopic.generatePoll(config, c.Rand)
 		if err != nil {
 			c.handleMutex.Unlock()
 			c.log.Error.Fatal(err)
 			return request{c.generateRandomRead(config), nil}
 		}
-		c.pendingReads <- request{ra2, nextTopic}
+		// pendingReads is empty here, and has room for MaxBucketChoices-1 reads.
+		for _, ra := range args[1:] {
+			c.pendingReads <- request{ra, nextTopic}
+		}
 		c.handleMutex.Unlock()
-		return request{ra1, nextTopic}
+		return request{args[0], nextTopic}
 	}
 	c.handleMutex.Unlock()
 
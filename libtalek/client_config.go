@@ -3,6 +3,7 @@ package libtalek
 import (
 	"encoding/json"
 	"io/ioutil"
+	"strings"
 	"time"
 
 	"github.com/privacylab/talek/common"
@@ -24,6 +25,12 @@ type ClientConfig struct {
 
 	// Where should the client connect?
 	FrontendAddr string
+	// The DER certificate of the frontend, pinned when FrontendAddr is https.
+	FrontendCertificate []byte
+	// The modulus of the write token key of the frontend, whose exponent must
+	// be 65537. Required to write to frontends issuing write tokens, as a
+	// frontend issuing tokens under other keys could link them to the client.
+	TokenKey []byte
 }
 
 // ClientConfigFromFile restores a client configuration from on-disk form.
@@ -39,3 +46,17 @@ func ClientConfigFromFile(file string) *ClientConfig {
 
 	return config
 }
+
+// NewFrontendRPC creates a stub for calls to the frontend at FrontendAddr.
+// Frontends with https addresses must present the FrontendCertificate.
+func (c *ClientConfig) NewFrontendRPC(name string) (*common.FrontendRPC, error) {
+	f := common.NewFrontendRPC(name, c.FrontendAddr)
+	if strings.HasPrefix(c.FrontendAddr, "https://") {
+		tlsConfig, err := common.PinnedTLSConfig(c.FrontendCertificate)
+		if err != nil {
+			return nil, err
+		}
+		f.SetTransport(common.NewTLSTransport(common.DefaultCallTimeout, tlsConfig))
+	}
+	return f, nil
+}
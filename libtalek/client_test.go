@@ -1,7 +1,10 @@
 package libtalek
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/binary"
+	"sync"
 	"testing"
 	"time"
 
@@ -36,6 +39,17 @@ func (m *mockLeader) Read(args *common.EncodedReadArgs, reply *common.ReadReply)
 func (m *mockLeader) GetUpdates(args *common.GetUpdatesArgs, reply *common.GetUpdatesReply) error {
 	return nil
 }
+func (m *mockLeader) GetHint(args *common.GetHintArgs, reply *common.GetHintReply) error {
+	return nil
+}
+func (m *mockLeader) GetTokenKey(args *common.GetTokenKeyArgs, reply *common.GetTokenKeyReply) error {
+	reply.Expires = time.Now().Add(time.Hour)
+	return nil
+}
+func (m *mockLeader) IssueTokens(args *common.IssueTokensArgs, reply *common.IssueTokensReply) error {
+	reply.Err = "write tokens are not required"
+	return nil
+}
 
 func TestWrite(t *testing.T) {
 	config := ClientConfig{
@@ -44,6 +58,8 @@ func TestWrite(t *testing.T) {
 		time.Second,
 		[]*common.TrustDomainConfig{common.NewTrustDomainConfig("TestTrustDomain", "127.0.0.1", true, false)},
 		"",
+		nil,
+		nil,
 	}
 
 	writes := make(chan *common.WriteArgs, 1)
@@ -83,6 +99,8 @@ func TestRead(t *testing.T) {
 			common.NewTrustDomainConfig("TestTrustDomain1", "127.0.0.1", true, false),
 		},
 		"",
+		nil,
+		nil,
 	}
 
 	reads := make(chan *common.EncodedReadArgs, 1)
@@ -126,3 +144,189 @@ func TestRead(t *testing.T) {
 		t.Fatalf("Read wasn't for the enqueued subscription. %v / %v / %d", rv1, rv2, bucket)
 	}
 }
+
+// tokenLeader issues write tokens under its key.
+type tokenLeader struct {
+	*mockLeader
+	key    *rsa.PrivateKey
+	tokens int
+}
+
+func (l *tokenLeader) GetTokenKey(args *common.GetTokenKeyArgs, reply *common.GetTokenKeyReply) error {
+	reply.Epoch = 1
+	reply.Expires = time.Now().Add(time.Hour)
+	reply.Modulus = l.key.N.Bytes()
+	reply.Exponent = l.key.E
+	reply.Tokens = l.tokens
+	reply.WritesPerToken = 2
+	return nil
+}
+func (l *tokenLeader) IssueTokens(args *common.IssueTokensArgs, reply *common.IssueTokensReply) error {
+	for _, b := range args.Blinded {
+		sig, err := common.SignBlindedToken(l.key, b)
+		if err != nil {
+			return err
+		}
+		reply.Signatures = append(reply.Signatures, sig)
+	}
+	return nil
+}
+
+func TestWriteTokens(t *testing.T) {
+	config := ClientConfig{
+		Config:        &common.Config{NumBuckets: 64, BucketDepth: 4, DataSize: 1024, BloomFalsePositive: 0.05, MaxLoadFactor: 0.95, LoadFactorStep: 0.05, InterestMultiple: 1000},
+		WriteInterval: time.Millisecond,
+		ReadInterval:  time.Second,
+		TrustDomains:  []*common.TrustDomainConfig{common.NewTrustDomainConfig("TestTrustDomain", "127.0.0.1", true, false)},
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes := make(chan *common.WriteArgs, 1)
+	leader := &tokenLeader{&mockLeader{writes, nil}, key, 2}
+
+	// A client without a pinned key takes no tokens from the frontend.
+	c := NewClient("TestWriteTokensUnpinned", config, leader)
+	if token := (<-writes).Token; token != nil {
+		t.Errorf("Client used tokens of a key it does not pin")
+	}
+	c.Kill()
+
+	config.TokenKey = key.N.Bytes()
+	c = NewClient("TestWriteTokens", config, leader)
+	if c == nil {
+		t.Fatalf("Error creating client")
+	}
+	var tokens []*common.WriteToken
+	for i := 0; i < 5; i++ {
+		tokens = append(tokens, (<-writes).Token)
+	}
+	c.Kill()
+
+	for i, token := range tokens[:4] {
+		if token == nil || !common.VerifyToken(&key.PublicKey, token) {
+			t.Fatalf("Write %d was made without a valid token", i)
+		}
+	}
+	if tokens[0] != tokens[1] || tokens[2] != tokens[3] || tokens[1] == tokens[2] {
+		t.Errorf("Tokens were not used for WritesPerToken writes each")
+	}
+	if tokens[4] != nil {
+		t.Errorf("Write was made with a token beyond the allowance")
+	}
+
+	// A client pinning another key refuses the tokens of the frontend.
+	other, _ := rsa.GenerateKey(rand.Reader, 1024)
+	config.TokenKey = other.N.Bytes()
+	c = NewClient("TestWriteTokensPinned", config, leader)
+	if token := (<-writes).Token; token != nil {
+		t.Errorf("Client used tokens of a key it does not pin")
+	}
+	c.Kill()
+}
+
+// restartingLeader rejects writes with tokens of other keys than its own,
+// which is replaced when the frontend restarts without a key file.
+type restartingLeader struct {
+	*tokenLeader
+	lock sync.Mutex
+}
+
+func (l *restartingLeader) GetTokenKey(args *common.GetTokenKeyArgs, reply *common.GetTokenKeyReply) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.tokenLeader.GetTokenKey(args, reply)
+}
+func (l *restartingLeader) IssueTokens(args *common.IssueTokensArgs, reply *common.IssueTokensReply) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.tokenLeader.IssueTokens(args, reply)
+}
+func (l *restartingLeader) Write(args *common.WriteArgs, reply *common.WriteReply) error {
+	l.lock.Lock()
+	if args.Token == nil || !common.VerifyToken(&l.key.PublicKey, args.Token) {
+		reply.Err = "invalid write token"
+		reply.TokenRejected = true
+	}
+	l.lock.Unlock()
+	return l.tokenLeader.Write(args, reply)
+}
+func (l *restartingLeader) restart(key *rsa.PrivateKey) {
+	l.lock.Lock()
+	l.key = key
+	l.lock.Unlock()
+}
+
+func TestWriteTokensRestart(t *testing.T) {
+	config := ClientConfig{
+		Config:        &common.Config{NumBuckets: 64, BucketDepth: 4, DataSize: 1024, BloomFalsePositive: 0.05, MaxLoadFactor: 0.95, LoadFactorStep: 0.05, InterestMultiple: 1000},
+		WriteInterval: time.Millisecond,
+		ReadInterval:  time.Second,
+		TrustDomains:  []*common.TrustDomainConfig{common.NewTrustDomainConfig("TestTrustDomain", "127.0.0.1", true, false)},
+	}
+	key, _ := rsa.GenerateKey(rand.Reader, 1024)
+	writes := make(chan *common.WriteArgs, 1)
+	leader := &restartingLeader{tokenLeader: &tokenLeader{&mockLeader{writes, nil}, key, 100}}
+
+	config.TokenKey = key.N.Bytes()
+	c := NewClient("TestWriteTokensRestart", config, leader)
+	if c == nil {
+		t.Fatalf("Error creating client")
+	}
+	defer c.Kill()
+	if token := (<-writes).Token; token == nil || !common.VerifyToken(&key.PublicKey, token) {
+		t.Fatalf("Write was made without a valid token")
+	}
+
+	// Tokens of the pinned key are rejected by a frontend restarted with
+	// another key, which the client refuses to take tokens under.
+	restarted, _ := rsa.GenerateKey(rand.Reader, 1024)
+	leader.restart(restarted)
+	for i := 0; i < 5; i++ {
+		if token := (<-writes).Token; token != nil && !common.VerifyToken(&key.PublicKey, token) {
+			t.Fatalf("Client took tokens of a key other than the pinned key")
+		}
+	}
+}
+
+// hintLeader serves hints of a database in epoch.
+type hintLeader struct {
+	*mockLeader
+	epoch   uint64
+	fetches int // Hints sent
+}
+
+func (l *hintLeader) GetHint(args *common.GetHintArgs, reply *common.GetHintReply) error {
+	reply.Epoch = l.epoch
+	if args.Epoch != l.epoch {
+		reply.Hint = []byte{byte(l.epoch)}
+		l.fetches++
+	}
+	return nil
+}
+
+func TestHintEpochs(t *testing.T) {
+	leader := &hintLeader{mockLeader: &mockLeader{}, epoch: 5}
+	c := &Client{log: common.NewLogger("TestHintEpochs"), leader: leader}
+
+	hint, err := c.hintOf(5)
+	if err != nil || hint[0] != 5 {
+		t.Fatalf("Failed to fetch hint of the epoch read: %v", err)
+	}
+	// The hint held is not sent again while the epoch is unchanged.
+	if err := c.fetchHint(); err != nil || leader.fetches != 1 {
+		t.Fatalf("Hint of an unchanged epoch was sent again: %v", err)
+	}
+	if hint, err := c.hintOf(5); err != nil || hint[0] != 5 {
+		t.Fatalf("Hint held was dropped: %v", err)
+	}
+	// A read of a later epoch fetches its hint.
+	leader.epoch = 6
+	if hint, err := c.hintOf(6); err != nil || hint[0] != 6 {
+		t.Fatalf("Hint was not fetched for a read of a later epoch: %v", err)
+	}
+	if _, err := c.hintOf(5); err == nil {
+		t.Errorf("Read of a previous epoch was given a hint")
+	}
+}
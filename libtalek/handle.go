@@ -13,6 +13,7 @@ import (
 	"github.com/dchest/siphash"
 	"github.com/privacylab/talek/common"
 	"github.com/privacylab/talek/drbg"
+	"github.com/privacylab/talek/pir/lwe"
 	"github.com/privacylab/talek/pir/pirclient"
 	"golang.org/x/crypto/nacl/box"
 )
@@ -24,7 +25,7 @@ import (
 // read from the Handle.
 type Handle struct {
 	// for random looking pir requests
-	drbg *drbg.HashDrbg
+	drbg drbg.Drbg
 
 	// For learning log positions
 	Seed1 *drbg.Seed
@@ -48,6 +49,9 @@ type Handle struct {
 
 	// log for messages
 	log *common.Logger
+
+	// Set for the handles of keywords, whose record is read at fixed positions.
+	keyword *KeywordHandle
 }
 
 //NewHandle creates a new topic handle, without attachment to a specific topic.
@@ -61,7 +65,7 @@ func initHandle(h *Handle) (err error) {
 	h.updates = make(chan []byte)
 	h.hasher = sha256.New()
 
-	h.drbg, err = drbg.NewHashDrbg(nil)
+	h.drbg, err = drbg.NewDrbg(nil)
 	return
 }
 
@@ -83,6 +87,24 @@ func (h *Handle) nextBuckets(conf *common.Config) (uint64, uint64) {
 	return b1, b2
 }
 
+// nextChoices returns all candidate buckets of the next poll or publish of
+// this topic: the pair of nextBuckets, followed by further buckets when the
+// config has more than two BucketChoices.
+func (h *Handle) nextChoices(conf *common.Config) []uint64 {
+	b1, b2 := h.nextBuckets(conf)
+	buckets := []uint64{b1, b2}
+
+	seqNoBytes := make([]byte, 24)
+	_ = binary.PutUvarint(seqNoBytes, h.Seqno)
+	k0, k1 := h.Seed1.KeyUint128()
+	for i := 2; i < conf.Choices(); i++ {
+		// The varint sequence number leaves the last byte for the choice.
+		seqNoBytes[23] = byte(i)
+		buckets = append(buckets, siphash.Hash(k0, k1, seqNoBytes)%conf.NumBuckets)
+	}
+	return buckets
+}
+
 // nextInterestVector returns the bytes that will be used to set the bloom filter location
 // the next time this handle is written to.
 func (h *Handle) nextInterestVector() []byte {
@@ -98,35 +120,64 @@ func makeReadArg(config *ClientConfig, bucket uint64, rand io.Reader) *common.Re
 	arg.TD = make([]common.PirArgs, num)
 
 	pirClient := pirclient.NewClient("pirclient")
-	reqVec, err := pirClient.GenerateRequestVectors(bucket, uint64(num), config.Config.NumBuckets)
+	var reqVec, dpfKeys, reqSeeds [][]byte
+	var err error
+	switch config.Config.RequestEncoding {
+	case common.EncodingDPF:
+		if num != 2 {
+			return nil
+		}
+		dpfKeys, err = pirClient.GenerateDPFKeys(bucket, config.Config.NumBuckets)
+	case common.EncodingLWE:
+		if num != 1 {
+			return nil
+		}
+		var vec []byte
+		vec, arg.Secret, err = pirClient.GenerateLWEQuery(bucket, config.Config.NumBuckets)
+		reqVec = [][]byte{vec}
+	case common.EncodingSeed:
+		var vec []byte
+		vec, reqSeeds, err = pirClient.GenerateSeededRequest(bucket, uint64(num), config.Config.NumBuckets)
+		reqVec = [][]byte{vec}
+	default:
+		reqVec, err = pirClient.GenerateRequestVectors(bucket, uint64(num), config.Config.NumBuckets)
+	}
 	if err != nil {
 		return nil
 	}
 
 	for i := 0; i < num; i++ {
-		arg.TD[i].RequestVector = reqVec[i]
-		arg.TD[i].PadSeed = make([]byte, drbg.SeedLength)
-		if _, err := rand.Read(arg.TD[i].PadSeed); err != nil {
+		if dpfKeys != nil {
+			arg.TD[i].DPFKey = dpfKeys[i]
+		} else if i < len(reqVec) {
+			arg.TD[i].RequestVector = reqVec[i]
+		} else {
+			arg.TD[i].RequestSeed = reqSeeds[i-len(reqVec)]
+		}
+		padSeed, err := drbg.NewSeedFor(drbg.DefaultAlgorithm, rand)
+		if err != nil {
 			return nil
 		}
+		arg.TD[i].PadSeed, _ = padSeed.MarshalBinary()
 
 	}
 
 	return arg
 }
 
-func (h *Handle) generatePoll(config *ClientConfig, rand io.Reader) (*common.ReadArgs, *common.ReadArgs, error) {
+// generatePoll creates a read of each candidate bucket of the next message.
+func (h *Handle) generatePoll(config *ClientConfig, rand io.Reader) ([]*common.ReadArgs, error) {
 	if h.SharedSecret == nil || h.SigningPublicKey == nil {
-		return nil, nil, errors.New("Subscription not fully initialized")
+		return nil, errors.New("Subscription not fully initialized")
 	}
 
-	args := make([]*common.ReadArgs, 2)
-	bucket1, bucket2 := h.nextBuckets(config.Config)
-
-	args[0] = makeReadArg(config, bucket1, rand)
-	args[1] = makeReadArg(config, bucket2, rand)
+	buckets := h.nextChoices(config.Config)
+	args := make([]*common.ReadArgs, len(buckets))
+	for i, bucket := range buckets {
+		args[i] = makeReadArg(config, bucket, rand)
+	}
 
-	return args[0], args[1], nil
+	return args, nil
 }
 
 // Decrypt attempts decryption of a message for a topic using a specific nonce.
@@ -159,6 +210,12 @@ func (h *Handle) Decrypt(cyphertext []byte, nonce *[24]byte) ([]byte, error) {
 // OnResponse processes a response for a request generated by generatePoll,
 // sending it to the handle's updates channel if valid.
 func (h *Handle) OnResponse(args *common.ReadArgs, reply *common.ReadReply, dataSize uint) {
+	if h.keyword != nil {
+		if data := h.decodeResponse(args, reply); data != nil {
+			h.keyword.onResponse(data, dataSize)
+		}
+		return
+	}
 	msg := h.retrieveResponse(args, reply, dataSize)
 	if msg != nil {
 		h.Seqno++
@@ -172,7 +229,10 @@ func (h *Handle) OnResponse(args *common.ReadArgs, reply *common.ReadReply, data
 	}
 }
 
-func (h *Handle) retrieveResponse(args *common.ReadArgs, reply *common.ReadReply, dataSize uint) []byte {
+// decodeResponse removes the pads of the trust domains from a reply, and
+// decodes the response to an lwe query, returning the items of the bucket read
+// followed by those of the stash, or nil if the reply can't be decoded.
+func (h *Handle) decodeResponse(args *common.ReadArgs, reply *common.ReadReply) []byte {
 	data := reply.Data
 
 	// strip out the padding injected by trust domains.
@@ -185,6 +245,40 @@ func (h *Handle) retrieveResponse(args *common.ReadArgs, reply *common.ReadReply
 		}
 	}
 
+	// decode responses to lwe queries with the hint of the database. The
+	// response is followed by the stash of the replica.
+	if len(args.Secret) > 0 {
+		if len(args.Hint) == 0 {
+			if h.log != nil {
+				h.log.Info.Printf("Failed to decode lwe response: no hint of the database read\n")
+			}
+			return nil
+		}
+		responseLength := lwe.ResponseLength(len(args.Hint) / lwe.HintLength(1))
+		if len(data) < responseLength {
+			if h.log != nil {
+				h.log.Info.Printf("Failed to decode lwe response: response too short\n")
+			}
+			return nil
+		}
+		bucket, err := lwe.Recover(args.Hint, args.Secret, data[:responseLength])
+		if err != nil {
+			if h.log != nil {
+				h.log.Info.Printf("Failed to decode lwe response: %v\n", err)
+			}
+			return nil
+		}
+		data = append(bucket, data[responseLength:]...)
+	}
+	return data
+}
+
+func (h *Handle) retrieveResponse(args *common.ReadArgs, reply *common.ReadReply, dataSize uint) []byte {
+	data := h.decodeResponse(args, reply)
+	if data == nil {
+		return nil
+	}
+
 	var seqNoBytes [24]byte
 	_ = binary.PutUvarint(seqNoBytes[:], h.Seqno)
 
@@ -10,7 +10,7 @@ import (
 
 func TestGeneratePoll(t *testing.T) {
 	fmt.Printf("TestGeneratePoll:\n")
-	config := &ClientConfig{&common.Config{}, 0, 0, nil, ""}
+	config := &ClientConfig{&common.Config{}, 0, 0, nil, "", nil, nil}
 	config.Config.NumBuckets = 1000000
 	config.TrustDomains = make([]*common.TrustDomainConfig, 3)
 
@@ -18,17 +18,18 @@ func TestGeneratePoll(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error creating handle: %v\n", err)
 	}
-	_, _, err = h.generatePoll(config, rand.Reader)
+	_, err = h.generatePoll(config, rand.Reader)
 	if err == nil {
 		t.Fatalf("Could generate a poll from an un-configured subscription")
 	}
 
 	topic, _ := NewTopic()
 	h = &topic.Handle
-	args0, _, err := h.generatePoll(config, rand.Reader)
+	polls, err := h.generatePoll(config, rand.Reader)
 	if err != nil {
 		t.Fatalf("Error creating ReadArgs: %v\n", err)
 	}
+	args0 := polls[0]
 
 	if uint64(len(args0.TD[0].RequestVector)) != config.Config.NumBuckets/8 {
 		t.Fatalf("Length of request was incorrect. %d vs %d", len(args0.TD[0].RequestVector), config.Config.NumBuckets/8)
@@ -59,6 +60,33 @@ func TestSerialization(t *testing.T) {
 	}
 }
 
+func TestGeneratePollChoices(t *testing.T) {
+	config := &ClientConfig{&common.Config{}, 0, 0, nil, "", nil, nil}
+	config.TrustDomains = make([]*common.TrustDomainConfig, 2)
+	config.Config.NumBuckets = 64
+	config.Config.DataSize = 256
+	config.Config.BucketChoices = 3
+
+	topic, _ := NewTopic()
+	polls, err := topic.Handle.generatePoll(config, rand.Reader)
+	if err != nil {
+		t.Fatalf("Error creating ReadArgs: %v\n", err)
+	}
+	if len(polls) != 3 {
+		t.Fatalf("Expected a read per bucket choice, got %d", len(polls))
+	}
+	write, err := topic.GeneratePublish(config.Config, []byte("hi"))
+	if err != nil {
+		t.Fatalf("Error publishing: %v\n", err)
+	}
+	buckets := append([]uint64{write.Bucket1, write.Bucket2}, write.Buckets...)
+	for i, poll := range polls {
+		if uint64(poll.Bucket()) != buckets[i] {
+			t.Fatalf("Read %d of bucket %d, but written to %d", i, poll.Bucket(), buckets[i])
+		}
+	}
+}
+
 func BenchmarkGeneratePollN10K(b *testing.B) {
 	HelperBenchmarkGeneratePoll(b, 10000/4)
 }
@@ -70,7 +98,7 @@ func BenchmarkGeneratePollN1M(b *testing.B) {
 }
 
 func HelperBenchmarkGeneratePoll(b *testing.B, NumBuckets uint64) {
-	config := &ClientConfig{&common.Config{}, 0, 0, nil, ""}
+	config := &ClientConfig{&common.Config{}, 0, 0, nil, "", nil, nil}
 	config.TrustDomains = make([]*common.TrustDomainConfig, 3)
 	config.Config.NumBuckets = NumBuckets
 
@@ -82,13 +110,13 @@ func HelperBenchmarkGeneratePoll(b *testing.B, NumBuckets uint64) {
 	// Start timing
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _, _ = h.generatePoll(config, rand.Reader)
+		_, _ = h.generatePoll(config, rand.Reader)
 	}
 
 }
 
 func BenchmarkRetrieveResponse(b *testing.B) {
-	config := &ClientConfig{&common.Config{}, 0, 0, nil, ""}
+	config := &ClientConfig{&common.Config{}, 0, 0, nil, "", nil, nil}
 	config.TrustDomains = make([]*common.TrustDomainConfig, 3)
 	config.Config.NumBuckets = 10
 
@@ -97,10 +125,11 @@ func BenchmarkRetrieveResponse(b *testing.B) {
 	if err != nil {
 		b.Fatalf("Error creating topic handle: %v\n", err)
 	}
-	args, _, err := h.generatePoll(config, rand.Reader)
+	polls, err := h.generatePoll(config, rand.Reader)
 	if err != nil {
 		b.Fatalf("Error creating ReadArgs: %v\n", err)
 	}
+	args := polls[0]
 	reply := &common.ReadReply{}
 	reply.Data = make([]byte, 1024)
 	// Start timing
@@ -0,0 +1,212 @@
+package libtalek
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/agl/ed25519"
+	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/drbg"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Keyword topics hold a record placed under a public keyword, such as a public
+// key or a username, rather than under randomly generated seeds. Anyone
+// knowing the keyword and its publisher can privately read the record, so
+// keyword records are not confidential, but reads do not reveal which keyword
+// was looked up.
+//
+// The record of a keyword is always written to the same buckets, derived from
+// the keyword alone, so a reader finds the latest record without knowing how
+// often it was published. Items leave the database once they are older than
+// its window, so publishers republish their record each window. An item is
+//   nonce (24 bytes) | secretbox(publisher public key (32 bytes) |
+//     signature (64 bytes) | version (8 bytes) | length (4 bytes) | value)
+// sealed under a key derived from the keyword. The signature covers the
+// keyword, version and value, and readers deliver the valid record of their
+// publisher with the highest version.
+//
+// Since the keyword is public, anyone can write items to its buckets. Readers
+// ignore items of other publishers, but enough of them can displace the record
+// from the database before it is republished.
+
+// keywordHeader is the length of a record before its value.
+const keywordHeader = 32 + ed25519.SignatureSize + 8 + 4
+
+// KeywordOverhead is the number of bytes a keyword record adds to its value.
+const KeywordOverhead = 24 + secretbox.Overhead + keywordHeader
+
+// KeywordTopic publishes the record of a publisher under a keyword.
+// Records are published with client.PublishKeyword(topic, value), and must
+// fit in a single item.
+type KeywordTopic struct {
+	// Positions of the record.
+	Handle
+
+	// The key signing records, identifying the publisher.
+	PublisherKey *[64]byte
+
+	keyword []byte
+	version uint64
+}
+
+// KeywordHandle reads the record of a publisher under a keyword.
+// Records are read with client.Poll(&handle.Handle), which delivers the value
+// of each newer record of the publisher.
+type KeywordHandle struct {
+	Handle
+
+	keyword []byte
+	version uint64 // Of the last delivered record
+}
+
+// deriveKeyword expands a keyword into key material for a given use.
+func deriveKeyword(keyword []byte, use string) []byte {
+	h := sha256.New()
+	h.Write([]byte("talek keyword " + use))
+	h.Write(keyword)
+	return h.Sum(nil)
+}
+
+// initKeywordHandle sets the fixed log positions and key of a handle for a
+// keyword. The publisher takes the place of the signing key of the handle.
+func initKeywordHandle(h *Handle, keyword []byte, publisher *[32]byte) error {
+	var err error
+	if h.Seed1, err = drbg.NewSeedFromKey(drbg.DefaultAlgorithm, deriveKeyword(keyword, "seed1")); err != nil {
+		return err
+	}
+	if h.Seed2, err = drbg.NewSeedFromKey(drbg.DefaultAlgorithm, deriveKeyword(keyword, "seed2")); err != nil {
+		return err
+	}
+	h.SharedSecret = new([32]byte)
+	copy(h.SharedSecret[:], deriveKeyword(keyword, "secret"))
+	h.SigningPublicKey = new([32]byte)
+	*h.SigningPublicKey = *publisher
+	return initHandle(h)
+}
+
+// keywordSigned is the message signed for a record.
+func keywordSigned(keyword []byte, version uint64, value []byte) []byte {
+	msg := make([]byte, sha256.Size+8, sha256.Size+8+len(value))
+	copy(msg, deriveKeyword(keyword, "record"))
+	binary.LittleEndian.PutUint64(msg[sha256.Size:], version)
+	return append(msg, value...)
+}
+
+// NewKeywordTopic creates a KeywordTopic for publishing records under
+// keyword, signed with the publisher's ed25519 private key.
+func NewKeywordTopic(keyword []byte, publisherKey *[64]byte) (*KeywordTopic, error) {
+	if publisherKey == nil {
+		return nil, errors.New("keyword topics need a publisher key")
+	}
+	t := &KeywordTopic{PublisherKey: publisherKey}
+	t.keyword = append([]byte{}, keyword...)
+	var pub [32]byte
+	copy(pub[:], publisherKey[32:])
+	if err := initKeywordHandle(&t.Handle, keyword, &pub); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// NewKeywordHandle creates a KeywordHandle for reading the record of
+// publisher under keyword. Directories keyed by public keys should use the key
+// as both keyword and publisher.
+func NewKeywordHandle(keyword []byte, publisher *[32]byte) (*KeywordHandle, error) {
+	if publisher == nil {
+		return nil, errors.New("keyword handles need a publisher")
+	}
+	h := &KeywordHandle{}
+	h.keyword = append([]byte{}, keyword...)
+	if err := initKeywordHandle(&h.Handle, keyword, publisher); err != nil {
+		return nil, err
+	}
+	h.Handle.keyword = h
+	return h, nil
+}
+
+// GeneratePublish creates the write args of a record of value, replacing the
+// records published before it.
+func (t *KeywordTopic) GeneratePublish(commonConfig *common.Config, value []byte) (*common.WriteArgs, error) {
+	if len(value) > int(commonConfig.DataSize)-KeywordOverhead {
+		return nil, errors.New("record is too long")
+	}
+	version := uint64(time.Now().UnixNano())
+	if version <= t.version {
+		version = t.version + 1
+	}
+	t.version = version
+
+	args := &common.WriteArgs{}
+	buckets := t.Handle.nextChoices(commonConfig)
+	args.Bucket1 = buckets[0]
+	args.Bucket2 = buckets[1]
+	args.Buckets = buckets[2:]
+	args.InterestVector = t.Handle.nextInterestVector()
+
+	record := make([]byte, int(commonConfig.DataSize)-24-secretbox.Overhead)
+	copy(record, t.PublisherKey[32:])
+	sig := ed25519.Sign(t.PublisherKey, keywordSigned(t.keyword, version, value))
+	copy(record[32:], sig[:])
+	binary.LittleEndian.PutUint64(record[32+ed25519.SignatureSize:], version)
+	binary.LittleEndian.PutUint32(record[32+ed25519.SignatureSize+8:], uint32(len(value)))
+	copy(record[keywordHeader:], value)
+
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	args.Data = secretbox.Seal(nonce[:], record, &nonce, t.SharedSecret)
+	return args, nil
+}
+
+// open verifies an item read from h, and returns its value and version.
+func (h *KeywordHandle) open(item []byte) ([]byte, uint64, error) {
+	if len(item) < KeywordOverhead {
+		return nil, 0, errors.New("item too short")
+	}
+	var nonce [24]byte
+	copy(nonce[:], item)
+	record, ok := secretbox.Open(nil, item[24:], &nonce, h.SharedSecret)
+	if !ok {
+		return nil, 0, errors.New("Failed to decrypt")
+	}
+	if !bytes.Equal(record[:32], h.SigningPublicKey[:]) {
+		return nil, 0, errors.New("record of another publisher")
+	}
+	var sig [ed25519.SignatureSize]byte
+	copy(sig[:], record[32:])
+	version := binary.LittleEndian.Uint64(record[32+ed25519.SignatureSize:])
+	length := binary.LittleEndian.Uint32(record[32+ed25519.SignatureSize+8:])
+	if uint64(length) > uint64(len(record)-keywordHeader) {
+		return nil, 0, errors.New("invalid record length")
+	}
+	value := record[keywordHeader : keywordHeader+int(length)]
+	if !ed25519.Verify(h.SigningPublicKey, keywordSigned(h.keyword, version, value), &sig) {
+		return nil, 0, errors.New("invalid record signature")
+	}
+	return value, version, nil
+}
+
+// onResponse delivers the newest record of the decoded items of a read, if it
+// is newer than the last delivered record.
+func (h *KeywordHandle) onResponse(data []byte, dataSize uint) {
+	var latest []byte
+	version := h.version
+	for i := uint(0); i+dataSize <= uint(len(data)); i += dataSize {
+		value, v, err := h.open(data[i : i+dataSize])
+		if err == nil && v > version {
+			latest, version = value, v
+		}
+	}
+	if latest != nil {
+		h.version = version
+		if h.updates != nil {
+			h.updates <- latest
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package libtalek
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/agl/ed25519"
+	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/drbg"
+)
+
+// keywordWindow holds the writes within the window of a database.
+type keywordWindow struct {
+	config *common.Config
+	writes []*common.WriteArgs
+}
+
+func (w *keywordWindow) write(args *common.WriteArgs) {
+	w.writes = append(w.writes, args)
+	if uint64(len(w.writes)) > w.config.WindowSize() {
+		w.writes = w.writes[1:]
+	}
+}
+
+// read is the reply to a read of bucket.
+func (w *keywordWindow) read(bucket uint64) *common.ReadReply {
+	reply := &common.ReadReply{}
+	for _, args := range w.writes {
+		buckets := append([]uint64{args.Bucket1, args.Bucket2}, args.Buckets...)
+		for _, b := range buckets {
+			if b == bucket {
+				reply.Data = append(reply.Data, args.Data...)
+				break
+			}
+		}
+	}
+	return reply
+}
+
+// poll reads each candidate bucket of handle as a client of two trust domains
+// would, with each trust domain padding its reply, and returns the delivered
+// records.
+func (w *keywordWindow) poll(handle *KeywordHandle) [][]byte {
+	client := &ClientConfig{w.config, 0, 0, make([]*common.TrustDomainConfig, 2), "", nil, nil}
+	handle.updates = make(chan []byte, w.config.Choices())
+	for _, bucket := range handle.nextChoices(w.config) {
+		args := makeReadArg(client, bucket, rand.Reader)
+		reply := w.read(bucket)
+		for _, td := range args.TD {
+			drbg.Overlay(td.PadSeed, reply.Data)
+		}
+		handle.OnResponse(args, reply, uint(w.config.DataSize))
+	}
+	close(handle.updates)
+	var records [][]byte
+	for record := range handle.updates {
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestKeywordRecord(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error creating publisher key: %v\n", err)
+	}
+	topic, err := NewKeywordTopic(pub[:], priv)
+	if err != nil {
+		t.Fatalf("Error creating keyword topic: %v\n", err)
+	}
+	handle, err := NewKeywordHandle(pub[:], pub)
+	if err != nil {
+		t.Fatalf("Error creating keyword handle: %v\n", err)
+	}
+	if !Equal(&topic.Handle, &handle.Handle) {
+		t.Fatalf("Keyword handle should match the handle of its topic")
+	}
+	if _, err = NewKeywordHandle(pub[:], nil); err == nil {
+		t.Fatalf("Keyword handles should require a publisher")
+	}
+
+	config := &common.Config{NumBuckets: 64, DataSize: 256}
+	write, err := topic.GeneratePublish(config, []byte("contact info"))
+	if err != nil {
+		t.Fatalf("Error publishing record: %v\n", err)
+	}
+	if len(write.Data) != int(config.DataSize) {
+		t.Fatalf("Record of %d bytes rather than %d", len(write.Data), config.DataSize)
+	}
+	b1, b2 := handle.nextBuckets(config)
+	if write.Bucket1 != b1 || write.Bucket2 != b2 {
+		t.Fatalf("Record written to %d,%d rather than %d,%d", write.Bucket1, write.Bucket2, b1, b2)
+	}
+	again, _ := topic.GeneratePublish(config, []byte("new contact info"))
+	if again.Bucket1 != b1 || again.Bucket2 != b2 {
+		t.Fatalf("Republished record moved to %d,%d from %d,%d", again.Bucket1, again.Bucket2, b1, b2)
+	}
+
+	value, version, err := handle.open(write.Data)
+	if err != nil {
+		t.Fatalf("Error opening record: %v\n", err)
+	}
+	if !bytes.Equal(value, []byte("contact info")) {
+		t.Fatalf("Record did not round trip")
+	}
+	if _, newer, _ := handle.open(again.Data); newer <= version {
+		t.Fatalf("Republished record should have a newer version")
+	}
+	if _, err = topic.GeneratePublish(config, make([]byte, int(config.DataSize)-KeywordOverhead+1)); err == nil {
+		t.Fatalf("Records longer than an item should be rejected")
+	}
+}
+
+func TestKeywordRecordPublisher(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	var pub [32]byte
+	copy(pub[:], priv[32:])
+	topic, err := NewKeywordTopic([]byte("alice"), priv)
+	if err != nil {
+		t.Fatalf("Error creating keyword topic: %v\n", err)
+	}
+	config := &common.Config{NumBuckets: 64, DataSize: 256}
+	write, _ := topic.GeneratePublish(config, []byte("hello"))
+
+	pinned, _ := NewKeywordHandle([]byte("alice"), other)
+	if _, _, err = pinned.open(write.Data); err == nil {
+		t.Fatalf("Records of other publishers should be rejected")
+	}
+	elsewhere, _ := NewKeywordHandle([]byte("bob"), &pub)
+	if _, _, err = elsewhere.open(write.Data); err == nil {
+		t.Fatalf("Records should not open under another keyword")
+	}
+	write.Data[len(write.Data)-1] ^= 1
+	handle, _ := NewKeywordHandle([]byte("alice"), &pub)
+	if _, _, err = handle.open(write.Data); err == nil {
+		t.Fatalf("Modified records should be rejected")
+	}
+}
+
+func TestKeywordRepublish(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	_, squatter, _ := ed25519.GenerateKey(rand.Reader)
+	topic, _ := NewKeywordTopic([]byte("alice"), priv)
+	squat, _ := NewKeywordTopic([]byte("alice"), squatter)
+	config := &common.Config{NumBuckets: 64, BucketDepth: 2, DataSize: 256, MaxLoadFactor: 0.5}
+	window := &keywordWindow{config: config}
+
+	write, _ := topic.GeneratePublish(config, []byte("v1"))
+	window.write(write)
+	handle, _ := NewKeywordHandle([]byte("alice"), pub)
+	if records := window.poll(handle); len(records) != 1 || string(records[0]) != "v1" {
+		t.Fatalf("Expected to read v1, read %q", records)
+	}
+
+	// Fill the window with other writes, so the record expires.
+	for i := uint64(0); i < config.WindowSize(); i++ {
+		other, _ := NewTopic()
+		write, _ = other.GeneratePublish(config, make([]byte, config.DataSize-PublishingOverhead))
+		window.write(write)
+	}
+	expired, _ := NewKeywordHandle([]byte("alice"), pub)
+	if records := window.poll(expired); len(records) != 0 {
+		t.Fatalf("Expired record was read: %q", records)
+	}
+
+	write, _ = topic.GeneratePublish(config, []byte("v2"))
+	window.write(write)
+	write, _ = squat.GeneratePublish(config, []byte("squatted"))
+	window.write(write)
+	write, _ = topic.GeneratePublish(config, []byte("v3"))
+	window.write(write)
+
+	fresh, _ := NewKeywordHandle([]byte("alice"), pub)
+	if records := window.poll(fresh); len(records) != 1 || string(records[0]) != "v3" {
+		t.Fatalf("Expected a fresh handle to read v3, read %q", records)
+	}
+	if records := window.poll(fresh); len(records) != 0 {
+		t.Fatalf("Record should be delivered once, read %q", records)
+	}
+}
@@ -78,9 +78,10 @@ func NewTopic() (t *Topic, err error) {
 // entry in this topic log.
 func (t *Topic) GeneratePublish(commonConfig *common.Config, message []byte) (*common.WriteArgs, error) {
 	args := &common.WriteArgs{}
-	bucket1, bucket2 := t.Handle.nextBuckets(commonConfig)
-	args.Bucket1 = bucket1
-	args.Bucket2 = bucket2
+	buckets := t.Handle.nextChoices(commonConfig)
+	args.Bucket1 = buckets[0]
+	args.Bucket2 = buckets[1]
+	args.Buckets = buckets[2:]
 	var seqNoBytes [24]byte
 	_ = binary.PutUvarint(seqNoBytes[:], t.Seqno)
 
@@ -93,16 +94,6 @@ func (t *Topic) GeneratePublish(commonConfig *common.Config, message []byte) (*c
 	}
 	args.Data = ciphertext
 
-	// @todo - use new bloom/ implementation
-	/**
-	bloomFilter := bloom.NewWithEstimates(uint(commonConfig.WindowSize()), commonConfig.BloomFalsePositive)
-	idBytes := make([]byte, 8, 20)
-	_ = binary.PutUvarint(idBytes, t.ID)
-	idBytes = append(idBytes, seqNoBytes[:]...)
-	bloomFilter.Add(idBytes)
-	**/
-	//args.InterestVector, _ = bloomFilter.GobEncode()
-
 	return args, nil
 }
 
@@ -0,0 +1,134 @@
+package libtalek
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/privacylab/talek/common"
+)
+
+// tokenBatch is the number of write tokens requested from the frontend at once.
+const tokenBatch = 16
+
+// tokenExponent is the public exponent of token keys, which are pinned by
+// their modulus.
+const tokenExponent = 65537
+
+// tokenWallet holds the write tokens of a client. It is only used by the
+// write loop of the client.
+type tokenWallet struct {
+	key       *common.GetTokenKeyReply
+	tokens    []*common.WriteToken
+	uses      int // Writes made with tokens[0]
+	requested int // Tokens issued in the epoch of key
+}
+
+// nextToken is the token admitting the next write, or nil if the frontend
+// does not require tokens or none could be obtained.
+func (c *Client) nextToken(config *ClientConfig) *common.WriteToken {
+	w := &c.wallet
+	if w.key == nil || !time.Now().Before(w.key.Expires) {
+		if err := c.refreshTokenKey(config); err != nil {
+			c.log.Warn.Printf("Failed to fetch write token key: %v\n", err)
+			return nil
+		}
+	}
+	if w.key.Tokens == 0 {
+		return nil
+	}
+
+	if len(w.tokens) > 0 && w.uses >= w.key.WritesPerToken {
+		w.tokens = w.tokens[1:]
+		w.uses = 0
+	}
+	if len(w.tokens) == 0 && w.requested < w.key.Tokens {
+		if err := c.requestTokens(); err != nil {
+			c.log.Warn.Printf("Failed to obtain write tokens: %v\n", err)
+		}
+	}
+	if len(w.tokens) == 0 {
+		return nil
+	}
+	w.uses++
+	return w.tokens[0]
+}
+
+// refreshTokenKey fetches the token key of the current epoch, and drops
+// tokens that have expired.
+func (c *Client) refreshTokenKey(config *ClientConfig) error {
+	w := &c.wallet
+	reply := &common.GetTokenKeyReply{}
+	err := c.leader.GetTokenKey(&common.GetTokenKeyArgs{}, reply)
+	if err == nil && reply.Err != "" {
+		err = errors.New(reply.Err)
+	}
+	if err != nil {
+		return err
+	}
+	// Keys are only taken from the configuration, as the frontend could tell
+	// clients apart by the keys it hands them.
+	if reply.Tokens > 0 && len(config.TokenKey) == 0 {
+		return errors.New("frontend requires write tokens, but no token key is pinned")
+	}
+	if reply.Tokens > 0 && (!bytes.Equal(reply.Modulus, config.TokenKey) || reply.Exponent != tokenExponent) {
+		return errors.New("frontend token key does not match the pinned key")
+	}
+	if w.key == nil || w.key.Epoch != reply.Epoch {
+		w.requested = 0
+	}
+	w.key = reply
+
+	// Tokens are admitted in their epoch and the following one.
+	kept := w.tokens[:0]
+	for i, t := range w.tokens {
+		if t.Epoch+1 >= reply.Epoch {
+			kept = append(kept, t)
+		} else if i == 0 {
+			w.uses = 0
+		}
+	}
+	w.tokens = kept
+	return nil
+}
+
+// requestTokens blinds a batch of tokens for the frontend to sign.
+func (c *Client) requestTokens() error {
+	w := &c.wallet
+	n := w.key.Tokens - w.requested
+	if n > tokenBatch {
+		n = tokenBatch
+	}
+	key := w.key.PublicKey()
+	args := &common.IssueTokensArgs{Epoch: w.key.Epoch, Blinded: make([][]byte, n)}
+	blinded := make([]*common.BlindedToken, n)
+	for i := range blinded {
+		b, err := common.BlindToken(key, w.key.Epoch, c.Rand)
+		if err != nil {
+			return err
+		}
+		blinded[i] = b
+		args.Blinded[i] = b.Blinded
+	}
+
+	reply := &common.IssueTokensReply{}
+	err := c.leader.IssueTokens(args, reply)
+	if err == nil && reply.Err != "" {
+		err = errors.New(reply.Err)
+	}
+	if err != nil {
+		return err
+	}
+	if len(reply.Signatures) > n {
+		return errors.New("frontend issued more tokens than requested")
+	}
+	w.requested += len(reply.Signatures)
+	for i, sig := range reply.Signatures {
+		token, err := blinded[i].Unblind(sig)
+		if err != nil {
+			return err
+		}
+		w.tokens = append(w.tokens, token)
+	}
+	return nil
+}
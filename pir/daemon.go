@@ -0,0 +1,143 @@
+package pir
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/privacylab/talek/common"
+)
+
+// Daemon runs PIR computation for Servers connecting over a unix domain
+// socket. Each connection has its own database, computed on by the backing
+// of the daemon.
+type Daemon struct {
+	log     *common.Logger
+	backing string
+}
+
+// NewDaemon creates a PIR daemon for a backing
+func NewDaemon(name string, backing string) (*Daemon, error) {
+	if strings.HasPrefix(backing, SocketPrefix) {
+		return nil, errors.New("a daemon cannot use a socket backing")
+	}
+	d := &Daemon{}
+	d.log = common.NewLogger(name)
+	d.backing = backing
+	return d, nil
+}
+
+// Serve handles connections on listener until it is closed.
+func (d *Daemon) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *Daemon) handle(conn net.Conn) {
+	defer conn.Close()
+	server, err := NewServer(d.backing)
+	if err != nil {
+		d.log.Error.Printf("Could not create PIR server: %v\n", err)
+		return
+	}
+	defer server.Disconnect()
+
+	var db *DB
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		op, payload, err := readFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				d.log.Warn.Printf("Connection closed: %v\n", err)
+			}
+			return
+		}
+		response, err := d.apply(server, &db, op, payload)
+		if err != nil {
+			err = writeFrame(w, statusError, []byte(err.Error()))
+		} else {
+			err = writeFrame(w, statusOK, response)
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			d.log.Warn.Printf("Connection closed: %v\n", err)
+			return
+		}
+	}
+}
+
+// apply performs a single request on server, with db holding its database.
+func (d *Daemon) apply(server *Server, db **DB, op byte, payload []byte) ([]byte, error) {
+	switch op {
+	case opConfigure:
+		if len(payload) < 16 || len(payload)%4 != 0 {
+			return nil, errors.New("invalid configure request")
+		}
+		// The database of a previous configuration is released.
+		if *db != nil {
+			if server.DB == *db {
+				server.DB = nil
+			}
+			(*db).Close()
+			*db = nil
+		}
+		err := server.Configure(int(binary.LittleEndian.Uint32(payload)),
+			int(binary.LittleEndian.Uint32(payload[4:])),
+			int(binary.LittleEndian.Uint32(payload[8:])))
+		if err != nil {
+			return nil, err
+		}
+		server.RequestLength = int(binary.LittleEndian.Uint32(payload[12:]))
+		if len(payload) > 16 {
+			server.BatchSizes = nil
+			for i := 16; i < len(payload); i += 4 {
+				server.BatchSizes = append(server.BatchSizes, int(binary.LittleEndian.Uint32(payload[i:])))
+			}
+		}
+		*db, err = server.GetDB()
+		return nil, err
+	case opLoad:
+		if *db == nil {
+			return nil, errors.New("pir server unconfigured")
+		}
+		entryLength := 4 + server.CellLength
+		if len(payload)%entryLength != 0 {
+			return nil, errors.New("invalid load request")
+		}
+		for ; len(payload) > 0; payload = payload[entryLength:] {
+			cell := int(binary.LittleEndian.Uint32(payload))
+			if cell >= server.CellCount {
+				return nil, fmt.Errorf("cell %d out of range", cell)
+			}
+			copy((*db).DB[cell*server.CellLength:(cell+1)*server.CellLength], payload[4:entryLength])
+			(*db).MarkDirty(cell)
+		}
+		return nil, nil
+	case opSetDB:
+		if *db == nil {
+			return nil, errors.New("pir server unconfigured")
+		}
+		return nil, server.SetDB(*db)
+	case opRead:
+		responses := make(chan []byte, 1)
+		if err := server.Read(payload, responses); err != nil {
+			return nil, err
+		}
+		return <-responses, nil
+	case opGetHint:
+		return server.GetHint(), nil
+	}
+	return nil, fmt.Errorf("unknown request %d", op)
+}
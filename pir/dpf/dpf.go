@@ -0,0 +1,281 @@
+package dpf
+
+/**
+ * A two-party distributed point function, following Boyle, Gilboa and Ishai,
+ * "Function Secret Sharing: Improvements and Extensions" (CCS 2016).
+ * A pair of keys is generated for a bucket index. Expanding each key over the
+ * full domain gives a bit vector, and the XOR of the two vectors has exactly
+ * the bit of the bucket set. Keys are O(log numBuckets) bytes, where request
+ * vectors are numBuckets/8 bytes.
+ *
+ * The tree terminates early with 128-bit leaves, so each leaf expands into the
+ * request bits of 128 consecutive buckets.
+ */
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BlockSize is the size of seeds and leaves of the evaluation tree.
+const BlockSize = aes.BlockSize
+
+// leafBits is the number of buckets covered by each leaf.
+const leafBits = BlockSize * 8
+
+type block [BlockSize]byte
+
+// prgKeys are the public, fixed keys of the tree PRG. Expanding a seed is
+// fixed-key AES in Matyas-Meyer-Oseas mode under the left and right keys,
+// and leaves are converted to output under the third.
+var prgKeys = [3][]byte{
+	[]byte("talek dpf left  "),
+	[]byte("talek dpf right "),
+	[]byte("talek dpf output"),
+}
+
+type prg struct {
+	left  cipher.Block
+	right cipher.Block
+	conv  cipher.Block
+}
+
+func newPRG() *prg {
+	p := &prg{}
+	p.left, _ = aes.NewCipher(prgKeys[0])
+	p.right, _ = aes.NewCipher(prgKeys[1])
+	p.conv, _ = aes.NewCipher(prgKeys[2])
+	return p
+}
+
+func mmo(c cipher.Block, in *block, out *block) {
+	c.Encrypt(out[:], in[:])
+	for i := range out {
+		out[i] ^= in[i]
+	}
+}
+
+// expand computes the seeds and control bits of both children of a node.
+func (p *prg) expand(s *block, sL *block, sR *block) (tL byte, tR byte) {
+	mmo(p.left, s, sL)
+	mmo(p.right, s, sR)
+	tL = sL[0] & 1
+	tR = sR[0] & 1
+	sL[0] &^= 1
+	sR[0] &^= 1
+	return
+}
+
+func (p *prg) convert(s *block, out *block) {
+	mmo(p.conv, s, out)
+}
+
+func xorBlock(dst *block, src *block) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// Key is one party's share of a distributed point function.
+type Key struct {
+	party byte
+	seed  block
+	// Per level correction words: seed and the left and right control bits.
+	seedCW  []block
+	leftCW  []byte
+	rightCW []byte
+	// Correction of the converted leaf.
+	outputCW block
+}
+
+// Depth computes the depth of the evaluation tree for numBuckets buckets.
+func Depth(numBuckets uint64) int {
+	leaves := (numBuckets + leafBits - 1) / leafBits
+	depth := 0
+	for (uint64(1) << uint(depth)) < leaves {
+		depth++
+	}
+	return depth
+}
+
+// KeySize is the number of bytes of a marshaled key for numBuckets buckets.
+func KeySize(numBuckets uint64) int {
+	return 2 + BlockSize + Depth(numBuckets)*(BlockSize+1) + BlockSize
+}
+
+// Gen creates the pair of keys for a point function selecting `bucket` out
+// of numBuckets buckets.
+func Gen(bucket uint64, numBuckets uint64, rand io.Reader) (*Key, *Key, error) {
+	if bucket >= numBuckets {
+		return nil, nil, fmt.Errorf("bucket=%v must be <numBuckets=%v", bucket, numBuckets)
+	}
+	depth := Depth(numBuckets)
+	leaf := bucket / leafBits
+	p := newPRG()
+
+	keys := [2]*Key{{party: 0}, {party: 1}}
+	var s [2]block
+	t := [2]byte{0, 1}
+	for b := 0; b < 2; b++ {
+		if _, err := io.ReadFull(rand, s[b][:]); err != nil {
+			return nil, nil, err
+		}
+		s[b][0] &^= 1
+		keys[b].seed = s[b]
+		keys[b].seedCW = make([]block, depth)
+		keys[b].leftCW = make([]byte, depth)
+		keys[b].rightCW = make([]byte, depth)
+	}
+
+	for level := 0; level < depth; level++ {
+		var sL, sR [2]block
+		var tL, tR [2]byte
+		for b := 0; b < 2; b++ {
+			tL[b], tR[b] = p.expand(&s[b], &sL[b], &sR[b])
+		}
+		bit := byte((leaf >> uint(depth-1-level)) & 1)
+
+		// Correct the seeds of the child off the path to be equal.
+		var sCW block
+		if bit == 0 {
+			sCW = sR[0]
+			xorBlock(&sCW, &sR[1])
+		} else {
+			sCW = sL[0]
+			xorBlock(&sCW, &sL[1])
+		}
+		tLCW := tL[0] ^ tL[1] ^ bit ^ 1
+		tRCW := tR[0] ^ tR[1] ^ bit
+		for b := 0; b < 2; b++ {
+			keys[b].seedCW[level] = sCW
+			keys[b].leftCW[level] = tLCW
+			keys[b].rightCW[level] = tRCW
+		}
+
+		// Follow the path, correcting nodes whose parent control bit is set.
+		for b := 0; b < 2; b++ {
+			var tNext, tKeepCW byte
+			if bit == 0 {
+				s[b], tNext, tKeepCW = sL[b], tL[b], tLCW
+			} else {
+				s[b], tNext, tKeepCW = sR[b], tR[b], tRCW
+			}
+			if t[b] == 1 {
+				xorBlock(&s[b], &sCW)
+				tNext ^= tKeepCW
+			}
+			t[b] = tNext
+		}
+	}
+
+	// The converted leaves of the two parties differ by exactly the bucket bit.
+	var out, conv block
+	p.convert(&s[0], &out)
+	p.convert(&s[1], &conv)
+	xorBlock(&out, &conv)
+	pos := bucket % leafBits
+	out[pos/8] ^= 1 << (pos % 8)
+	keys[0].outputCW = out
+	keys[1].outputCW = out
+
+	return keys[0], keys[1], nil
+}
+
+// EvalFull expands a key over the full domain, returning the request vector
+// of (numBuckets+7)/8 bytes, with the bit of bucket i at byte i/8, bit i%8.
+func (k *Key) EvalFull(numBuckets uint64) ([]byte, error) {
+	depth := Depth(numBuckets)
+	if len(k.seedCW) != depth || len(k.leftCW) != depth || len(k.rightCW) != depth {
+		return nil, errors.New("key does not match number of buckets")
+	}
+	p := newPRG()
+
+	// Breadth first expansion of the tree.
+	seeds := []block{k.seed}
+	controls := []byte{k.party}
+	for level := 0; level < depth; level++ {
+		nextSeeds := make([]block, 2*len(seeds))
+		nextControls := make([]byte, 2*len(seeds))
+		for i := range seeds {
+			sL, sR := &nextSeeds[2*i], &nextSeeds[2*i+1]
+			tL, tR := p.expand(&seeds[i], sL, sR)
+			if controls[i] == 1 {
+				xorBlock(sL, &k.seedCW[level])
+				xorBlock(sR, &k.seedCW[level])
+				tL ^= k.leftCW[level]
+				tR ^= k.rightCW[level]
+			}
+			nextControls[2*i] = tL
+			nextControls[2*i+1] = tR
+		}
+		seeds = nextSeeds
+		controls = nextControls
+	}
+
+	vector := make([]byte, len(seeds)*BlockSize)
+	var out block
+	for i := range seeds {
+		p.convert(&seeds[i], &out)
+		if controls[i] == 1 {
+			xorBlock(&out, &k.outputCW)
+		}
+		copy(vector[i*BlockSize:], out[:])
+	}
+	return vector[:(numBuckets+7)/8], nil
+}
+
+// MarshalBinary encodes a key as
+// party | depth | seed | depth * (seed correction | control corrections) | output correction
+func (k *Key) MarshalBinary() ([]byte, error) {
+	depth := len(k.seedCW)
+	if depth > 255 || len(k.leftCW) != depth || len(k.rightCW) != depth {
+		return nil, errors.New("malformed key")
+	}
+	out := make([]byte, 0, 2+BlockSize+depth*(BlockSize+1)+BlockSize)
+	out = append(out, k.party, byte(depth))
+	out = append(out, k.seed[:]...)
+	for i := 0; i < depth; i++ {
+		out = append(out, k.seedCW[i][:]...)
+		out = append(out, k.leftCW[i]|(k.rightCW[i]<<1))
+	}
+	out = append(out, k.outputCW[:]...)
+	return out, nil
+}
+
+// UnmarshalBinary restores a key encoded by MarshalBinary.
+func (k *Key) UnmarshalBinary(data []byte) error {
+	if len(data) < 2+2*BlockSize {
+		return errors.New("invalid DPF key. Too few bytes")
+	}
+	depth := int(data[1])
+	if len(data) != 2+BlockSize+depth*(BlockSize+1)+BlockSize || data[0] > 1 {
+		return errors.New("invalid DPF key")
+	}
+	k.party = data[0]
+	data = data[2:]
+	copy(k.seed[:], data)
+	data = data[BlockSize:]
+	k.seedCW = make([]block, depth)
+	k.leftCW = make([]byte, depth)
+	k.rightCW = make([]byte, depth)
+	for i := 0; i < depth; i++ {
+		copy(k.seedCW[i][:], data)
+		k.leftCW[i] = data[BlockSize] & 1
+		k.rightCW[i] = (data[BlockSize] >> 1) & 1
+		data = data[BlockSize+1:]
+	}
+	copy(k.outputCW[:], data)
+	return nil
+}
+
+// Expand decodes a marshaled key and expands it into a request vector.
+func Expand(key []byte, numBuckets uint64) ([]byte, error) {
+	k := &Key{}
+	if err := k.UnmarshalBinary(key); err != nil {
+		return nil, err
+	}
+	return k.EvalFull(numBuckets)
+}
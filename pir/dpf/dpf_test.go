@@ -0,0 +1,90 @@
+package dpf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func checkPoint(t *testing.T, bucket uint64, numBuckets uint64) {
+	k0, k1, err := Gen(bucket, numBuckets, rand.Reader)
+	if err != nil {
+		t.Fatalf("Gen(%d, %d) failed: %v", bucket, numBuckets, err)
+	}
+	v0, err := k0.EvalFull(numBuckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := k1.EvalFull(numBuckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v0) != int((numBuckets+7)/8) || len(v1) != len(v0) {
+		t.Fatalf("EvalFull gave vector of length %d for %d buckets", len(v0), numBuckets)
+	}
+	if bytes.Equal(v0, v1) {
+		t.Fatalf("shares of the point function should differ")
+	}
+	for i := uint64(0); i < numBuckets; i++ {
+		bit := (v0[i/8] ^ v1[i/8]) & (1 << (i % 8))
+		if (bit != 0) != (i == bucket) {
+			t.Fatalf("point function for %d of %d incorrect at %d", bucket, numBuckets, i)
+		}
+	}
+}
+
+func TestPointFunction(t *testing.T) {
+	for _, numBuckets := range []uint64{1, 8, 100, 128, 129, 512, 1024, 5000} {
+		for _, bucket := range []uint64{0, numBuckets / 2, numBuckets - 1} {
+			checkPoint(t, bucket, numBuckets)
+		}
+	}
+}
+
+func TestInvalidBucket(t *testing.T) {
+	if _, _, err := Gen(512, 512, rand.Reader); err == nil {
+		t.Fatal("Gen should fail for a bucket out of range")
+	}
+}
+
+func TestMarshaling(t *testing.T) {
+	numBuckets := uint64(4096)
+	k0, k1, err := Gen(1234, numBuckets, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []*Key{k0, k1} {
+		data, err := k.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != KeySize(numBuckets) {
+			t.Fatalf("marshaled key is %d bytes, expected %d", len(data), KeySize(numBuckets))
+		}
+		expected, _ := k.EvalFull(numBuckets)
+		actual, err := Expand(data, numBuckets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Fatal("unmarshaled key expands differently")
+		}
+	}
+
+	if _, err := Expand(make([]byte, 10), numBuckets); err == nil {
+		t.Fatal("Expand should fail on a truncated key")
+	}
+	data, _ := k0.MarshalBinary()
+	if _, err := Expand(data, 2*numBuckets); err == nil {
+		t.Fatal("Expand should fail on a key for a different number of buckets")
+	}
+}
+
+func BenchmarkEvalFull(b *testing.B) {
+	numBuckets := uint64(1 << 20)
+	k0, _, _ := Gen(12345, numBuckets, rand.Reader)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = k0.EvalFull(numBuckets)
+	}
+}
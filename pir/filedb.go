@@ -0,0 +1,96 @@
+package pir
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+/**
+ * A file-backed DB holds both of its buffers in a memory mapped file, so that
+ * the database may be larger than physical memory. The file starts with a
+ * header recording the sizing of the database and which buffer was last
+ * served, which is served again when the file is reopened.
+ *
+ * header: magic | cellLength | cellCount | served buffer
+ */
+
+// fileHeaderSize is the space reserved for the header, keeping buffers page aligned.
+const fileHeaderSize = 4096
+
+var fileMagic = []byte("talekdb1")
+
+type dbFile struct {
+	mapping []byte
+	buffers [2][]byte
+}
+
+// GetFileDB provides a DB backed by a memory mapped file at path.
+// A new file is created if none exists. An existing file must have been
+// created with the same sizing, and its last served contents become the
+// contents of the DB.
+func (s *Server) GetFileDB(path string) (*DB, error) {
+	if s.CellCount == 0 || s.CellLength == 0 {
+		return nil, errors.New("pir server unconfigured")
+	}
+	size := s.CellCount * s.CellLength
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// The mapping remains valid once the file is closed.
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	fresh := info.Size() == 0
+	if fresh {
+		if err = f.Truncate(int64(fileHeaderSize + 2*size)); err != nil {
+			return nil, err
+		}
+	} else if info.Size() != int64(fileHeaderSize+2*size) {
+		return nil, fmt.Errorf("%s is %d bytes, not sized for %d cells of %d bytes", path, info.Size(), s.CellCount, s.CellLength)
+	}
+
+	mapping, err := mapFile(f, fileHeaderSize+2*size)
+	if err != nil {
+		return nil, err
+	}
+	header := mapping[:fileHeaderSize]
+	if fresh {
+		copy(header, fileMagic)
+		binary.LittleEndian.PutUint64(header[8:], uint64(s.CellLength))
+		binary.LittleEndian.PutUint64(header[16:], uint64(s.CellCount))
+	} else if !bytes.Equal(header[:8], fileMagic) ||
+		binary.LittleEndian.Uint64(header[8:]) != uint64(s.CellLength) ||
+		binary.LittleEndian.Uint64(header[16:]) != uint64(s.CellCount) {
+		unmapFile(mapping)
+		return nil, fmt.Errorf("%s is not a database of %d cells of %d bytes", path, s.CellCount, s.CellLength)
+	}
+
+	file := &dbFile{mapping: mapping}
+	file.buffers[0] = mapping[fileHeaderSize : fileHeaderSize+size]
+	file.buffers[1] = mapping[fileHeaderSize+size:]
+	served := binary.LittleEndian.Uint64(header[24:]) & 1
+
+	db := newDB(file.buffers[served], file.buffers[1-served], s.CellLength, s.CellCount)
+	db.file = file
+	return db, nil
+}
+
+// served records that buffer is the contents served by the read path.
+func (f *dbFile) served(buffer []byte) {
+	index := uint64(0)
+	if len(buffer) > 0 && &buffer[0] == &f.buffers[1][0] {
+		index = 1
+	}
+	binary.LittleEndian.PutUint64(f.mapping[24:], index)
+}
+
+func (f *dbFile) close() error {
+	return unmapFile(f.mapping)
+}
@@ -1,15 +1,30 @@
 package pir
 
 import (
+	"bufio"
+	"encoding/binary"
 	"errors"
+	"net"
+	"strings"
+	"sync"
 
 	"github.com/privacylab/talek/pir/pirinterface"
 )
 
-// DB is a memory area for PIR computations shared with a PIR daemon.
+// DB is a double buffered memory area for PIR computations shared with a PIR daemon.
+// Writes are made to DB, while the read path serves the other buffer. Cells
+// written must be marked with MarkDirty, so that they are carried over to the
+// other buffer when it becomes DB at the next SetDB.
 type DB struct {
 	DB    []byte
 	shard pirinterface.Shard
+
+	other      []byte
+	dirty      []bool
+	cellLength int
+
+	// Set for DBs backed by a memory mapped file.
+	file *dbFile
 }
 
 type pirReq struct {
@@ -24,7 +39,21 @@ type Server struct {
 	CellLength int
 	CellCount  int
 	BatchSize  int
+	// RequestLength is the length of each request in a batch. It defaults to
+	// a bit per cell, and differs for shards of computational PIR schemes.
+	RequestLength int
+	// BatchSizes are the numbers of requests a read may batch. It defaults
+	// to BatchSize alone.
+	BatchSizes []int
 	DB         *DB
+
+	// Set for Servers computing in a PIR daemon, with backing SocketPrefix
+	// followed by the path of the socket of the daemon. conn is nil while
+	// the daemon is unreachable.
+	socket   string
+	conn     net.Conn
+	connRW   *bufio.ReadWriter
+	connLock sync.Mutex
 }
 
 // NewServer creates a Server for communication
@@ -32,6 +61,14 @@ func NewServer(backing string) (*Server, error) {
 	server := new(Server)
 	server.backing = backing
 
+	if strings.HasPrefix(backing, SocketPrefix) {
+		server.socket = strings.TrimPrefix(backing, SocketPrefix)
+		if err := server.dial(); err != nil {
+			return nil, err
+		}
+		return server, nil
+	}
+
 	if cons := pirinterface.GetBacking(backing); cons != nil {
 		server.newshard = cons
 		return server, nil
@@ -43,16 +80,114 @@ func NewServer(backing string) (*Server, error) {
 // Disconnect closes a Server connection
 func (s *Server) Disconnect() error {
 	if s.DB != nil {
-		s.DB.Free()
+		s.DB.Close()
+	}
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	if s.conn != nil {
+		err := s.conn.Close()
+		s.conn = nil
+		return err
 	}
 	return nil
 }
 
+// daemonError is an error reported by the PIR daemon, rather than a failure
+// of the connection to it.
+type daemonError string
+
+func (e daemonError) Error() string {
+	return string(e)
+}
+
+func (s *Server) dial() error {
+	conn, err := net.Dial("unix", s.socket)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.connRW = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	return nil
+}
+
+// call makes a request to the PIR daemon of the Server. When the connection to
+// the daemon fails, as when the daemon restarted, it is dialed again and the
+// daemon is given the DB before the request is retried.
+func (s *Server) call(op byte, payload []byte) ([]byte, error) {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	if s.conn != nil {
+		response, err := s.exchange(op, payload)
+		if _, ok := err.(daemonError); ok || err == nil {
+			return response, err
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.redial(); err != nil {
+		return nil, err
+	}
+	return s.exchange(op, payload)
+}
+
+// exchange sends a request over the connection to the daemon and reads its
+// response. It is called with connLock held.
+func (s *Server) exchange(op byte, payload []byte) ([]byte, error) {
+	if err := writeFrame(s.connRW, op, payload); err != nil {
+		return nil, err
+	}
+	if err := s.connRW.Flush(); err != nil {
+		return nil, err
+	}
+	status, response, err := readFrame(s.connRW)
+	if err != nil {
+		return nil, err
+	}
+	if status != statusOK {
+		return nil, daemonError(response)
+	}
+	return response, nil
+}
+
+// redial connects to the daemon again, and restores the DB served. The new
+// connection has a database of its own, so it is configured, and all cells of
+// the DB are loaded. Writes not yet applied by SetDB are loaded as well, and
+// are read until the next SetDB applies them. It is called with connLock held.
+func (s *Server) redial() error {
+	if err := s.dial(); err != nil {
+		return err
+	}
+	if s.DB == nil {
+		// Not configured yet.
+		return nil
+	}
+	err := s.restore()
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *Server) restore() error {
+	if _, err := s.exchange(opConfigure, s.configuration()); err != nil {
+		return err
+	}
+	all := func(int) bool { return true }
+	if err := s.load(s.DB, all, s.CellCount, s.exchange); err != nil {
+		return err
+	}
+	_, err := s.exchange(opSetDB, nil)
+	return err
+}
+
 // Configure sets the size of the DB and operational parameters.
 func (s *Server) Configure(celllength int, cellcount int, batchsize int) error {
 	s.BatchSize = batchsize
 	s.CellCount = cellcount
 	s.CellLength = celllength
+	s.RequestLength = cellcount / 8
+	s.BatchSizes = []int{batchsize}
 
 	if s.CellCount%8 != 0 || s.CellLength%8 != 0 {
 		return errors.New("invalid sizing of database; everything needs to be multiples of 8 bytes")
@@ -61,34 +196,192 @@ func (s *Server) Configure(celllength int, cellcount int, batchsize int) error {
 	return nil
 }
 
+// configureDaemon sends the sizing of the Server to its PIR daemon.
+func (s *Server) configureDaemon() error {
+	_, err := s.call(opConfigure, s.configuration())
+	return err
+}
+
+// configuration is the payload of opConfigure for the sizing of the Server.
+func (s *Server) configuration() []byte {
+	payload := make([]byte, 16+4*len(s.BatchSizes))
+	binary.LittleEndian.PutUint32(payload, uint32(s.CellLength))
+	binary.LittleEndian.PutUint32(payload[4:], uint32(s.CellCount))
+	binary.LittleEndian.PutUint32(payload[8:], uint32(s.BatchSize))
+	binary.LittleEndian.PutUint32(payload[12:], uint32(s.RequestLength))
+	for i, size := range s.BatchSizes {
+		binary.LittleEndian.PutUint32(payload[16+4*i:], uint32(size))
+	}
+	return payload
+}
+
 // GetDB provides direct access to the DB of the Server.
 func (s *Server) GetDB() (*DB, error) {
 	if s.CellCount == 0 || s.CellLength == 0 {
 		return nil, errors.New("pir server unconfigured")
 	}
+	size := s.CellCount * s.CellLength
+	if s.socket != "" {
+		// The daemon keeps the served copy, so a single buffer suffices.
+		return newDB(make([]byte, size), nil, s.CellLength, s.CellCount), nil
+	}
+	return newDB(make([]byte, size), make([]byte, size), s.CellLength, s.CellCount), nil
+}
+
+func newDB(buffer []byte, other []byte, cellLength int, cellCount int) *DB {
 	db := new(DB)
+	db.DB = buffer
+	db.other = other
+	db.cellLength = cellLength
+	// Start fully dirty, so the first SetDB carries over any initial contents.
+	db.dirty = make([]bool, cellCount)
+	for i := range db.dirty {
+		db.dirty[i] = true
+	}
+	return db
+}
 
-	db.DB = make([]byte, s.CellCount*s.CellLength)
+// MarkDirty records that a cell of DB was written since the last SetDB.
+func (db *DB) MarkDirty(cell int) {
+	db.dirty[cell] = true
+}
 
-	return db, nil
+// swap hands out the write buffer, and replaces it with the other buffer
+// brought up to date with the cells written since the last swap.
+func (db *DB) swap() []byte {
+	view := db.DB
+	next := db.other
+	for cell, dirty := range db.dirty {
+		if dirty {
+			start := cell * db.cellLength
+			copy(next[start:start+db.cellLength], view[start:start+db.cellLength])
+			db.dirty[cell] = false
+		}
+	}
+	db.DB = next
+	db.other = view
+	if db.file != nil {
+		db.file.served(view)
+	}
+	return view
 }
 
-// SetDB updates the PIR Server Database
+// SetDB updates the PIR Server Database to the current contents of db.DB.
+// The write buffer of db is served by the read path without copying, and
+// db.DB becomes the other buffer of db, which is no longer being read.
+// Reads must not be concurrent with SetDB, and db.DB must not be written
+// until SetDB returns.
 func (s *Server) SetDB(db *DB) error {
+	if s.socket != "" {
+		return s.setDaemonDB(db)
+	}
+	// The shard serving db holds its other buffer, which the dirty cells of
+	// db.DB are the only changes to, so it may derive the next shard.
+	if s.DB == db && db.shard != nil {
+		if us, ok := db.shard.(pirinterface.UpdatableShard); ok {
+			next, err := us.Update(db.DB, db.dirty)
+			if err != nil {
+				return err
+			}
+			db.shard.Free()
+			db.shard = next
+			db.swap()
+			return nil
+		}
+	}
 	if s.DB != nil {
 		s.DB.Free()
 	}
 
-	shardMemory := make([]byte, len(db.DB))
-	copy(shardMemory[:], db.DB[:])
-	db.shard = s.newshard(s.CellLength, shardMemory, s.backing)
+	db.shard = s.newshard(s.CellLength, db.DB, s.backing)
 	if db.shard == nil {
 		return errors.New("Couldn't set DB")
 	}
+	db.swap()
+	s.DB = db
+	return nil
+}
+
+// setDaemonDB loads the cells of db written since the last SetDB into the PIR
+// daemon, and has it serve them. db.DB remains the write buffer.
+func (s *Server) setDaemonDB(db *DB) error {
+	if s.DB == nil {
+		// The daemon is configured once the RequestLength is settled.
+		if err := s.configureDaemon(); err != nil {
+			return err
+		}
+	}
+	dirty := 0
+	for _, d := range db.dirty {
+		if d {
+			dirty++
+		}
+	}
+	isDirty := func(cell int) bool { return db.dirty[cell] }
+	if err := s.load(db, isDirty, dirty, s.call); err != nil {
+		return err
+	}
+	if _, err := s.call(opSetDB, nil); err != nil {
+		return err
+	}
+	for cell := range db.dirty {
+		db.dirty[cell] = false
+	}
 	s.DB = db
 	return nil
 }
 
+// load sends the count cells of db for which selected is true to the daemon,
+// in frames of at most maxFrameSize bytes.
+func (s *Server) load(db *DB, selected func(int) bool, count int, call func(byte, []byte) ([]byte, error)) error {
+	entryLength := 4 + db.cellLength
+	perFrame := maxFrameSize / entryLength
+	if count < perFrame {
+		perFrame = count
+	}
+	payload := make([]byte, 0, entryLength*perFrame)
+	for cell := range db.dirty {
+		if !selected(cell) {
+			continue
+		}
+		if len(payload)+entryLength > cap(payload) {
+			if _, err := call(opLoad, payload); err != nil {
+				return err
+			}
+			payload = payload[:0]
+		}
+		var index [4]byte
+		binary.LittleEndian.PutUint32(index[:], uint32(cell))
+		payload = append(payload, index[:]...)
+		payload = append(payload, db.DB[cell*db.cellLength:(cell+1)*db.cellLength]...)
+	}
+	if len(payload) > 0 {
+		if _, err := call(opLoad, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetHint returns the hint of the current DB, for backings of computational
+// PIR schemes, or nil.
+func (s *Server) GetHint() []byte {
+	if s.DB == nil {
+		return nil
+	}
+	if s.socket != "" {
+		hint, err := s.call(opGetHint, nil)
+		if err != nil || len(hint) == 0 {
+			return nil
+		}
+		return hint
+	}
+	if hs, ok := s.DB.shard.(pirinterface.HintShard); ok {
+		return hs.GetHint()
+	}
+	return nil
+}
+
 // Free releases memory for a DB instance
 func (db *DB) Free() error {
 	if db.shard != nil {
@@ -98,17 +391,37 @@ func (db *DB) Free() error {
 	return nil
 }
 
+// Close releases a DB instance, including the mapping of a file-backed DB.
+func (db *DB) Close() error {
+	db.Free()
+	if db.file != nil {
+		err := db.file.close()
+		db.file = nil
+		return err
+	}
+	return nil
+}
+
 // Read makes a PIR request against the server.
 func (s *Server) Read(masks []byte, responseChan chan []byte) error {
 	if s.DB == nil || s.CellCount == 0 {
 		return errors.New("db not configured")
 	}
 
-	if len(masks) != (s.CellCount*s.BatchSize)/8 {
+	if !s.isBatchSize(len(masks)) {
 		return errors.New("wrong mask length")
 	}
 
-	responses, err := s.DB.shard.Read(masks, s.CellCount/8)
+	if s.socket != "" {
+		responses, err := s.call(opRead, masks)
+		if err != nil {
+			return err
+		}
+		responseChan <- responses
+		return nil
+	}
+
+	responses, err := s.DB.shard.Read(masks, s.RequestLength)
 	if err != nil {
 		return err
 	}
@@ -116,3 +429,13 @@ func (s *Server) Read(masks []byte, responseChan chan []byte) error {
 
 	return nil
 }
+
+// isBatchSize checks that masks of a length form a batch of an allowed size.
+func (s *Server) isBatchSize(length int) bool {
+	for _, size := range s.BatchSizes {
+		if length == s.RequestLength*size {
+			return true
+		}
+	}
+	return false
+}
@@ -2,7 +2,10 @@ package pir
 
 import (
 	"errors"
+	"io/ioutil"
 	"math/rand"
+	"net"
+	"path/filepath"
 	"strconv"
 
 	_ "github.com/privacylab/talek/pir/pircpu"
@@ -67,6 +70,248 @@ func TestPir(t *testing.T) {
 	pirServer.Disconnect()
 }
 
+func TestDBSwap(t *testing.T) {
+	pirServer, err := NewServer("cpu.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirServer.Configure(8, 16, 1)
+	db, err := pirServer.GetDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB[8] = 1
+	if err = pirServer.SetDB(db); err != nil {
+		t.Fatal(err)
+	}
+	if db.DB[8] != 1 {
+		t.Fatal("Initial contents should carry over to the write buffer")
+	}
+
+	// Writes to the write buffer are not visible until the next SetDB.
+	db.DB[16] = 2
+	db.MarkDirty(2)
+	responseChan := make(chan []byte, 1)
+	masks := []byte{0x06, 0x00}
+	if err = pirServer.Read(masks, responseChan); err != nil {
+		t.Fatal(err)
+	}
+	if response := <-responseChan; response[0] != 1 {
+		t.Fatalf("Read should only see the first write, got %d", response[0])
+	}
+
+	if err = pirServer.SetDB(db); err != nil {
+		t.Fatal(err)
+	}
+	if err = pirServer.Read(masks, responseChan); err != nil {
+		t.Fatal(err)
+	}
+	if response := <-responseChan; response[0] != 3 {
+		t.Fatalf("Read should see both writes, got %d", response[0])
+	}
+	if db.DB[8] != 1 || db.DB[16] != 2 {
+		t.Fatal("Dirty cells should carry over to the write buffer")
+	}
+	pirServer.Disconnect()
+}
+
+func TestFileDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "talekdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "db")
+	pirServer, err := NewServer("cpu.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirServer.Configure(8, 16, 1)
+	db, err := pirServer.GetFileDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB[8] = 1
+	db.MarkDirty(1)
+	if err = pirServer.SetDB(db); err != nil {
+		t.Fatal(err)
+	}
+	// An unapplied write is not seen after a restart.
+	db.DB[16] = 2
+	db.MarkDirty(2)
+	pirServer.Disconnect()
+
+	pirServer, _ = NewServer("cpu.0")
+	pirServer.Configure(8, 16, 1)
+	db, err = pirServer.GetFileDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pirServer.SetDB(db); err != nil {
+		t.Fatal(err)
+	}
+	responseChan := make(chan []byte, 1)
+	if err = pirServer.Read([]byte{0x06, 0x00}, responseChan); err != nil {
+		t.Fatal(err)
+	}
+	if response := <-responseChan; response[0] != 1 {
+		t.Fatalf("Read after restart should see the applied write, got %d", response[0])
+	}
+	pirServer.Disconnect()
+
+	pirServer, _ = NewServer("cpu.0")
+	pirServer.Configure(8, 32, 1)
+	if _, err = pirServer.GetFileDB(path); err == nil {
+		t.Fatal("Opening a database file with different sizing should fail")
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "talekpird")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "pir.socket")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	daemon, err := NewDaemon("talekpird", "cpu.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go daemon.Serve(listener)
+
+	pirServer, err := NewServer(SocketPrefix + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pirServer.Disconnect()
+	pirServer.Configure(8, 16, 1)
+	db, err := pirServer.GetDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB[3*8] = 3
+	if err = pirServer.SetDB(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only cells marked dirty are loaded into the daemon.
+	db.DB[3*8] = 4
+	db.DB[5*8] = 5
+	db.MarkDirty(5)
+	if err = pirServer.SetDB(db); err != nil {
+		t.Fatal(err)
+	}
+
+	responseChan := make(chan []byte, 1)
+	masks := make([]byte, 2)
+	masks[0] = 1<<3 | 1<<5
+	if err = pirServer.Read(masks, responseChan); err != nil {
+		t.Fatal(err)
+	}
+	if response := <-responseChan; response[0] != 3^5 {
+		t.Fatalf("response is incorrect. byte 0 was %d, not %d", response[0], 3^5)
+	}
+
+	if err = pirServer.Read(make([]byte, 3), responseChan); err == nil {
+		t.Fatal("read with wrong mask length should fail")
+	}
+	if pirServer.GetHint() != nil {
+		t.Fatal("cpu backing should not have a hint")
+	}
+}
+
+func TestDaemonRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "talekpird")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "pir.socket")
+	serve := func() net.Listener {
+		listener, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		daemon, _ := NewDaemon("talekpird", "cpu.0")
+		go daemon.Serve(listener)
+		return listener
+	}
+	listener := serve()
+
+	pirServer, err := NewServer(SocketPrefix + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pirServer.Disconnect()
+	pirServer.Configure(8, 16, 1)
+	db, _ := pirServer.GetDB()
+	db.DB[3*8] = 3
+	if err = pirServer.SetDB(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// The daemon restarts, losing its connection and database.
+	listener.Close()
+	pirServer.conn.Close()
+	listener = serve()
+	defer listener.Close()
+
+	responseChan := make(chan []byte, 1)
+	masks := make([]byte, 2)
+	masks[0] = 1 << 3
+	if err = pirServer.Read(masks, responseChan); err != nil {
+		t.Fatalf("read after the daemon restarted failed: %v", err)
+	}
+	if response := <-responseChan; response[0] != 3 {
+		t.Fatalf("response is incorrect after restart. byte 0 was %d, not 3", response[0])
+	}
+
+	// Only dirty cells are loaded again.
+	db.DB[5*8] = 5
+	db.MarkDirty(5)
+	if err = pirServer.SetDB(db); err != nil {
+		t.Fatal(err)
+	}
+	masks[0] = 1<<3 | 1<<5
+	if err = pirServer.Read(masks, responseChan); err != nil {
+		t.Fatal(err)
+	}
+	if response := <-responseChan; response[0] != 3^5 {
+		t.Fatalf("response is incorrect. byte 0 was %d, not %d", response[0], 3^5)
+	}
+}
+
+func TestDaemonReconfigure(t *testing.T) {
+	daemon, _ := NewDaemon("talekpird", "cpu.0")
+	server, _ := NewServer("cpu.0")
+	defer server.Disconnect()
+	var db *DB
+	configure := &Server{CellLength: 8, CellCount: 16, BatchSize: 1, RequestLength: 2}
+	if _, err := daemon.apply(server, &db, opConfigure, configure.configuration()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := daemon.apply(server, &db, opSetDB, nil); err != nil {
+		t.Fatal(err)
+	}
+	old := db
+
+	configure.CellCount = 32
+	if _, err := daemon.apply(server, &db, opConfigure, configure.configuration()); err != nil {
+		t.Fatal(err)
+	}
+	if old.shard != nil || server.DB == old {
+		t.Fatal("the database of the previous configuration should be released")
+	}
+	if len(db.DB) != 8*32 {
+		t.Fatalf("database of %d bytes after reconfiguring", len(db.DB))
+	}
+}
+
 func BenchmarkPir(b *testing.B) {
 	cellLength := 1024
 	cellCount := 2048
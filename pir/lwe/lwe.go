@@ -0,0 +1,266 @@
+package lwe
+
+/**
+ * A single-server PIR scheme based on learning with errors, in the style of
+ * Henzinger et al., "One Server for the Price of Two: Simple and Fast
+ * Single-Server Private Information Retrieval" (SimplePIR, USENIX Sec 2023).
+ *
+ * The database is a matrix D with a column for each bucket and a row for
+ * each byte of a bucket. All arithmetic is modulo q = 2^32.
+ * The public matrix A has a row of N elements for each bucket, derived from
+ * a fixed seed. To read bucket i, the client sends
+ *   query = A * s + e + delta * u_i
+ * for a secret s, small error e and unit vector u_i. The server responds with
+ *   answer = D * query
+ * and publishes the hint H = D * A for its database. The client then recovers
+ * column i of D by rounding answer - H * s.
+ *
+ * Queries are 4 bytes per bucket, responses 4 bytes per byte of a bucket, and
+ * the hint is 4*N bytes per byte of a bucket, which changes with every update
+ * of the database.
+ */
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"runtime"
+	"sync"
+)
+
+// N is the dimension of the LWE secret.
+const N = 1024
+
+// PlainBits is the number of bits of the database in each element of a response.
+const PlainBits = 8
+
+// MaxBuckets bounds the number of buckets for which the error accumulated in
+// a response stays well below delta/2, so that decoding succeeds.
+const MaxBuckets = 1 << 20
+
+// delta scales a plaintext into the top PlainBits bits of an element.
+const delta = uint32(1) << (32 - PlainBits)
+
+// matrixKey is the public, fixed key from which rows of A are generated.
+var matrixKey = []byte("talek lwe matrix")
+
+// matrix generates rows of the public matrix A.
+type matrix struct {
+	block cipher.Block
+	buf   []byte
+}
+
+func newMatrix() *matrix {
+	m := &matrix{}
+	m.block, _ = aes.NewCipher(matrixKey)
+	m.buf = make([]byte, 4*N)
+	return m
+}
+
+// row fills out with row j of A, which is AES-CTR output at block j*4N/16.
+func (m *matrix) row(j uint64, out []uint32) {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], j*(4*N/aes.BlockSize))
+	for i := range m.buf {
+		m.buf[i] = 0
+	}
+	cipher.NewCTR(m.block, iv).XORKeyStream(m.buf, m.buf)
+	for i := range out {
+		out[i] = binary.LittleEndian.Uint32(m.buf[4*i:])
+	}
+}
+
+// QueryLength is the number of bytes of a query for numBuckets buckets.
+func QueryLength(numBuckets int) int {
+	return 4 * numBuckets
+}
+
+// ResponseLength is the number of bytes of a response for buckets of bucketSize bytes.
+func ResponseLength(bucketSize int) int {
+	return 4 * bucketSize
+}
+
+// HintLength is the number of bytes of the hint for buckets of bucketSize bytes.
+func HintLength(bucketSize int) int {
+	return 4 * N * bucketSize
+}
+
+// SecretLength is the number of bytes of the secret of a query.
+const SecretLength = 4 * N
+
+// sampleError draws from a centered binomial distribution with standard deviation 8.
+func sampleError(rand io.Reader) (uint32, error) {
+	var buf [32]byte
+	if _, err := io.ReadFull(rand, buf[:]); err != nil {
+		return 0, err
+	}
+	e := 0
+	for i := 0; i < 2; i++ {
+		e += bits.OnesCount64(binary.LittleEndian.Uint64(buf[8*i:]))
+		e -= bits.OnesCount64(binary.LittleEndian.Uint64(buf[16+8*i:]))
+	}
+	return uint32(int32(e)), nil
+}
+
+// Query creates a query for `bucket` out of numBuckets buckets, and the
+// secret needed to recover the bucket from the response.
+func Query(bucket uint64, numBuckets uint64, rand io.Reader) ([]byte, []byte, error) {
+	if bucket >= numBuckets {
+		return nil, nil, fmt.Errorf("bucket=%v must be <numBuckets=%v", bucket, numBuckets)
+	}
+	if numBuckets > MaxBuckets {
+		return nil, nil, fmt.Errorf("numBuckets=%v must be <=%v", numBuckets, MaxBuckets)
+	}
+
+	secret := make([]byte, SecretLength)
+	if _, err := io.ReadFull(rand, secret); err != nil {
+		return nil, nil, err
+	}
+	s := make([]uint32, N)
+	for i := range s {
+		s[i] = binary.LittleEndian.Uint32(secret[4*i:])
+	}
+
+	m := newMatrix()
+	a := make([]uint32, N)
+	query := make([]byte, QueryLength(int(numBuckets)))
+	for j := uint64(0); j < numBuckets; j++ {
+		m.row(j, a)
+		var v uint32
+		for i := range a {
+			v += a[i] * s[i]
+		}
+		e, err := sampleError(rand)
+		if err != nil {
+			return nil, nil, err
+		}
+		v += e
+		if j == bucket {
+			v += delta
+		}
+		binary.LittleEndian.PutUint32(query[4*j:], v)
+	}
+	return query, secret, nil
+}
+
+// Hint computes H = D * A for a database of buckets of bucketSize bytes.
+func Hint(data []byte, bucketSize int) ([]byte, error) {
+	if bucketSize < 1 || len(data)%bucketSize != 0 {
+		return nil, fmt.Errorf("data(len=%v) not multiple of bucketSize=%v", len(data), bucketSize)
+	}
+	numBuckets := len(data) / bucketSize
+	hint := make([]uint32, N*bucketSize)
+
+	// Rows of the hint are split across threads, each generating A.
+	numThreads := runtime.NumCPU()
+	if numThreads > bucketSize {
+		numThreads = bucketSize
+	}
+	var wg sync.WaitGroup
+	for t := 0; t < numThreads; t++ {
+		wg.Add(1)
+		go func(start int, end int) {
+			defer wg.Done()
+			m := newMatrix()
+			a := make([]uint32, N)
+			for j := 0; j < numBuckets; j++ {
+				m.row(uint64(j), a)
+				bucket := data[j*bucketSize:]
+				for r := start; r < end; r++ {
+					d := uint32(bucket[r])
+					if d == 0 {
+						continue
+					}
+					h := hint[r*N : (r+1)*N]
+					for i := range h {
+						h[i] += d * a[i]
+					}
+				}
+			}
+		}(t*bucketSize/numThreads, (t+1)*bucketSize/numThreads)
+	}
+	wg.Wait()
+
+	out := make([]byte, HintLength(bucketSize))
+	for i, v := range hint {
+		binary.LittleEndian.PutUint32(out[4*i:], v)
+	}
+	return out, nil
+}
+
+// UpdateHint updates the hint of a database in place, for bucket changing
+// from old to new contents of bucketSize bytes, which costs as much as the
+// contribution of one bucket to Hint.
+func UpdateHint(hint []byte, bucket uint64, old []byte, new []byte) error {
+	bucketSize := len(new)
+	if len(old) != bucketSize || len(hint) != HintLength(bucketSize) {
+		return fmt.Errorf("hint(len=%v) does not match buckets(len=%v, %v)", len(hint), len(old), len(new))
+	}
+	a := make([]uint32, N)
+	newMatrix().row(bucket, a)
+	for r := range new {
+		d := uint32(new[r]) - uint32(old[r])
+		if d == 0 {
+			continue
+		}
+		h := hint[4*N*r : 4*N*(r+1)]
+		for i := range a {
+			v := binary.LittleEndian.Uint32(h[4*i:]) + d*a[i]
+			binary.LittleEndian.PutUint32(h[4*i:], v)
+		}
+	}
+	return nil
+}
+
+// Answer computes the response D * query for a database of buckets of bucketSize bytes.
+func Answer(data []byte, bucketSize int, query []byte) ([]byte, error) {
+	if bucketSize < 1 || len(data)%bucketSize != 0 {
+		return nil, fmt.Errorf("data(len=%v) not multiple of bucketSize=%v", len(data), bucketSize)
+	}
+	numBuckets := len(data) / bucketSize
+	if len(query) != QueryLength(numBuckets) {
+		return nil, fmt.Errorf("query(len=%v) should be %v bytes", len(query), QueryLength(numBuckets))
+	}
+	answer := make([]uint32, bucketSize)
+	for j := 0; j < numBuckets; j++ {
+		q := binary.LittleEndian.Uint32(query[4*j:])
+		bucket := data[j*bucketSize : (j+1)*bucketSize]
+		for r, d := range bucket {
+			answer[r] += uint32(d) * q
+		}
+	}
+	out := make([]byte, ResponseLength(bucketSize))
+	for r, v := range answer {
+		binary.LittleEndian.PutUint32(out[4*r:], v)
+	}
+	return out, nil
+}
+
+// Recover decodes the bucket from an answer, using the hint of the database
+// the answer was computed on and the secret of the query.
+func Recover(hint []byte, secret []byte, answer []byte) ([]byte, error) {
+	if len(secret) != SecretLength {
+		return nil, errors.New("invalid secret")
+	}
+	if len(answer)%4 != 0 || len(hint) != HintLength(len(answer)/4) {
+		return nil, fmt.Errorf("hint(len=%v) does not match answer(len=%v)", len(hint), len(answer))
+	}
+	s := make([]uint32, N)
+	for i := range s {
+		s[i] = binary.LittleEndian.Uint32(secret[4*i:])
+	}
+	bucket := make([]byte, len(answer)/4)
+	for r := range bucket {
+		v := binary.LittleEndian.Uint32(answer[4*r:])
+		h := hint[4*N*r:]
+		for i := range s {
+			v -= binary.LittleEndian.Uint32(h[4*i:]) * s[i]
+		}
+		bucket[r] = byte((v + delta/2) >> (32 - PlainBits))
+	}
+	return bucket, nil
+}
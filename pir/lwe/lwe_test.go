@@ -0,0 +1,63 @@
+package lwe
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	bucketSize := 32
+	numBuckets := 64
+	data := make([]byte, bucketSize*numBuckets)
+	rand.Read(data)
+
+	hint, err := Hint(data, bucketSize)
+	if err != nil {
+		t.Fatalf("Hint failed: %v", err)
+	}
+	for _, bucket := range []uint64{0, 17, 63} {
+		query, secret, err := Query(bucket, uint64(numBuckets), rand.Reader)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		answer, err := Answer(data, bucketSize, query)
+		if err != nil {
+			t.Fatalf("Answer failed: %v", err)
+		}
+		result, err := Recover(hint, secret, answer)
+		if err != nil {
+			t.Fatalf("Recover failed: %v", err)
+		}
+		if !bytes.Equal(result, data[int(bucket)*bucketSize:int(bucket+1)*bucketSize]) {
+			t.Fatalf("Recovered bucket %d is incorrect", bucket)
+		}
+	}
+}
+
+func TestInvalidQuery(t *testing.T) {
+	if _, _, err := Query(64, 64, rand.Reader); err == nil {
+		t.Fatal("Query should fail with out of bounds bucket")
+	}
+	if _, _, err := Query(0, MaxBuckets+1, rand.Reader); err == nil {
+		t.Fatal("Query should fail with too many buckets")
+	}
+	if _, err := Answer(make([]byte, 64), 8, make([]byte, 4)); err == nil {
+		t.Fatal("Answer should fail with a mismatched query")
+	}
+}
+
+func BenchmarkQuery(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Query(1, 1024, rand.Reader)
+	}
+}
+
+func BenchmarkHint(b *testing.B) {
+	data := make([]byte, 256*1024)
+	rand.Read(data)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Hint(data, 256)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package pir
+
+import (
+	"errors"
+	"os"
+)
+
+func mapFile(file *os.File, size int) ([]byte, error) {
+	return nil, errors.New("file-backed databases are not supported on this platform")
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
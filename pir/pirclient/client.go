@@ -5,6 +5,9 @@ import (
 	"fmt"
 
 	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/drbg"
+	"github.com/privacylab/talek/pir/dpf"
+	"github.com/privacylab/talek/pir/lwe"
 	"github.com/privacylab/talek/pir/xor"
 )
 
@@ -61,6 +64,81 @@ func (c *Client) GenerateRequestVectors(bucket uint64, numServers uint64, numBuc
 	return req, nil
 }
 
+// GenerateSeededRequest creates a request vector for the first server,
+// and numServers-1 seeds for the others to retrieve data at the specified bucket.
+// Each seed stands for the request vector produced by drbg.Overlay on a zero vector.
+func (c *Client) GenerateSeededRequest(bucket uint64, numServers uint64, numBuckets uint64) ([]byte, [][]byte, error) {
+	if numServers < 2 {
+		c.log.Error.Printf("GenerateSeededRequest called with too few servers=%v", numServers)
+		return nil, nil, fmt.Errorf("numServers=%v must be >1", numServers)
+	}
+	if bucket >= numBuckets {
+		c.log.Error.Printf("GenerateSeededRequest called with invalid bucket=%v, numBuckets=%v", bucket, numBuckets)
+		return nil, nil, fmt.Errorf("bucket=%v must be <numBuckets=%v", bucket, numBuckets)
+	}
+
+	numBytes := numBuckets / 8
+	if (numBuckets % 8) != 0 {
+		numBytes++
+	}
+
+	// Encode the secret
+	req := make([]byte, numBytes)
+	req[bucket/8] |= 1 << (bucket % 8)
+
+	// Generate numServers-1 seeds, XORing their expansions into the secret
+	seeds := make([][]byte, numServers-1)
+	for i := range seeds {
+		seed, err := drbg.NewSeed()
+		if err != nil {
+			c.log.Error.Printf("GenerateSeededRequest failed: error generating seed %v", err)
+			return nil, nil, err
+		}
+		if seeds[i], err = seed.MarshalBinary(); err != nil {
+			return nil, nil, err
+		}
+		if err = drbg.Overlay(seeds[i], req); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return req, seeds, nil
+}
+
+// GenerateDPFKeys creates a pair of distributed point function keys
+// to retrieve data at the specified bucket from two servers
+func (c *Client) GenerateDPFKeys(bucket uint64, numBuckets uint64) ([][]byte, error) {
+	if bucket >= numBuckets {
+		c.log.Error.Printf("GenerateDPFKeys called with invalid bucket=%v, numBuckets=%v", bucket, numBuckets)
+		return nil, fmt.Errorf("bucket=%v must be <numBuckets=%v", bucket, numBuckets)
+	}
+
+	k0, k1, err := dpf.Gen(bucket, numBuckets, rand.Reader)
+	if err != nil {
+		c.log.Error.Printf("GenerateDPFKeys failed: %v", err)
+		return nil, err
+	}
+	keys := make([][]byte, 2)
+	if keys[0], err = k0.MarshalBinary(); err != nil {
+		return nil, err
+	}
+	if keys[1], err = k1.MarshalBinary(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// GenerateLWEQuery creates a query to retrieve data at the specified bucket
+// from a single server, and the secret needed to recover it from the response
+func (c *Client) GenerateLWEQuery(bucket uint64, numBuckets uint64) ([]byte, []byte, error) {
+	query, secret, err := lwe.Query(bucket, numBuckets, rand.Reader)
+	if err != nil {
+		c.log.Error.Printf("GenerateLWEQuery failed: %v", err)
+		return nil, nil, err
+	}
+	return query, secret, nil
+}
+
 // CombineResponses returns the result from XORing all responses together
 // Precondition: all responses are the same length
 // Returns a byte array of the result
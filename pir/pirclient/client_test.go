@@ -3,6 +3,10 @@ package pirclient
 import (
 	"encoding/binary"
 	"testing"
+
+	"github.com/privacylab/talek/drbg"
+	"github.com/privacylab/talek/pir/dpf"
+	"github.com/privacylab/talek/pir/lwe"
 )
 
 func TestNewClient(t *testing.T) {
@@ -107,3 +111,96 @@ func TestCombineResponsesInvalid(t *testing.T) {
 		t.Errorf("CombineResponses is okay with bigger later responses")
 	}
 }
+
+func TestGenerateSeededRequest(t *testing.T) {
+	c := NewClient("test")
+	req, seeds, err := c.GenerateSeededRequest(1, 3, 65)
+	if err != nil {
+		t.Errorf("GenerateSeededRequest failed: %v", err)
+	}
+	if len(seeds) != 2 {
+		t.Errorf("GenerateSeededRequest produced %v seeds, expected 2", len(seeds))
+	}
+	reqVec := [][]byte{req}
+	for _, seed := range seeds {
+		vec := make([]byte, len(req))
+		if err = drbg.Overlay(seed, vec); err != nil {
+			t.Errorf("Expanding request seed failed: %v", err)
+		}
+		reqVec = append(reqVec, vec)
+	}
+	resultBytes, err := c.CombineResponses(reqVec)
+	if err != nil {
+		t.Errorf("CombineResponses failed: %v", err)
+	}
+	result, _ := binary.Uvarint(resultBytes)
+	if result != 2 {
+		t.Errorf("Secret request vector should translate to 2, not %v", result)
+	}
+}
+
+func TestGenerateSeededRequestInvalid(t *testing.T) {
+	c := NewClient("test")
+	if _, _, err := c.GenerateSeededRequest(1, 1, 64); err == nil {
+		t.Errorf("GenerateSeededRequest should fail with 1 server")
+	}
+	if _, _, err := c.GenerateSeededRequest(65, 3, 64); err == nil {
+		t.Errorf("GenerateSeededRequest should fail with out of bounds bucket")
+	}
+}
+
+func TestGenerateLWEQuery(t *testing.T) {
+	c := NewClient("test")
+	data := make([]byte, 8*64)
+	data[8*5] = 2
+	query, secret, err := c.GenerateLWEQuery(5, 64)
+	if err != nil {
+		t.Errorf("GenerateLWEQuery failed: %v", err)
+	}
+	hint, _ := lwe.Hint(data, 8)
+	answer, _ := lwe.Answer(data, 8, query)
+	resultBytes, err := lwe.Recover(hint, secret, answer)
+	if err != nil {
+		t.Errorf("Recover failed: %v", err)
+	}
+	result, _ := binary.Uvarint(resultBytes)
+	if result != 2 {
+		t.Errorf("LWE query should retrieve 2, not %v", result)
+	}
+	if _, _, err = c.GenerateLWEQuery(65, 64); err == nil {
+		t.Errorf("GenerateLWEQuery should fail with out of bounds bucket")
+	}
+}
+
+func TestGenerateDPFKeys(t *testing.T) {
+	c := NewClient("test")
+	keys, err := c.GenerateDPFKeys(1, 1024)
+	if err != nil {
+		t.Errorf("GenerateDPFKeys failed: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("GenerateDPFKeys produced %v keys, expected 2", len(keys))
+	}
+	reqVec := make([][]byte, len(keys))
+	for i, key := range keys {
+		if reqVec[i], err = dpf.Expand(key, 1024); err != nil {
+			t.Errorf("Expanding DPF key failed: %v", err)
+		}
+	}
+	resultBytes, err := c.CombineResponses(reqVec)
+	if err != nil {
+		t.Errorf("CombineResponses failed: %v", err)
+	}
+	result, _ := binary.Uvarint(resultBytes)
+	if result != 2 {
+		t.Errorf("Secret request vector should translate to 2, not %v", result)
+	}
+}
+
+func TestGenerateDPFKeysInvalidBucket(t *testing.T) {
+	c := NewClient("test")
+	_, err := c.GenerateDPFKeys(65, 64)
+	if err == nil {
+		t.Errorf("GenerateDPFKeys should fail with out of bounds bucket")
+	}
+}
@@ -0,0 +1,202 @@
+package pircpu
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/pir/pirinterface"
+	"github.com/privacylab/talek/pir/xor"
+)
+
+// ParallelBlockSize is the target number of bytes of data in each block of
+// buckets scanned by a worker. Every request in a batch is applied to a block
+// while it is in cache, so each block is streamed from memory once per batch.
+const ParallelBlockSize = 256 * 1024
+
+// ShardCPUParallel represents a read-only shard of the database
+// backed by a multi-threaded CPU implementation of PIR
+type ShardCPUParallel struct {
+	// Private State
+	log          *common.Logger
+	name         string
+	bucketSize   int
+	numBuckets   int
+	data         []byte
+	numThreads   int
+	blockBuckets int
+}
+
+// NewParallelShard creates a new parallel cpu shard conforming to the common interface
+func NewParallelShard(bucketSize int, data []byte, userdata string) pirinterface.Shard {
+	parts := strings.Split(userdata, ".")
+	if len(parts) < 3 {
+		fmt.Fprintf(os.Stderr, "Invalid cpu specification: %s. Should be cpu.parallel.N", parts)
+		return nil
+	}
+	numThreads, err := strconv.ParseInt(parts[2], 10, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid cpu specification: %s. Should be cpu.parallel.N", parts)
+		return nil
+	}
+	shard, err := NewShardCPUParallel("CPU Parallel Shard ("+userdata+")", bucketSize, data, int(numThreads))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not create CPU shard: %v", err)
+		return nil
+	}
+	return pirinterface.Shard(shard)
+}
+
+func init() {
+	pirinterface.Register("cpu.parallel", NewParallelShard)
+}
+
+// NewShardCPUParallel creates a new CPU-backed shard, reading with numThreads goroutines
+// The data is represented as a flat byte array = append(bucket_1, bucket_2 ... bucket_n)
+// Pre-conditions:
+// - len(data) must be a multiple of bucketSize
+// - numThreads must be at least 1
+// Returns: the shard, or an error if mismatched size
+func NewShardCPUParallel(name string, bucketSize int, data []byte, numThreads int) (*ShardCPUParallel, error) {
+	s := &ShardCPUParallel{}
+	s.log = common.NewLogger(name)
+	s.name = name
+
+	if bucketSize < 1 || len(data)%bucketSize != 0 {
+		return nil, fmt.Errorf("NewShardCPUParallel(%v) failed: data(len=%v) not multiple of bucketSize=%v", name, len(data), bucketSize)
+	}
+
+	if numThreads < 1 {
+		return nil, fmt.Errorf("NewShardCPUParallel(%v) failed: numThreads=%v must be at least 1", name, numThreads)
+	}
+
+	s.bucketSize = bucketSize
+	s.numBuckets = (len(data) / bucketSize)
+	s.data = data
+	s.numThreads = numThreads
+
+	// Blocks are a multiple of 8 buckets, so that each covers whole bytes of a request.
+	s.blockBuckets = (ParallelBlockSize / bucketSize) &^ 7
+	if s.blockBuckets < 8 {
+		s.blockBuckets = 8
+	}
+
+	s.log.Info.Printf("NewShardCPUParallel(%v) finished\n", s.name)
+	return s, nil
+}
+
+// Free currently does nothing. ShardCPUParallel waits for the go garbage collector
+func (s *ShardCPUParallel) Free() error {
+	s.log.Info.Printf("%v.Free finished\n", s.name)
+	return nil
+}
+
+// GetBucketSize returns the size (in bytes) of a bucket
+func (s *ShardCPUParallel) GetBucketSize() int {
+	return s.bucketSize
+}
+
+// GetNumBuckets returns the number of buckets in the shard
+func (s *ShardCPUParallel) GetNumBuckets() int {
+	return s.numBuckets
+}
+
+// GetData returns a slice of the data
+func (s *ShardCPUParallel) GetData() []byte {
+	return s.data[:]
+}
+
+// Read handles a batch read, where each request is concatentated into `reqs`
+// each request consists of `reqLength` bytes, and starts on a byte boundary.
+// Returns: a single byte array where responses are concatenated by the order in `reqs`
+// each response consists of `s.bucketSize` bytes
+//
+// Blocks of buckets are distributed over the worker goroutines, each of which
+// accumulates a partial response for every request. The partial responses
+// are then combined in parallel over requests.
+func (s *ShardCPUParallel) Read(reqs []byte, reqLength int) ([]byte, error) {
+	if len(reqs)%reqLength != 0 {
+		return nil, fmt.Errorf("ShardCPUParallel.Read expects len(reqs)=%d to be a multiple of reqLength=%d", len(reqs), reqLength)
+	}
+	s.log.Trace.Printf("%v.Read: start\n", s.name)
+	numReqs := len(reqs) / reqLength
+	respLength := numReqs * s.bucketSize
+	numBlocks := (s.numBuckets + s.blockBuckets - 1) / s.blockBuckets
+
+	numThreads := s.numThreads
+	if numThreads > numBlocks {
+		numThreads = numBlocks
+	}
+	if numThreads < 1 {
+		return make([]byte, respLength), nil
+	}
+
+	// Scan blocks of buckets
+	partials := make([][]byte, numThreads)
+	blocks := make(chan int, numBlocks)
+	for block := 0; block < numBlocks; block++ {
+		blocks <- block
+	}
+	close(blocks)
+	var wg sync.WaitGroup
+	for t := 0; t < numThreads; t++ {
+		partials[t] = make([]byte, respLength)
+		wg.Add(1)
+		go func(responses []byte) {
+			defer wg.Done()
+			for block := range blocks {
+				s.readBlock(reqs, reqLength, numReqs, block, responses)
+			}
+		}(partials[t])
+	}
+	wg.Wait()
+
+	// Combine partial responses
+	responses := partials[0]
+	for reqIndex := 0; reqIndex < numReqs; reqIndex++ {
+		wg.Add(1)
+		go func(reqIndex int) {
+			defer wg.Done()
+			respOffset := reqIndex * s.bucketSize
+			response := responses[respOffset:(respOffset + s.bucketSize)]
+			for t := 1; t < numThreads; t++ {
+				xor.Bytes(response, response, partials[t][respOffset:(respOffset+s.bucketSize)])
+			}
+		}(reqIndex)
+	}
+	wg.Wait()
+
+	s.log.Trace.Printf("%v.Read: end\n", s.name)
+	return responses, nil
+}
+
+// readBlock applies every request in the batch to one block of buckets,
+// XORing matching buckets into responses.
+func (s *ShardCPUParallel) readBlock(reqs []byte, reqLength int, numReqs int, block int, responses []byte) {
+	start := block * s.blockBuckets
+	end := start + s.blockBuckets
+	if end > s.numBuckets {
+		end = s.numBuckets
+	}
+	for reqIndex := 0; reqIndex < numReqs; reqIndex++ {
+		reqOffset := reqIndex * reqLength
+		respOffset := reqIndex * s.bucketSize
+		response := responses[respOffset:(respOffset + s.bucketSize)]
+		for bucketIndex := start; bucketIndex < end; bucketIndex++ {
+			reqByte := reqs[reqOffset+(bucketIndex/8)]
+			if reqByte == 0 {
+				// Skip to the next byte of the request
+				bucketIndex |= 7
+				continue
+			}
+			if reqByte&(byte(1)<<uint(bucketIndex%8)) != 0 {
+				bucketOffset := bucketIndex * s.bucketSize
+				bucket := s.data[bucketOffset:(bucketOffset + s.bucketSize)]
+				xor.Bytes(response, response, bucket)
+			}
+		}
+	}
+}
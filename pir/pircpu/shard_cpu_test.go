@@ -1,10 +1,15 @@
 package pircpu
 
 import (
+	"bytes"
 	"fmt"
+	"math/rand"
+	"runtime"
+	"strconv"
 	"testing"
 
 	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/pir/pirinterface"
 	pt "github.com/privacylab/talek/pir/pirtest"
 )
 
@@ -109,6 +114,82 @@ func TestShardCPUReadv2(t *testing.T) {
 	fmt.Printf("... done \n")
 }
 
+func TestNewShardParallelInvalidUserData(t *testing.T) {
+	fmt.Printf("TestNewShardParallelInvalidUserData: ...\n")
+	beforeEach()
+	shard := NewShard(pt.TestDepth*pt.TestMessageSize, pt.GenerateData(pt.TestNumMessages*pt.TestMessageSize), "cpu.parallel")
+	if shard != nil {
+		t.Fatalf("new ShardCPUParallel should have failed with invalid user data, but returned a shard")
+	}
+	shard = NewParallelShard(pt.TestDepth*pt.TestMessageSize, pt.GenerateData(pt.TestNumMessages*pt.TestMessageSize), "cpu.parallel.0")
+	if shard != nil {
+		t.Fatalf("new ShardCPUParallel should have failed with no threads, but returned a shard")
+	}
+	shard = NewParallelShard(7, pt.GenerateData(pt.TestNumMessages*pt.TestMessageSize), "cpu.parallel.4")
+	if shard != nil {
+		t.Fatalf("new ShardCPUParallel should have failed with invalid bucketSize, but returned a shard")
+	}
+	fmt.Printf("... done \n")
+}
+
+func TestShardCPUParallelBacking(t *testing.T) {
+	fmt.Printf("TestShardCPUParallelBacking: ...\n")
+	beforeEach()
+	cons := pirinterface.GetBacking("cpu.parallel.4")
+	if cons == nil {
+		t.Fatalf("cpu.parallel backing is not registered")
+	}
+	shard := cons(pt.TestDepth*pt.TestMessageSize, pt.GenerateData(pt.TestNumMessages*pt.TestMessageSize), "cpu.parallel.4")
+	if _, ok := shard.(*ShardCPUParallel); !ok {
+		t.Fatalf("cpu.parallel.4 should create a ShardCPUParallel")
+	}
+	pt.AfterEach(t, shard, nil)
+	fmt.Printf("... done \n")
+}
+
+func TestShardCPUParallelRead(t *testing.T) {
+	fmt.Printf("TestShardCPUParallelRead: ...\n")
+	beforeEach()
+	for _, threads := range []string{"1", "2", "8"} {
+		shard := NewParallelShard(pt.TestDepth*pt.TestMessageSize, pt.GenerateData(pt.TestNumMessages*pt.TestMessageSize), "cpu.parallel."+threads)
+		if shard == nil {
+			t.Fatalf("cannot create new ShardCPUParallel with %s threads\n", threads)
+		}
+		pt.HelperTestShardRead(t, shard)
+		pt.HelperTestClientRead(t, shard)
+		pt.AfterEach(t, shard, nil)
+	}
+	fmt.Printf("... done \n")
+}
+
+func TestShardCPUParallelMatchesv0(t *testing.T) {
+	fmt.Printf("TestShardCPUParallelMatchesv0: ...\n")
+	beforeEach()
+	// Enough buckets to span several blocks.
+	bucketSize := 4096
+	data := pt.GenerateData(bucketSize * 1000)
+	serial := NewShard(bucketSize, data, "cpu.0")
+	parallel := NewParallelShard(bucketSize, data, "cpu.parallel.3")
+	reqLength := (serial.GetNumBuckets() + 7) / 8
+	reqs := make([]byte, reqLength*pt.TestBatchSize)
+	rand.Read(reqs)
+
+	expected, err := serial.Read(reqs, reqLength)
+	if err != nil {
+		t.Fatalf("error calling shard.Read: %v\n", err)
+	}
+	actual, err := parallel.Read(reqs, reqLength)
+	if err != nil {
+		t.Fatalf("error calling shard.Read: %v\n", err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("parallel read differs from read v0")
+	}
+	pt.AfterEach(t, serial, nil)
+	pt.AfterEach(t, parallel, nil)
+	fmt.Printf("... done \n")
+}
+
 func BenchmarkShardCPUReadv0(b *testing.B) {
 	//fmt.Printf("BenchmarkShardCPUReadv0 began with N=%d... \n", b.N)
 	beforeEach()
@@ -141,3 +222,13 @@ func BenchmarkShardCPUReadv2(b *testing.B) {
 	pt.HelperBenchmarkShardRead(b, shard, pt.BenchBatchSize)
 	pt.AfterEach(b, shard, nil)
 }
+
+func BenchmarkShardCPUReadParallel(b *testing.B) {
+	beforeEach()
+	shard := NewParallelShard(pt.BenchDepth*pt.BenchMessageSize, pt.GenerateData(pt.BenchNumMessages*pt.BenchMessageSize), "cpu.parallel."+strconv.Itoa(runtime.NumCPU()))
+	if shard == nil {
+		b.Fatalf("cannot create new ShardCPUParallel\n")
+	}
+	pt.HelperBenchmarkShardRead(b, shard, pt.BenchBatchSize)
+	pt.AfterEach(b, shard, nil)
+}
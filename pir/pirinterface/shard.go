@@ -17,6 +17,24 @@ type Shard interface {
 	Read(reqs []byte, reqLength int) ([]byte, error)
 }
 
+// HintShard is a Shard of a computational PIR scheme, where clients decode
+// responses using a hint derived from the data of the shard.
+// Requests and responses of such shards are not bit vectors and buckets.
+type HintShard interface {
+	Shard
+	GetHint() []byte
+}
+
+// UpdatableShard is a Shard which derives the shard of an updated copy of its
+// data more cheaply than creating it, when few buckets changed.
+type UpdatableShard interface {
+	Shard
+	// Update creates a shard of data, which holds the data of this shard
+	// except in the buckets marked in dirty. The data of this shard must not
+	// change until Update returns.
+	Update(data []byte, dirty []bool) (Shard, error)
+}
+
 // backings is a static table of the registered / available PIR implementations
 var backings map[string]func(int, []byte, string) Shard
 
@@ -29,14 +47,18 @@ func Register(prefix string, cons func(int, []byte, string) Shard) {
 }
 
 // GetBacking allows a client to search for available shard constructors
+// When several registered prefixes match, the longest one is used.
 func GetBacking(prefix string) func(int, []byte, string) Shard {
 	if backings == nil {
 		return nil
 	}
+	var match string
+	var cons func(int, []byte, string) Shard
 	for k, v := range backings {
-		if strings.HasPrefix(prefix, k) {
-			return v
+		if strings.HasPrefix(prefix, k) && len(k) >= len(match) {
+			match = k
+			cons = v
 		}
 	}
-	return nil
+	return cons
 }
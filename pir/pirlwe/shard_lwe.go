@@ -0,0 +1,153 @@
+package pirlwe
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/pir/lwe"
+	"github.com/privacylab/talek/pir/pirinterface"
+)
+
+// ShardLWE represents a read-only shard of the database
+// backed by single-server, LWE-based PIR.
+// Requests are lwe queries of lwe.QueryLength(numBuckets) bytes, and
+// responses are lwe answers of lwe.ResponseLength(bucketSize) bytes, which
+// clients decode using the hint of the shard.
+type ShardLWE struct {
+	// Private State
+	log        *common.Logger
+	name       string
+	bucketSize int
+	numBuckets int
+	data       []byte
+	hint       []byte
+}
+
+// NewShard creates a new lwe shard conforming to the common interface
+func NewShard(bucketSize int, data []byte, userdata string) pirinterface.Shard {
+	shard, err := NewShardLWE("LWE Shard ("+userdata+")", bucketSize, data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not create LWE shard: %v", err)
+		return nil
+	}
+	return pirinterface.Shard(shard)
+}
+
+func init() {
+	pirinterface.Register("lwe", NewShard)
+}
+
+// NewShardLWE creates a new LWE-backed shard, computing the hint of its data
+// The data is represented as a flat byte array = append(bucket_1, bucket_2 ... bucket_n)
+// Pre-conditions:
+// - len(data) must be a multiple of bucketSize
+// - there must be at most lwe.MaxBuckets buckets
+// Returns: the shard, or an error if mismatched size
+func NewShardLWE(name string, bucketSize int, data []byte) (*ShardLWE, error) {
+	s := &ShardLWE{}
+	s.log = common.NewLogger(name)
+	s.name = name
+
+	if bucketSize < 1 || len(data)%bucketSize != 0 {
+		return nil, fmt.Errorf("NewShardLWE(%v) failed: data(len=%v) not multiple of bucketSize=%v", name, len(data), bucketSize)
+	}
+	if len(data)/bucketSize > lwe.MaxBuckets {
+		return nil, fmt.Errorf("NewShardLWE(%v) failed: %v buckets is more than %v", name, len(data)/bucketSize, lwe.MaxBuckets)
+	}
+
+	s.bucketSize = bucketSize
+	s.numBuckets = (len(data) / bucketSize)
+	s.data = data
+
+	hint, err := lwe.Hint(data, bucketSize)
+	if err != nil {
+		return nil, fmt.Errorf("NewShardLWE(%v) failed: %v", name, err)
+	}
+	s.hint = hint
+
+	s.log.Info.Printf("NewShardLWE(%v) finished\n", s.name)
+	return s, nil
+}
+
+// Update creates the shard of data, which differs from the data of s only in
+// the buckets marked in dirty. The hint of s is updated for the changed
+// buckets rather than computed again.
+func (s *ShardLWE) Update(data []byte, dirty []bool) (pirinterface.Shard, error) {
+	if len(data) != len(s.data) || len(dirty) != s.numBuckets {
+		return nil, fmt.Errorf("%v.Update failed: data(len=%v) does not match the shard", s.name, len(data))
+	}
+	next := &ShardLWE{log: s.log, name: s.name, bucketSize: s.bucketSize, numBuckets: s.numBuckets, data: data, hint: s.hint}
+	copied := false
+	for bucket, changed := range dirty {
+		if !changed {
+			continue
+		}
+		old := s.data[bucket*s.bucketSize : (bucket+1)*s.bucketSize]
+		new := data[bucket*s.bucketSize : (bucket+1)*s.bucketSize]
+		if bytes.Equal(old, new) {
+			continue
+		}
+		// The hint of s may still be sent to clients, so it is not modified.
+		if !copied {
+			next.hint = append([]byte(nil), s.hint...)
+			copied = true
+		}
+		if err := lwe.UpdateHint(next.hint, uint64(bucket), old, new); err != nil {
+			return nil, err
+		}
+	}
+	return next, nil
+}
+
+// Free currently does nothing. ShardLWE waits for the go garbage collector
+func (s *ShardLWE) Free() error {
+	s.log.Info.Printf("%v.Free finished\n", s.name)
+	return nil
+}
+
+// GetBucketSize returns the size (in bytes) of a bucket
+func (s *ShardLWE) GetBucketSize() int {
+	return s.bucketSize
+}
+
+// GetNumBuckets returns the number of buckets in the shard
+func (s *ShardLWE) GetNumBuckets() int {
+	return s.numBuckets
+}
+
+// GetData returns a slice of the data
+func (s *ShardLWE) GetData() []byte {
+	return s.data[:]
+}
+
+// GetHint returns the hint clients need to decode responses from the shard
+func (s *ShardLWE) GetHint() []byte {
+	return s.hint
+}
+
+// Read handles a batch read, where each lwe query is concatentated into `reqs`
+// each query consists of `reqLength` bytes.
+// Returns: a single byte array where responses are concatenated by the order in `reqs`
+// each response consists of lwe.ResponseLength(s.bucketSize) bytes
+func (s *ShardLWE) Read(reqs []byte, reqLength int) ([]byte, error) {
+	if reqLength != lwe.QueryLength(s.numBuckets) || len(reqs)%reqLength != 0 {
+		return nil, fmt.Errorf("ShardLWE.Read expects len(reqs)=%d to be a multiple of reqLength=%d", len(reqs), lwe.QueryLength(s.numBuckets))
+	}
+	s.log.Trace.Printf("%v.Read: start\n", s.name)
+	numReqs := len(reqs) / reqLength
+	respLength := lwe.ResponseLength(s.bucketSize)
+	responses := make([]byte, numReqs*respLength)
+
+	for reqIndex := 0; reqIndex < numReqs; reqIndex++ {
+		answer, err := lwe.Answer(s.data, s.bucketSize, reqs[reqIndex*reqLength:(reqIndex+1)*reqLength])
+		if err != nil {
+			return nil, err
+		}
+		copy(responses[reqIndex*respLength:], answer)
+	}
+
+	s.log.Trace.Printf("%v.Read: end\n", s.name)
+	return responses, nil
+}
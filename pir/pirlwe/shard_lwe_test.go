@@ -0,0 +1,120 @@
+package pirlwe
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/pir/lwe"
+	"github.com/privacylab/talek/pir/pirinterface"
+	pt "github.com/privacylab/talek/pir/pirtest"
+)
+
+func beforeEach() {
+	common.SilenceLoggers()
+}
+
+func TestNewShardInvalidBucketSize(t *testing.T) {
+	fmt.Printf("TestNewShardInvalidBucketSize: ...\n")
+	beforeEach()
+	shard := NewShard(7, pt.GenerateData(pt.TestNumMessages*pt.TestMessageSize), "lwe")
+	if shard != nil {
+		t.Fatalf("new ShardLWE should have failed with invalid bucketSize, but returned a shard")
+	}
+	fmt.Printf("... done \n")
+}
+
+func TestShardLWEBacking(t *testing.T) {
+	if pirinterface.GetBacking("lwe") == nil {
+		t.Fatalf("lwe backing should be registered")
+	}
+}
+
+func TestShardLWERead(t *testing.T) {
+	fmt.Printf("TestShardLWERead: ...\n")
+	beforeEach()
+	bucketSize := pt.TestDepth * pt.TestMessageSize
+	data := pt.GenerateData(pt.TestNumMessages * pt.TestMessageSize)
+	shard := NewShard(bucketSize, data, "lwe")
+	if shard == nil {
+		t.Fatalf("cannot create new ShardLWE\n")
+	}
+	numBuckets := uint64(shard.GetNumBuckets())
+	hint := shard.(pirinterface.HintShard).GetHint()
+
+	buckets := []uint64{0, numBuckets / 2, numBuckets - 1}
+	reqLength := lwe.QueryLength(int(numBuckets))
+	reqs := make([]byte, 0, reqLength*len(buckets))
+	secrets := make([][]byte, len(buckets))
+	for i, bucket := range buckets {
+		query, secret, err := lwe.Query(bucket, numBuckets, rand.Reader)
+		if err != nil {
+			t.Fatalf("error creating query: %v\n", err)
+		}
+		reqs = append(reqs, query...)
+		secrets[i] = secret
+	}
+	responses, err := shard.Read(reqs, reqLength)
+	if err != nil {
+		t.Fatalf("error calling shard.Read: %v\n", err)
+	}
+	respLength := lwe.ResponseLength(bucketSize)
+	for i, bucket := range buckets {
+		result, err := lwe.Recover(hint, secrets[i], responses[i*respLength:(i+1)*respLength])
+		if err != nil {
+			t.Fatalf("error recovering response: %v\n", err)
+		}
+		if !bytes.Equal(result, data[int(bucket)*bucketSize:int(bucket+1)*bucketSize]) {
+			t.Fatalf("response %d for bucket %d is incorrect", i, bucket)
+		}
+	}
+
+	if _, err = shard.Read(reqs, int(numBuckets/8)); err == nil {
+		t.Fatalf("ShardLWE.Read should fail with bit vector requests")
+	}
+	pt.AfterEach(t, shard, nil)
+	fmt.Printf("... done \n")
+}
+
+func TestShardLWEUpdate(t *testing.T) {
+	fmt.Printf("TestShardLWEUpdate: ...\n")
+	beforeEach()
+	bucketSize := pt.TestDepth * pt.TestMessageSize
+	data := pt.GenerateData(pt.TestNumMessages * pt.TestMessageSize)
+	shard := NewShard(bucketSize, data, "lwe")
+	if shard == nil {
+		t.Fatalf("cannot create new ShardLWE\n")
+	}
+	hint := append([]byte(nil), shard.(pirinterface.HintShard).GetHint()...)
+
+	next := append([]byte(nil), data...)
+	dirty := make([]bool, shard.GetNumBuckets())
+	rand.Read(next[bucketSize : 2*bucketSize])
+	dirty[1] = true
+	// Marked, but unchanged.
+	dirty[2] = true
+	updated, err := shard.(pirinterface.UpdatableShard).Update(next, dirty)
+	if err != nil {
+		t.Fatalf("error updating shard: %v\n", err)
+	}
+	expected, err := lwe.Hint(next, bucketSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(updated.(pirinterface.HintShard).GetHint(), expected) {
+		t.Fatalf("updated hint differs from the hint of the updated data")
+	}
+	if !bytes.Equal(shard.(pirinterface.HintShard).GetHint(), hint) {
+		t.Fatalf("Update modified the hint of the previous shard")
+	}
+
+	// Without changes, the hint is shared rather than copied.
+	unchanged, _ := updated.(pirinterface.UpdatableShard).Update(next, make([]bool, len(dirty)))
+	if &unchanged.(pirinterface.HintShard).GetHint()[0] != &updated.(pirinterface.HintShard).GetHint()[0] {
+		t.Fatalf("hint was copied without dirty buckets")
+	}
+	pt.AfterEach(t, shard, nil)
+	fmt.Printf("... done \n")
+}
@@ -0,0 +1,130 @@
+package pirsimd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/pir/pirinterface"
+	"github.com/privacylab/talek/pir/xor"
+)
+
+// TileBuckets is the number of buckets processed together by the kernel.
+// For each tile, the bits of every request covering the tile are read as a
+// single 64-bit column of the request matrix.
+const TileBuckets = 64
+
+// ShardSIMD represents a read-only shard of the database
+// backed by a vectorized CPU implementation of PIR
+type ShardSIMD struct {
+	// Private State
+	log        *common.Logger
+	name       string
+	bucketSize int
+	numBuckets int
+	data       []byte
+}
+
+// NewShard creates a new simd shard conforming to the common interface
+func NewShard(bucketSize int, data []byte, userdata string) pirinterface.Shard {
+	shard, err := NewShardSIMD("SIMD Shard ("+userdata+")", bucketSize, data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not create SIMD shard: %v", err)
+		return nil
+	}
+	return pirinterface.Shard(shard)
+}
+
+func init() {
+	pirinterface.Register("simd", NewShard)
+}
+
+// NewShardSIMD creates a new SIMD-backed shard
+// The data is represented as a flat byte array = append(bucket_1, bucket_2 ... bucket_n)
+// Pre-conditions:
+// - len(data) must be a multiple of bucketSize
+// Returns: the shard, or an error if mismatched size
+func NewShardSIMD(name string, bucketSize int, data []byte) (*ShardSIMD, error) {
+	s := &ShardSIMD{}
+	s.log = common.NewLogger(name)
+	s.name = name
+
+	if bucketSize < 1 || len(data)%bucketSize != 0 {
+		return nil, fmt.Errorf("NewShardSIMD(%v) failed: data(len=%v) not multiple of bucketSize=%v", name, len(data), bucketSize)
+	}
+
+	s.bucketSize = bucketSize
+	s.numBuckets = (len(data) / bucketSize)
+	s.data = data
+
+	s.log.Info.Printf("NewShardSIMD(%v) finished\n", s.name)
+	return s, nil
+}
+
+// Free currently does nothing. ShardSIMD waits for the go garbage collector
+func (s *ShardSIMD) Free() error {
+	s.log.Info.Printf("%v.Free finished\n", s.name)
+	return nil
+}
+
+// GetBucketSize returns the size (in bytes) of a bucket
+func (s *ShardSIMD) GetBucketSize() int {
+	return s.bucketSize
+}
+
+// GetNumBuckets returns the number of buckets in the shard
+func (s *ShardSIMD) GetNumBuckets() int {
+	return s.numBuckets
+}
+
+// GetData returns a slice of the data
+func (s *ShardSIMD) GetData() []byte {
+	return s.data[:]
+}
+
+// Read handles a batch read, where each request is concatentated into `reqs`
+// each request consists of `reqLength` bytes, and starts on a byte boundary.
+// Returns: a single byte array where responses are concatenated by the order in `reqs`
+// each response consists of `s.bucketSize` bytes
+//
+// The database is walked one tile of TileBuckets buckets at a time. Every
+// request in the batch is applied to the tile while it is in cache, using
+// the transposed 64-bit column of request bits for that tile as a mask.
+func (s *ShardSIMD) Read(reqs []byte, reqLength int) ([]byte, error) {
+	if len(reqs)%reqLength != 0 {
+		return nil, fmt.Errorf("ShardSIMD.Read expects len(reqs)=%d to be a multiple of reqLength=%d", len(reqs), reqLength)
+	}
+	s.log.Trace.Printf("%v.Read: start\n", s.name)
+	numReqs := len(reqs) / reqLength
+	responses := make([]byte, numReqs*s.bucketSize)
+
+	for tile := 0; tile < s.numBuckets; tile += TileBuckets {
+		tileData := s.data[tile*s.bucketSize:]
+		for reqIndex := 0; reqIndex < numReqs; reqIndex++ {
+			mask := s.tileMask(reqs[reqIndex*reqLength:(reqIndex+1)*reqLength], tile)
+			response := responses[reqIndex*s.bucketSize : (reqIndex+1)*s.bucketSize]
+			xor.Rows(response, tileData, s.bucketSize, mask)
+		}
+	}
+
+	s.log.Trace.Printf("%v.Read: end\n", s.name)
+	return responses, nil
+}
+
+// tileMask extracts the bits of a request selecting buckets in the tile
+// starting at bucket `tile`.
+func (s *ShardSIMD) tileMask(req []byte, tile int) uint64 {
+	var mask uint64
+	for i := 0; i < TileBuckets/8; i++ {
+		idx := tile/8 + i
+		if idx >= len(req) {
+			break
+		}
+		mask |= uint64(req[idx]) << uint(8*i)
+	}
+	// Clear bits past the end of the shard
+	if remaining := s.numBuckets - tile; remaining < TileBuckets {
+		mask &= (uint64(1) << uint(remaining)) - 1
+	}
+	return mask
+}
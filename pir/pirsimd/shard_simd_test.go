@@ -0,0 +1,79 @@
+package pirsimd
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/pir/pircpu"
+	pt "github.com/privacylab/talek/pir/pirtest"
+)
+
+func beforeEach() {
+	common.SilenceLoggers()
+}
+
+func TestNewShardInvalidBucketSize(t *testing.T) {
+	fmt.Printf("TestNewShardInvalidBucketSize: ...\n")
+	beforeEach()
+	shard := NewShard(7, pt.GenerateData(pt.TestNumMessages*pt.TestMessageSize), "simd")
+	if shard != nil {
+		t.Fatalf("new ShardSIMD should have failed with invalid bucketSize, but returned a shard")
+	}
+	fmt.Printf("... done \n")
+}
+
+func TestShardSIMDRead(t *testing.T) {
+	fmt.Printf("TestShardSIMDRead: ...\n")
+	beforeEach()
+	shard := NewShard(pt.TestDepth*pt.TestMessageSize, pt.GenerateData(pt.TestNumMessages*pt.TestMessageSize), "simd")
+	if shard == nil {
+		t.Fatalf("cannot create new ShardSIMD\n")
+	}
+	pt.HelperTestShardRead(t, shard)
+	pt.HelperTestClientRead(t, shard)
+	pt.AfterEach(t, shard, nil)
+	fmt.Printf("... done \n")
+}
+
+func TestShardSIMDMatchesCPU(t *testing.T) {
+	fmt.Printf("TestShardSIMDMatchesCPU: ...\n")
+	beforeEach()
+	// A number of buckets that does not fill the last tile, with buckets
+	// that are not a multiple of the vector width.
+	for _, bucketSize := range []int{40, 1024} {
+		data := pt.GenerateData(bucketSize * 200)
+		cpu := pircpu.NewShard(bucketSize, data, "cpu.0")
+		simd := NewShard(bucketSize, data, "simd")
+		reqLength := (cpu.GetNumBuckets() + 7) / 8
+		reqs := make([]byte, reqLength*pt.TestBatchSize)
+		rand.Read(reqs)
+
+		expected, err := cpu.Read(reqs, reqLength)
+		if err != nil {
+			t.Fatalf("error calling shard.Read: %v\n", err)
+		}
+		actual, err := simd.Read(reqs, reqLength)
+		if err != nil {
+			t.Fatalf("error calling shard.Read: %v\n", err)
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Fatalf("simd read differs from cpu read with bucketSize=%d", bucketSize)
+		}
+		pt.AfterEach(t, cpu, nil)
+		pt.AfterEach(t, simd, nil)
+	}
+	fmt.Printf("... done \n")
+}
+
+func BenchmarkShardSIMDRead(b *testing.B) {
+	beforeEach()
+	shard := NewShard(pt.BenchDepth*pt.BenchMessageSize, pt.GenerateData(pt.BenchNumMessages*pt.BenchMessageSize), "simd")
+	if shard == nil {
+		b.Fatalf("cannot create new ShardSIMD\n")
+	}
+	pt.HelperBenchmarkShardRead(b, shard, pt.BenchBatchSize)
+	pt.AfterEach(b, shard, nil)
+}
@@ -0,0 +1,72 @@
+package pir
+
+/**
+ * Framing of the protocol between a Server and a PIR daemon over a unix
+ * domain socket. Each request is a frame of
+ *   op (1 byte) | length (4 bytes, little endian) | payload
+ * and is answered by a frame of the same form, where op is statusOK with the
+ * result as payload, or statusError with an error message.
+ *
+ * Payloads:
+ * - opConfigure: cellLength | cellCount | batchSize | requestLength, followed
+ *   by the allowed batch sizes, 4 bytes each
+ * - opLoad: a sequence of cell index (4 bytes) | cell data, written to the DB
+ * - opSetDB: empty. Applies loaded cells to the DB served for reads
+ * - opRead: the request masks of a batch. Answered with the responses
+ * - opGetHint: empty. Answered with the hint of the DB, or empty
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// SocketPrefix is the prefix of backings naming the socket of a PIR daemon.
+const SocketPrefix = "socket:"
+
+const (
+	opConfigure byte = iota + 1
+	opLoad
+	opSetDB
+	opRead
+	opGetHint
+)
+
+const (
+	statusOK    byte = 0
+	statusError byte = 1
+)
+
+// maxFrameSize bounds the payload of a frame. Loads are split to fit.
+const maxFrameSize = 1 << 30
+
+func writeFrame(w io.Writer, op byte, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return errors.New("frame too large")
+	}
+	var header [5]byte
+	header[0] = op
+	binary.LittleEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[1:])
+	if length > maxFrameSize {
+		return 0, nil, errors.New("frame too large")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
@@ -5,6 +5,7 @@
 package xor
 
 import (
+	"math/bits"
 	"runtime"
 	"unsafe"
 )
@@ -51,6 +52,14 @@ func safeXORBytes(dst, a, b []byte) int {
 // Bytes xors the bytes in a and b. The destination is assumed to have enough
 // space. Returns the number of bytes xor'd.
 func Bytes(dst, a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	if done := xorBulk(dst, a, b, n); done > 0 {
+		Bytes(dst[done:], a[done:n], b[done:n])
+		return n
+	}
 	if supportsUnaligned {
 		return fastXORBytes(dst, a, b)
 	}
@@ -76,9 +85,36 @@ func fastXORWords(dst, a, b []byte) {
 
 // Words uses fastXORWords
 func Words(dst, a, b []byte) {
+	if done := xorBulk(dst, a, b, len(b)); done > 0 {
+		dst, a, b = dst[done:], a[done:], b[done:]
+	}
 	if supportsUnaligned {
 		fastXORWords(dst, a, b)
 	} else {
 		safeXORBytes(dst, a, b)
 	}
 }
+
+// Rows xors into dst each row i of src for which bit i of mask is set, where
+// row i is the len(dst) bytes of src starting at i*stride. Applying a 64-bit
+// column of a request matrix to a block of buckets this way keeps dst in
+// registers while the rows are streamed.
+func Rows(dst, src []byte, stride int, mask uint64) {
+	if mask == 0 {
+		return
+	}
+	last := 63 - bits.LeadingZeros64(mask)
+	if last*stride+len(dst) > len(src) {
+		panic("xor: row out of range")
+	}
+	done := xorRowsBulk(dst, src, stride, mask)
+	if done == len(dst) {
+		return
+	}
+	dst = dst[done:]
+	for m := mask; m != 0; m &= m - 1 {
+		i := bits.TrailingZeros64(m)
+		row := src[i*stride+done:]
+		Bytes(dst, dst, row[:len(dst)])
+	}
+}
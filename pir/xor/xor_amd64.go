@@ -0,0 +1,59 @@
+//go:build amd64 && !purego
+// +build amd64,!purego
+
+package xor
+
+// useAVX2 is set when both the processor and the operating system support AVX2.
+var useAVX2 = detectAVX2()
+
+//go:noescape
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+//go:noescape
+func xgetbv() (eax, edx uint32)
+
+//go:noescape
+func xorAVX2(dst, a, b *byte, n int)
+
+//go:noescape
+func xorRowsAVX2(dst, src *byte, stride int, mask uint64, n int)
+
+func detectAVX2() bool {
+	maxID, _, _, _ := cpuid(0, 0)
+	if maxID < 7 {
+		return false
+	}
+	_, _, ecx1, _ := cpuid(1, 0)
+	// OSXSAVE and AVX
+	if ecx1&(1<<27) == 0 || ecx1&(1<<28) == 0 {
+		return false
+	}
+	// The OS must save the XMM and YMM registers.
+	if xcr0, _ := xgetbv(); xcr0&6 != 6 {
+		return false
+	}
+	_, ebx7, _, _ := cpuid(7, 0)
+	return ebx7&(1<<5) != 0
+}
+
+// xorBulk xors the largest multiple of 32 bytes of a and b it can, returning
+// the number of bytes xor'd.
+func xorBulk(dst, a, b []byte, n int) int {
+	n &^= 31
+	if !useAVX2 || n == 0 {
+		return 0
+	}
+	xorAVX2(&dst[0], &a[0], &b[0], n)
+	return n
+}
+
+// xorRowsBulk accumulates the masked rows into the largest multiple of 32
+// bytes of dst it can, returning the number of bytes of dst handled.
+func xorRowsBulk(dst, src []byte, stride int, mask uint64) int {
+	n := len(dst) &^ 31
+	if !useAVX2 || n == 0 || mask == 0 {
+		return 0
+	}
+	xorRowsAVX2(&dst[0], &src[0], stride, mask, n)
+	return n
+}
@@ -0,0 +1,138 @@
+//go:build amd64 && !purego
+// +build amd64,!purego
+
+#include "textflag.h"
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
+
+// func xorAVX2(dst, a, b *byte, n int)
+// n must be a multiple of 32.
+TEXT ·xorAVX2(SB), NOSPLIT, $0-32
+	MOVQ dst+0(FP), DI
+	MOVQ a+8(FP), SI
+	MOVQ b+16(FP), DX
+	MOVQ n+24(FP), CX
+
+loop128:
+	CMPQ CX, $128
+	JB   loop32
+	VMOVDQU 0(SI), Y0
+	VMOVDQU 32(SI), Y1
+	VMOVDQU 64(SI), Y2
+	VMOVDQU 96(SI), Y3
+	VPXOR   0(DX), Y0, Y0
+	VPXOR   32(DX), Y1, Y1
+	VPXOR   64(DX), Y2, Y2
+	VPXOR   96(DX), Y3, Y3
+	VMOVDQU Y0, 0(DI)
+	VMOVDQU Y1, 32(DI)
+	VMOVDQU Y2, 64(DI)
+	VMOVDQU Y3, 96(DI)
+	ADDQ $128, SI
+	ADDQ $128, DX
+	ADDQ $128, DI
+	SUBQ $128, CX
+	JMP  loop128
+
+loop32:
+	CMPQ CX, $32
+	JB   done
+	VMOVDQU (SI), Y0
+	VPXOR   (DX), Y0, Y0
+	VMOVDQU Y0, (DI)
+	ADDQ $32, SI
+	ADDQ $32, DX
+	ADDQ $32, DI
+	SUBQ $32, CX
+	JMP  loop32
+
+done:
+	VZEROUPPER
+	RET
+
+// func xorRowsAVX2(dst, src *byte, stride int, mask uint64, n int)
+// XORs row i of src, starting at src+i*stride, into dst for each bit i set
+// in mask. dst is processed in strips held in registers while every selected
+// row is applied. n must be a multiple of 32.
+TEXT ·xorRowsAVX2(SB), NOSPLIT, $0-40
+	MOVQ dst+0(FP), DI
+	MOVQ src+8(FP), SI
+	MOVQ stride+16(FP), R8
+	MOVQ mask+24(FP), R9
+	MOVQ n+32(FP), CX
+
+strip128:
+	CMPQ CX, $128
+	JB   strip32
+	VMOVDQU 0(DI), Y0
+	VMOVDQU 32(DI), Y1
+	VMOVDQU 64(DI), Y2
+	VMOVDQU 96(DI), Y3
+	MOVQ R9, R10
+
+rows128:
+	TESTQ R10, R10
+	JZ    store128
+	BSFQ  R10, R11
+	BTRQ  R11, R10
+	IMULQ R8, R11
+	ADDQ  SI, R11
+	VPXOR 0(R11), Y0, Y0
+	VPXOR 32(R11), Y1, Y1
+	VPXOR 64(R11), Y2, Y2
+	VPXOR 96(R11), Y3, Y3
+	JMP   rows128
+
+store128:
+	VMOVDQU Y0, 0(DI)
+	VMOVDQU Y1, 32(DI)
+	VMOVDQU Y2, 64(DI)
+	VMOVDQU Y3, 96(DI)
+	ADDQ $128, DI
+	ADDQ $128, SI
+	SUBQ $128, CX
+	JMP  strip128
+
+strip32:
+	CMPQ CX, $32
+	JB   rowsdone
+	VMOVDQU (DI), Y0
+	MOVQ R9, R10
+
+rows32:
+	TESTQ R10, R10
+	JZ    store32
+	BSFQ  R10, R11
+	BTRQ  R11, R10
+	IMULQ R8, R11
+	ADDQ  SI, R11
+	VPXOR (R11), Y0, Y0
+	JMP   rows32
+
+store32:
+	VMOVDQU Y0, (DI)
+	ADDQ $32, DI
+	ADDQ $32, SI
+	SUBQ $32, CX
+	JMP  strip32
+
+rowsdone:
+	VZEROUPPER
+	RET
@@ -0,0 +1,12 @@
+//go:build !amd64 || purego
+// +build !amd64 purego
+
+package xor
+
+func xorBulk(dst, a, b []byte, n int) int {
+	return 0
+}
+
+func xorRowsBulk(dst, src []byte, stride int, mask uint64) int {
+	return 0
+}
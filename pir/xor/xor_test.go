@@ -0,0 +1,88 @@
+package xor
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func TestBytes(t *testing.T) {
+	for _, n := range []int{0, 1, 7, 31, 32, 33, 127, 128, 129, 1000, 4096} {
+		a := randomBytes(n)
+		b := randomBytes(n)
+		expected := make([]byte, n)
+		safeXORBytes(expected, a, b)
+
+		dst := make([]byte, n)
+		if Bytes(dst, a, b) != n {
+			t.Fatalf("Bytes(%d) did not xor all bytes", n)
+		}
+		if !bytes.Equal(dst, expected) {
+			t.Fatalf("Bytes(%d) is incorrect", n)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	for _, n := range []int{0, 8, 32, 40, 128, 1024, 4104} {
+		a := randomBytes(n)
+		b := randomBytes(n)
+		expected := make([]byte, n)
+		safeXORBytes(expected, a, b)
+
+		dst := make([]byte, n)
+		Words(dst, a, b)
+		if !bytes.Equal(dst, expected) {
+			t.Fatalf("Words(%d) is incorrect", n)
+		}
+	}
+}
+
+func TestRows(t *testing.T) {
+	for _, width := range []int{8, 32, 96, 160, 1000} {
+		stride := width + 24
+		src := randomBytes(64 * stride)
+		for _, mask := range []uint64{0, 1, 1 << 63, 0x5555555555555555, rand.Uint64()} {
+			dst := randomBytes(width)
+			expected := make([]byte, width)
+			copy(expected, dst)
+			for i := 0; i < 64; i++ {
+				if mask&(1<<uint(i)) != 0 {
+					safeXORBytes(expected, expected, src[i*stride:i*stride+width])
+				}
+			}
+
+			Rows(dst, src, stride, mask)
+			if !bytes.Equal(dst, expected) {
+				t.Fatalf("Rows(width=%d, mask=%x) is incorrect", width, mask)
+			}
+		}
+	}
+}
+
+func BenchmarkWords(b *testing.B) {
+	a := randomBytes(4096)
+	c := randomBytes(4096)
+	dst := make([]byte, 4096)
+	b.SetBytes(4096)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Words(dst, a, c)
+	}
+}
+
+func BenchmarkRows(b *testing.B) {
+	src := randomBytes(64 * 4096)
+	dst := make([]byte, 4096)
+	b.SetBytes(64 * 4096)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Rows(dst, src, 4096, ^uint64(0))
+	}
+}
@@ -38,7 +38,7 @@ type GetIntVecArgs struct {
 type GetIntVecReply struct {
 	Err        string
 	SnapshotID uint64
-	IntVec     []uint64 // Serialization of bloom filter
+	IntVec     []byte // Interest vector, encoded by bloom.MarshalVector
 }
 
 // CommitArgs contains a set of Writes to be committed
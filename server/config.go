@@ -3,6 +3,7 @@ package server
 import (
 	"encoding/json"
 	"io/ioutil"
+	"sort"
 	"time"
 
 	"github.com/privacylab/talek/common"
@@ -15,16 +16,54 @@ type Config struct {
 
 	// How many read requests should be made of the PIR server at a time?
 	ReadBatch int
+	// Smaller batch sizes that may be used when fewer reads are pending.
+	// Batches are padded to the smallest allowed size fitting the reads
+	// pending in the frontend, so the size depends only on the aggregate load.
+	ReadBatchSizes []int
 	// What's the minimum frequency when pending writes should be applied?
 	WriteInterval time.Duration `json:",string"`
 
 	// What's the minimum frequency when pending reads should be applied?
 	ReadInterval time.Duration `json:",string"`
 
+	// How long may the frontend wait for a replica to answer a call?
+	// common.DefaultCallTimeout when zero.
+	ReplicaTimeout time.Duration `json:",string"`
+
+	// How many write tokens does the frontend issue to each client address
+	// per TokenEpoch? Writes are admitted without tokens when zero.
+	WriteTokens int
+	// How many writes does each token admit? The frontend can link the writes
+	// made with a token. 1 when zero.
+	WritesPerToken int
+	// How long are write tokens issued for? An hour when zero.
+	TokenEpoch time.Duration `json:",string"`
+	// Path of a PEM file holding the RSA key signing write tokens, which
+	// clients pin by its modulus. Its exponent must be 65537. A key is
+	// generated when the frontend starts if empty, which clients can only pin
+	// until the frontend restarts.
+	TokenKeyFile string
+
 	// The trust domain this server is within. Includes keychain for the server.
 	TrustDomain *common.TrustDomainConfig
 	// In client read requests, which index is relevant for this server.
 	TrustDomainIndex int
+	// The public configuration of the frontend. Replicas only accept writes
+	// and reads signed by it, and if served over TLS, only connections
+	// authenticated by its certificate.
+	Frontend *common.TrustDomainConfig
+
+	// Addresses of the shard servers holding the database of a distributed
+	// trust domain, in bucket order. Only used when TrustDomain.IsDistributed.
+	ShardAddresses []string
+
+	// Path of a file holding the PIR database, which may then be larger than
+	// memory. The cuckoo table indexing the file is saved alongside it, at
+	// the path with a ".table" suffix, so items written before a restart
+	// remain readable until they are replaced. Trust domains other than the
+	// first and shards of partial bucket ranges suffix the path with their
+	// index and range. When empty, the database is held in memory.
+	DataFile string
 }
 
 // ConfigFromFile restores a json cofig. returns the config on success or nil if
@@ -41,3 +80,36 @@ func ConfigFromFile(file string, commonBase *common.Config) *Config {
 	config.Config = commonBase
 	return config
 }
+
+// BatchSizes returns the allowed sizes of read batches in increasing order.
+// ReadBatch is the largest allowed size.
+func (c Config) BatchSizes() []int {
+	sizes := []int{c.ReadBatch}
+	for _, size := range c.ReadBatchSizes {
+		if size > 0 && size < c.ReadBatch {
+			sizes = append(sizes, size)
+		}
+	}
+	sort.Ints(sizes)
+	return sizes
+}
+
+// BatchSize returns the smallest allowed batch size holding n reads, or 0 if
+// n exceeds ReadBatch.
+func (c Config) BatchSize(n int) int {
+	for _, size := range c.BatchSizes() {
+		if size >= n {
+			return size
+		}
+	}
+	return 0
+}
+
+// ReplicaCallTimeout returns the ReplicaTimeout of calls from the frontend to
+// replicas, or common.DefaultCallTimeout if it is unset.
+func (c Config) ReplicaCallTimeout() time.Duration {
+	if c.ReplicaTimeout > 0 {
+		return c.ReplicaTimeout
+	}
+	return common.DefaultCallTimeout
+}
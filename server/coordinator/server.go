@@ -35,7 +35,8 @@ type Server struct {
 	numNewCommits uint64
 	snapshotCount uint64
 	lastLayout    []uint64
-	intVec        []uint64
+	interest      *bloom.CountingFilter
+	intVec        []uint64 // Bits of interest as of the last snapshot
 	cuckooData    []byte
 	cuckooTable   *cuckoo.Table
 
@@ -68,12 +69,18 @@ func NewServer(name string, addr string, config common.Config, servers []notify.
 	s.numNewCommits = 0
 	s.snapshotCount = 0
 	s.lastLayout = make([]uint64, config.NumBuckets*config.BucketDepth)
-	s.intVec = buildInterestVector(config.WindowSize(), config.BloomFalsePositive, s.commitLog[:]).Bytes()
+	interest, err := buildInterestVector(&config, s.commitLog[:])
+	if err != nil {
+		s.log.Error.Printf("coordinator.NewServer(%v) error: %v", name, err)
+		return nil, err
+	}
+	s.interest = interest
+	s.intVec = append([]uint64{}, interest.Bits().Bytes()...)
 	s.cuckooData = make([]byte, config.NumBuckets*config.BucketDepth*uint64(coordinator.IDSize))
 
 	// Choose a random seed for the cuckoo table
 	seedBytes := make([]byte, 8)
-	_, err := rand.Read(seedBytes)
+	_, err = rand.Read(seedBytes)
 	if err != nil {
 		s.log.Error.Printf("coordinator.NewServer(%v) error: %v", name, err)
 		return nil, err
@@ -178,8 +185,15 @@ func (s *Server) GetIntVec(args *coordinator.GetIntVecArgs, reply *coordinator.G
 		return nil
 	}
 
+	intVec := bloom.From(s.interest.Bits().Length(), s.intVec)
+	vector, err := bloom.MarshalVector(intVec, s.interest.NumHash(), s.interest.KeyID(), true)
+	if err != nil {
+		reply.Err = err.Error()
+		s.lock.RUnlock()
+		return nil
+	}
 	reply.Err = ""
-	reply.IntVec = s.intVec[:]
+	reply.IntVec = vector
 
 	s.lock.RUnlock()
 	return nil
@@ -197,12 +211,14 @@ func (s *Server) Commit(args *coordinator.CommitArgs, reply *coordinator.CommitR
 	// Garbage Collect old elements
 	for uint64(len(s.commitLog)) >= windowSize {
 		_ = s.cuckooTable.Remove(asCuckooItem(s.config.NumBuckets, s.commitLog[0]))
+		s.interest.RemoveLocations(s.commitLog[0].IntVecLoc)
 		s.commitLog = s.commitLog[1:]
 	}
 
 	// Insert new item
 	s.numNewCommits++
 	s.commitLog = append(s.commitLog, args)
+	s.interest.AddLocations(args.IntVecLoc)
 	ok, _ := s.cuckooTable.Insert(asCuckooItem(s.config.NumBuckets, args))
 	if !ok {
 		s.log.Error.Fatalf("%v.processCommit failed to insert new element", s.name)
@@ -252,8 +268,8 @@ func (s *Server) NotifySnapshot(force bool) bool {
 	s.numNewCommits = 0
 	s.snapshotCount++
 
-	// Construct global interest vector
-	s.intVec = buildInterestVector(s.config.WindowSize(), s.config.BloomFalsePositive, s.commitLog[:]).Bytes()
+	// Update global interest vector with the changes since the last snapshot
+	s.interest.Sync(s.intVec)
 
 	// Copy the layout
 	for i := 0; i < len(s.lastLayout); i++ {
@@ -318,20 +334,21 @@ func asCuckooItem(numBuckets uint64, args *coordinator.CommitArgs) *cuckoo.Item
 }
 
 // buildInterestVector traverses the commitLog and creates a global interest vector
-// representing the elements.
-// Bloom filter stores n elements with fp false positive rate
-func buildInterestVector(n uint64, fp float64, commitLog []*coordinator.CommitArgs) *bloom.BitSet {
-	bits, numHash := bloom.EstimateParameters(n, fp)
-	intVec := bloom.NewBitSet(bits)
+// representing the elements. Commits are then added and removed as they enter
+// and leave the window.
+// The vector has the sizing and hashing of the interest vectors of replicas,
+// so IntVecLoc holds the Locations of a write in a bloom.Filter keyed on the
+// InterestSeed.
+func buildInterestVector(config *common.Config, commitLog []*coordinator.CommitArgs) (*bloom.CountingFilter, error) {
+	intVec, err := bloom.NewCountingFilter(bloom.KeyFromSeed(config.InterestSeed), config.WindowSize(), config.BloomFalsePositive)
+	if err != nil {
+		return nil, err
+	}
 	for _, c := range commitLog {
-		// Truncate interest vectors to first numHash bits
-		if uint64(len(c.IntVecLoc)) > numHash {
-			intVec = bloom.SetLocations(intVec, c.IntVecLoc[:numHash])
-		} else {
-			intVec = bloom.SetLocations(intVec, c.IntVecLoc[:])
-		}
+		// Locations beyond the number of hashes are truncated
+		intVec.AddLocations(c.IntVecLoc)
 	}
-	return intVec
+	return intVec, nil
 }
 
 func sendNotification(log *common.Logger, servers []notify.Interface, snapshotID uint64) {
@@ -102,7 +102,17 @@ func TestBuildInterestVector(t *testing.T) {
 	commits = append(commits, &coordinator.CommitArgs{IntVecLoc: []uint64{25, 27}})
 	commits = append(commits, &coordinator.CommitArgs{IntVecLoc: []uint64{35, 37, 51, 52, 53, 54, 55, 56, 57, 58, 59, 60, 61, 62, 63, 64, 65}})
 	expectedLocs := []uint64{15, 17, 25, 27, 35, 37}
-	intVec := buildInterestVector(1000, 0.01, commits)
+	config := &common.Config{
+		NumBuckets:         1000,
+		BucketDepth:        1,
+		BloomFalsePositive: 0.01,
+		MaxLoadFactor:      1.0,
+	}
+	interest, err := buildInterestVector(config, commits)
+	if err != nil {
+		t.Fatalf("Error building interest vector: %v", err)
+	}
+	intVec := interest.Bits()
 	if !bloom.CheckLocations(intVec, expectedLocs) {
 		t.Errorf("Interest Vector missing some bits")
 	}
@@ -292,7 +302,11 @@ func TestGetIntVecEmpty(t *testing.T) {
 	if reply.Err != "" || reply.SnapshotID != 0 {
 		t.Errorf("GetIntVec should have succeeded: %v", reply)
 	}
-	for _, v := range reply.IntVec {
+	_, intVec, err := bloom.UnmarshalVector(reply.IntVec)
+	if err != nil {
+		t.Fatalf("Error decoding interest vector: %v", err)
+	}
+	for _, v := range intVec.Bytes() {
 		if v != 0 {
 			t.Errorf("GetIntVec should have returned an empty interest vector, %v", reply)
 		}
@@ -383,8 +397,14 @@ func TestSnapshot(t *testing.T) {
 	if intVecReply.Err != "" || intVecReply.SnapshotID != 1 {
 		t.Errorf("GetIntVec error: %v", intVecReply)
 	}
-	numBits, _ := bloom.EstimateParameters(config.WindowSize(), config.BloomFalsePositive)
-	intVec := bloom.From(numBits, intVecReply.IntVec)
+	numBits, numHash := bloom.EstimateParameters(config.WindowSize(), config.BloomFalsePositive)
+	header, intVec, err := bloom.UnmarshalVector(intVecReply.IntVec)
+	if err != nil {
+		t.Fatalf("Error decoding interest vector: %v", err)
+	}
+	if header.NumBits != numBits || header.NumHash != numHash || header.KeyID != bloom.KeyID(bloom.KeyFromSeed(config.InterestSeed)) {
+		t.Errorf("Invalid interest vector header: %v", header)
+	}
 	if !bloom.Equal(intVec, bloom.SetLocations(bloom.NewBitSet(numBits), commit.IntVecLoc)) {
 		t.Errorf("Invalid interest vector. Commit not included")
 	}
@@ -392,6 +412,41 @@ func TestSnapshot(t *testing.T) {
 	afterEach(s, channels)
 }
 
+func TestSnapshotInterestWindow(t *testing.T) {
+	config := testConfig()
+	s, err := NewServer("test", testAddr, config, nil, 100, time.Hour)
+	if err != nil {
+		t.Errorf("Error creating new server")
+	}
+	// Fill the window, then push out the first commit
+	for i := uint64(0); i <= config.WindowSize(); i++ {
+		commit := newCommit()
+		commit.IntVecLoc = []uint64{2 * i, 2*i + 1}
+		if s.Commit(commit, &coordinator.CommitReply{}) != nil {
+			t.Errorf("Error calling Commit: %v", err)
+		}
+	}
+	s.NotifySnapshot(true)
+
+	intVecReply := &coordinator.GetIntVecReply{}
+	if s.GetIntVec(&coordinator.GetIntVecArgs{SnapshotID: 1}, intVecReply) != nil || intVecReply.Err != "" {
+		t.Errorf("GetIntVec error: %v", intVecReply)
+	}
+	_, intVec, err := bloom.UnmarshalVector(intVecReply.IntVec)
+	if err != nil {
+		t.Fatalf("Error decoding interest vector: %v", err)
+	}
+	if bloom.CheckLocations(intVec, []uint64{0}) || bloom.CheckLocations(intVec, []uint64{1}) {
+		t.Errorf("Interest vector holds commit outside of the window")
+	}
+	last := 2 * config.WindowSize()
+	if !bloom.CheckLocations(intVec, []uint64{2, 3, last, last + 1}) {
+		t.Errorf("Interest vector missing commits in the window")
+	}
+
+	afterEach(s, nil)
+}
+
 func TestSnapshotThreshold(t *testing.T) {
 	numServers := 3
 	snapshotThreshold := 32
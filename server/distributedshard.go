@@ -0,0 +1,179 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/pir/xor"
+)
+
+// DistributedShard is the head of a distributed trust domain. It holds no
+// data itself, but splits each batch of request vectors by bucket range,
+// fans them out to the shard servers holding those ranges, and combines
+// their partial responses.
+type DistributedShard struct {
+	log  *common.Logger
+	name string
+
+	config Config
+	shards []common.ShardInterface
+	starts []uint64
+	ends   []uint64
+}
+
+// ShardRange computes the bucket range [start, end) held by shard `index` of
+// `numShards` shards partitioning `numBuckets` buckets. Ranges must start on
+// byte boundaries of request vectors, so numBuckets must be divisible by
+// 8 * numShards.
+func ShardRange(numBuckets uint64, numShards int, index int) (uint64, uint64, error) {
+	if numShards < 1 || index < 0 || index >= numShards {
+		return 0, 0, fmt.Errorf("invalid shard %d of %d", index, numShards)
+	}
+	if numBuckets%(8*uint64(numShards)) != 0 {
+		return 0, 0, fmt.Errorf("numBuckets=%d cannot be split into %d byte-aligned ranges", numBuckets, numShards)
+	}
+	size := numBuckets / uint64(numShards)
+	return uint64(index) * size, uint64(index+1) * size, nil
+}
+
+// NewDistributedShard creates a head over a set of shard servers, given in
+// bucket order.
+func NewDistributedShard(name string, config Config, shards []common.ShardInterface) (*DistributedShard, error) {
+	d := &DistributedShard{}
+	d.log = common.NewLogger(name)
+	d.name = name
+	d.config = config
+	d.shards = shards
+	d.starts = make([]uint64, len(shards))
+	d.ends = make([]uint64, len(shards))
+
+	if len(shards) == 0 {
+		return nil, errors.New("distributed trust domain has no shards")
+	}
+	for i := range shards {
+		start, end, err := ShardRange(config.Config.NumBuckets, len(shards), i)
+		if err != nil {
+			return nil, err
+		}
+		d.starts[i] = start
+		d.ends[i] = end
+	}
+
+	return d, nil
+}
+
+/** PUBLIC METHODS (threadsafe) **/
+
+// Write forwards a write or epoch advance to every shard server, since any
+// of them may end up holding the item.
+func (d *DistributedShard) Write(args *common.ReplicaWriteArgs) error {
+	errs := make([]error, len(d.shards))
+	var wg sync.WaitGroup
+	for i, s := range d.shards {
+		wg.Add(1)
+		go func(i int, s common.ShardInterface) {
+			defer wg.Done()
+			reply := common.ReplicaWriteReply{}
+			errs[i] = s.Write(args, &reply)
+			if errs[i] == nil && reply.Err != "" {
+				errs[i] = errors.New(reply.Err)
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			d.log.Error.Printf("Write to shard %d failed: %v", i, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchRead performs a read of a set of client requests against the shard
+// servers. The combined reply is sent on the ReplyChan of the request.
+func (d *DistributedShard) BatchRead(req *DecodedBatchReadRequest) {
+	go d.batchRead(req)
+}
+
+// GetHint returns nil, as distributed trust domains only serve bit vector requests.
+func (d *DistributedShard) GetHint() ([]byte, uint64) {
+	return nil, 0
+}
+
+// Close is a no-op. Shard servers have their own lifecycle.
+func (d *DistributedShard) Close() {
+	d.log.Info.Printf("Graceful shutdown of distributed shard.")
+}
+
+/** PRIVATE METHODS **/
+
+func (d *DistributedShard) batchRead(req *DecodedBatchReadRequest) {
+	d.log.Trace.Printf("batchRead: enter\n")
+
+	// Split every request before any shard server is called, so a malformed
+	// request fails the batch without leaving calls in flight.
+	shardArgs := make([]*common.ShardReadArgs, len(d.shards))
+	for i := range d.shards {
+		shardArgs[i] = &common.ShardReadArgs{RequestVectors: make([][]byte, len(req.Args))}
+		for j, val := range req.Args {
+			if uint64(len(val.RequestVector)) < d.ends[i]/8 {
+				req.ReplyChan <- &common.BatchReadReply{Err: fmt.Sprintf("Invalid request vector length %d.", len(val.RequestVector))}
+				return
+			}
+			shardArgs[i].RequestVectors[j] = val.RequestVector[d.starts[i]/8 : d.ends[i]/8]
+		}
+	}
+
+	replies := make([]common.BatchReadReply, len(d.shards))
+	errs := make([]error, len(d.shards))
+	var wg sync.WaitGroup
+	for i, s := range d.shards {
+		wg.Add(1)
+		go func(i int, s common.ShardInterface) {
+			defer wg.Done()
+			errs[i] = s.BatchRead(shardArgs[i], &replies[i])
+		}(i, s)
+	}
+	wg.Wait()
+
+	failed := -1
+	for i := range d.shards {
+		if errs[i] != nil || replies[i].Err != "" {
+			d.log.Error.Printf("Read from shard %d failed: %v%v", i, errs[i], replies[i].Err)
+		} else if len(replies[i].Replies) != len(req.Args) {
+			d.log.Error.Printf("Shard %d gave %d replies instead of %d", i, len(replies[i].Replies), len(req.Args))
+		} else {
+			continue
+		}
+		if failed < 0 {
+			failed = i
+		}
+	}
+	if failed >= 0 {
+		req.ReplyChan <- &common.BatchReadReply{Err: fmt.Sprintf("Failed to read from shard %d.", failed)}
+		return
+	}
+
+	// XOR together the partial responses of each shard.
+	response := &common.BatchReadReply{Err: "", Replies: make([]common.ReadReply, len(req.Args))}
+	for i := range d.shards {
+		for j, partial := range replies[i].Replies {
+			if i == 0 {
+				response.Replies[j].Data = partial.Data
+				continue
+			}
+			if len(partial.Data) != len(response.Replies[j].Data) {
+				response.Replies[j].Err = "mismatched shard response lengths"
+				continue
+			}
+			xor.Bytes(response.Replies[j].Data, response.Replies[j].Data, partial.Data)
+		}
+	}
+	req.ReplyChan <- response
+
+	d.log.Trace.Printf("batchRead: exit\n")
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+
+	"github.com/privacylab/talek/common"
+)
+
+func TestShardRange(t *testing.T) {
+	start, end, err := ShardRange(512, 4, 2)
+	if err != nil || start != 256 || end != 384 {
+		t.Fatalf("wrong range [%d, %d) for shard 2 of 4: %v", start, end, err)
+	}
+	if _, _, err = ShardRange(512, 3, 0); err == nil {
+		t.Fatal("512 buckets should not split into 3 byte-aligned ranges")
+	}
+	if _, _, err = ShardRange(512, 4, 4); err == nil {
+		t.Fatal("shard index should be bounded by number of shards")
+	}
+}
+
+func TestDistributedShardMatchesShard(t *testing.T) {
+	conf := testConf()
+	conf.ShardAddresses = []string{"", ""}
+
+	full := NewShard("Test Shard", "cpu.0", conf)
+	remotes := make([]common.ShardInterface, len(conf.ShardAddresses))
+	for i := range remotes {
+		remote, err := NewRemoteShard("Test Remote Shard", "cpu.0", conf, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer remote.Close()
+		remotes[i] = remote
+	}
+	dist, err := NewDistributedShard("Test Distributed Shard", conf, remotes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < 200; i++ {
+		data := make([]byte, conf.Config.DataSize)
+		rand.Read(data)
+		write := &common.ReplicaWriteArgs{
+			WriteArgs: common.WriteArgs{
+				Bucket1:     uint64(rand.Int()) % conf.NumBuckets,
+				Bucket2:     uint64(rand.Int()) % conf.NumBuckets,
+				Data:        data,
+				GlobalSeqNo: uint64(i),
+			},
+		}
+		full.Write(write)
+		if err := dist.Write(write); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The second epoch flag blocks until the first has been applied.
+	for i := 0; i < 2; i++ {
+		full.Write(&common.ReplicaWriteArgs{EpochFlag: true})
+		dist.Write(&common.ReplicaWriteArgs{EpochFlag: true})
+	}
+
+	reqs := make([]common.PirArgs, conf.ReadBatch)
+	for i := range reqs {
+		reqs[i].RequestVector = make([]byte, conf.NumBuckets/8)
+		rand.Read(reqs[i].RequestVector)
+	}
+	fullReplies := make(chan *common.BatchReadReply)
+	distReplies := make(chan *common.BatchReadReply)
+	full.BatchRead(&DecodedBatchReadRequest{Args: reqs, ReplyChan: fullReplies})
+	dist.BatchRead(&DecodedBatchReadRequest{Args: reqs, ReplyChan: distReplies})
+	fullReply := <-fullReplies
+	distReply := <-distReplies
+
+	if distReply.Err != "" {
+		t.Fatalf("distributed read failed: %v", distReply.Err)
+	}
+	for i := range reqs {
+		if !bytes.Equal(fullReply.Replies[i].Data, distReply.Replies[i].Data) {
+			t.Fatalf("distributed read %d differs from a single shard", i)
+		}
+	}
+
+	full.Close()
+	dist.Close()
+}
+
+func TestReplicaDistributedShardFailure(t *testing.T) {
+	conf := testConf()
+	// No shard server listens on port 1.
+	conf.ShardAddresses = []string{"http://127.0.0.1:1", "http://127.0.0.1:1"}
+	conf.TrustDomain = common.NewTrustDomainConfig("Test Distributed", "127.0.0.1:9000", true, true)
+
+	r := NewReplica("Test Replica", "cpu.0", conf)
+	if r == nil {
+		t.Fatal("Failed to create replica.")
+	}
+	defer r.Close()
+
+	reply := &common.BatchReadReply{}
+	args := &common.BatchReadRequest{Args: make([]common.EncodedReadArgs, 1)}
+	if err := r.BatchRead(args, reply); err == nil || reply.Err == "" {
+		t.Fatalf("Read with unreachable shard servers succeeded")
+	}
+
+	write := &common.ReplicaWriteArgs{WriteArgs: common.WriteArgs{GlobalSeqNo: 1}}
+	writeReply := &common.ReplicaWriteReply{}
+	if err := r.Write(write, writeReply); err == nil || writeReply.Err == "" {
+		t.Fatalf("Write with unreachable shard servers succeeded")
+	}
+	if writeReply.GlobalSeqNo != 0 || atomic.LoadUint64(&r.committedSeqNo) != 0 {
+		t.Fatalf("Failed write was committed")
+	}
+}
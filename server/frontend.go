@@ -1,8 +1,8 @@
 package server
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
@@ -12,7 +12,7 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/foobaz/go-zopfli/zopfli"
+	"github.com/privacylab/talek/bloom"
 	"github.com/privacylab/talek/common"
 )
 
@@ -25,11 +25,16 @@ type Frontend struct {
 	*Config
 
 	proposedSeqNo   uint64 // Use atomic.AddUint64, atomic.LoadUint64
+	authSeqNo       uint64 // Sequence number of signed requests to replicas
 	currentInterest *globalInterest
 	readChan        chan *readRequest
 
-	replicas []common.ReplicaInterface
+	replicas []common.ReplicaContextInterface
+	tokens   *tokenIssuer // nil if writes are admitted without tokens
 	dead     int32
+	// ctx ends with Close, abandoning calls to replicas.
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	Verbose bool
 }
@@ -54,8 +59,21 @@ func NewFrontend(name string, config *Config, replicas []common.ReplicaInterface
 	fe.log = log.New(os.Stdout, "[Frontend:"+name+"] ", log.Ldate|log.Ltime|log.Lshortfile)
 	fe.name = name
 	fe.Config = config
-	fe.replicas = replicas
+	fe.replicas = make([]common.ReplicaContextInterface, len(replicas))
+	for i, r := range replicas {
+		fe.replicas[i] = common.ReplicaWithContext(r)
+	}
+	fe.ctx, fe.cancel = context.WithCancel(context.Background())
+	// Replicas reject sequence numbers they have seen, so numbering starts
+	// from the time to keep increasing across restarts.
+	fe.authSeqNo = uint64(time.Now().UnixNano())
 	fe.readChan = make(chan *readRequest, 10)
+	tokens, err := newTokenIssuer(config)
+	if err != nil {
+		fe.log.Printf("Failed to initialize write tokens: %v", err)
+		return nil
+	}
+	fe.tokens = tokens
 	nextInterest := new(globalInterest)
 	fe.currentInterest = nextInterest
 
@@ -72,6 +90,7 @@ func NewFrontend(name string, config *Config, replicas []common.ReplicaInterface
 // Close goroutines associated with this object.
 func (fe *Frontend) Close() {
 	atomic.StoreInt32(&fe.dead, 1)
+	fe.cancel()
 }
 
 // GetName exports the name of the server.
@@ -88,19 +107,36 @@ func (fe *Frontend) GetConfig(args *interface{}, reply *common.Config) error {
 }
 
 func (fe *Frontend) Write(args *common.WriteArgs, reply *common.WriteReply) error {
+	if err := fe.checkBuckets(args); err != nil {
+		reply.Err = err.Error()
+		return nil
+	}
+	if fe.tokens != nil {
+		if err := fe.tokens.spend(args.Token); err != nil {
+			reply.Err = err.Error()
+			reply.TokenRejected = true
+			return nil
+		}
+	}
 	seqNo := atomic.AddUint64(&fe.proposedSeqNo, 1)
 	args.GlobalSeqNo = seqNo
 
 	replicaWrite := &common.ReplicaWriteArgs{
 		WriteArgs: *args,
 	}
+	replicaWrite.Token = nil
+	if td, seq := fe.signer(); td != nil {
+		replicaWrite.Sign(td, seq)
+	}
 	replicaReply := common.ReplicaWriteReply{}
 	if fe.Verbose {
 		fe.log.Printf("write to %d,%d serialized.\n", args.Bucket1, args.Bucket2)
 	}
+	ctx, cancel := fe.replicaContext()
+	defer cancel()
 	//@todo writes in parallel
 	for i, r := range fe.replicas {
-		err := r.Write(replicaWrite, &replicaReply)
+		err := r.WriteContext(ctx, replicaWrite, &replicaReply)
 		if err != nil {
 			reply.Err = err.Error()
 			fe.log.Printf("Error writing to replica %d: %v", i, err)
@@ -113,7 +149,29 @@ func (fe *Frontend) Write(args *common.WriteArgs, reply *common.WriteReply) erro
 	return nil
 }
 
+// checkBuckets rejects writes with more candidate buckets than BucketChoices,
+// or with candidates beyond the buckets of the database.
+func (fe *Frontend) checkBuckets(args *common.WriteArgs) error {
+	config := fe.Config.Config
+	if len(args.Buckets) > config.Choices()-2 {
+		return fmt.Errorf("write has %d candidate buckets, more than %d", len(args.Buckets)+2, config.Choices())
+	}
+	if args.Bucket1 >= config.NumBuckets || args.Bucket2 >= config.NumBuckets {
+		return fmt.Errorf("write to bucket beyond %d", config.NumBuckets)
+	}
+	for _, b := range args.Buckets {
+		if b >= config.NumBuckets {
+			return fmt.Errorf("write to bucket beyond %d", config.NumBuckets)
+		}
+	}
+	return nil
+}
+
 func (fe *Frontend) Read(args *common.EncodedReadArgs, reply *common.ReadReply) error {
+	if args.Version > common.ReadVersionLatest {
+		reply.Err = fmt.Sprintf("unsupported read protocol version %d", args.Version)
+		return nil
+	}
 	ready := make(chan bool, 1)
 	fe.readChan <- &readRequest{Args: args, Reply: reply, Done: ready}
 	<-ready
@@ -121,7 +179,7 @@ func (fe *Frontend) Read(args *common.EncodedReadArgs, reply *common.ReadReply)
 	return nil
 }
 
-// GetUpdates provides the most recent global interest vector deltas.
+// GetUpdates provides the global interest vector of the writes in the window.
 func (fe *Frontend) GetUpdates(args *common.GetUpdatesArgs, reply *common.GetUpdatesReply) error {
 	intr := fe.currentInterest
 	reply.InterestVector = intr.CompressedVector
@@ -129,6 +187,57 @@ func (fe *Frontend) GetUpdates(args *common.GetUpdatesArgs, reply *common.GetUpd
 	return nil
 }
 
+// GetTokenKey describes the write tokens of the current epoch.
+func (fe *Frontend) GetTokenKey(args *common.GetTokenKeyArgs, reply *common.GetTokenKeyReply) error {
+	if fe.tokens == nil {
+		// Writes are admitted without tokens. Clients ask again after an epoch.
+		reply.Expires = time.Now().Add(defaultTokenEpoch)
+		return nil
+	}
+	fe.tokens.describe(reply)
+	return nil
+}
+
+// IssueTokensFor blind signs write tokens for a requester, which is limited to
+// WriteTokens tokens each epoch. Requesters are identified by the transport,
+// as by the network address of clients of the FrontendServer.
+func (fe *Frontend) IssueTokensFor(requester string, args *common.IssueTokensArgs, reply *common.IssueTokensReply) error {
+	if fe.tokens == nil {
+		reply.Err = "write tokens are not required"
+		return nil
+	}
+	fe.tokens.issue(requester, args, reply)
+	return nil
+}
+
+// GetHint provides the hint of the current database, used to decode responses
+// to EncodingLWE reads. It is only available with a single trust domain.
+func (fe *Frontend) GetHint(args *common.GetHintArgs, reply *common.GetHintReply) error {
+	if len(fe.replicas) != 1 {
+		reply.Err = "Hints require a single trust domain"
+		return nil
+	}
+	ctx, cancel := fe.replicaContext()
+	defer cancel()
+	return fe.replicas[0].GetHintContext(ctx, args, reply)
+}
+
+// signer returns the trust domain of the frontend and the next sequence number
+// if requests to replicas are signed, as they are when the configuration holds
+// the private key of the trust domain.
+func (fe *Frontend) signer() (*common.TrustDomainConfig, uint64) {
+	td := fe.Config.TrustDomain
+	if td == nil || !td.CanSign() {
+		return nil, 0
+	}
+	return td, atomic.AddUint64(&fe.authSeqNo, 1)
+}
+
+// replicaContext bounds a call to replicas by the ReplicaTimeout of the config.
+func (fe *Frontend) replicaContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(fe.ctx, fe.Config.ReplicaCallTimeout())
+}
+
 // periodicWrite runs until the dead flag is set, and periodically send a write
 // request to all replicas telling them to advance their write epoch.
 func (fe *Frontend) periodicWrite() {
@@ -139,13 +248,20 @@ func (fe *Frontend) periodicWrite() {
 			args := &common.ReplicaWriteArgs{
 				EpochFlag: true,
 			}
+			if td, seq := fe.signer(); td != nil {
+				args.Sign(td, seq)
+			}
 			var rep common.ReplicaWriteReply
 			if fe.Verbose {
 				fe.log.Printf("Periodic update of database sent to replicas.\n")
 			}
-			for _, r := range fe.replicas {
-				r.Write(args, &rep)
+			ctx, cancel := fe.replicaContext()
+			for i, r := range fe.replicas {
+				if err := r.WriteContext(ctx, args, &rep); err != nil {
+					fe.log.Printf("Error advancing epoch of replica %d: %v", i, err)
+				}
 			}
+			cancel()
 		}
 	}
 }
@@ -159,13 +275,20 @@ func (fe *Frontend) periodicUpdate() {
 			args := &common.ReplicaWriteArgs{
 				InterestFlag: true,
 			}
+			if td, seq := fe.signer(); td != nil {
+				args.Sign(td, seq)
+			}
 			resp := make([]common.ReplicaWriteReply, len(fe.replicas))
 			if fe.Verbose {
 				fe.log.Printf("Periodic update of global interest vector to replicas.\n")
 			}
+			ctx, cancel := fe.replicaContext()
 			for i, r := range fe.replicas {
-				r.Write(args, &resp[i])
+				if err := r.WriteContext(ctx, args, &resp[i]); err != nil {
+					fe.log.Printf("Error requesting interest vector of replica %d: %v", i, err)
+				}
 			}
+			cancel()
 			go fe.generateInterestVector(resp)
 		}
 	}
@@ -187,20 +310,12 @@ func (fe *Frontend) generateInterestVector(partials []common.ReplicaWriteReply)
 		copy(nextInterest.Signatures[i][:], v.Signature[:])
 	}
 
-	// Compress the vector
-	var b bytes.Buffer
-	writer := bufio.NewWriter(&b)
-	opts := zopfli.DefaultOptions()
-	err := zopfli.Compress(
-		&opts,
-		zopfli.FORMAT_DEFLATE,
-		partials[0].InterestVec,
-		writer)
-	if err != nil {
-		fe.log.Printf("Failed to update interest vector delta: %v", err)
+	// Replicas compress the vector
+	compressed := partials[0].InterestVec
+	if _, err := bloom.ParseVectorHeader(compressed); err != nil {
+		fe.log.Printf("Failed to update interest vector: %v", err)
 		return
 	}
-	compressed := b.Bytes()
 	sum := sha256.Sum256(compressed)
 
 	nextInterest.ID = binary.LittleEndian.Uint64(sum[0:8])
@@ -239,15 +354,21 @@ func (fe *Frontend) batchReads() {
 
 func (fe *Frontend) triggerBatchRead(batch []*readRequest) error {
 	args := &common.BatchReadRequest{}
-	// Copy args
-	args.Args = make([]common.EncodedReadArgs, len(batch), len(batch))
+	// Copy args, padded to the smallest allowed batch size. Empty args are
+	// served as pad requests by the replicas.
+	batchSize := fe.Config.BatchSize(len(batch))
+	if batchSize < len(batch) {
+		// Left for replicas to reject.
+		batchSize = len(batch)
+	}
+	args.Args = make([]common.EncodedReadArgs, batchSize)
 	for i, val := range batch {
 		if val.Args != nil {
 			args.Args[i] = *val.Args
 		}
 	}
 	if fe.Verbose {
-		fe.log.Printf("Batch read with %d items sent to replicas.\n", len(batch))
+		fe.log.Printf("Batch read with %d items in a batch of %d sent to replicas.\n", len(batch), len(args.Args))
 	}
 
 	// Choose a SeqNoRange
@@ -259,21 +380,29 @@ func (fe *Frontend) triggerBatchRead(batch []*readRequest) error {
 	}
 	args.SeqNoRange.End = currSeqNo // Exclusive
 	args.SeqNoRange.Aborted = make([]uint64, 0, 0)
+	if td, seq := fe.signer(); td != nil {
+		args.Sign(td, seq)
+	}
 
 	// Start computation
 	// @todo reads in parallel
 	var replicaErr error
 	replies := make([]common.BatchReadReply, len(fe.replicas))
+	ctx, cancel := fe.replicaContext()
+	defer cancel()
 	for i, r := range fe.replicas {
-		err := r.BatchRead(args, &replies[i])
-		if err != nil || replies[i].Err != "" {
+		err := r.BatchReadContext(ctx, args, &replies[i])
+		if err == nil && replies[i].Err != "" {
+			err = errors.New(replies[i].Err)
+		}
+		if err != nil {
 			replicaErr = err
 			fe.log.Printf("Error making read to replica %d: %v%v", i, err, replies[i].Err)
 			break
 		}
-		if len(replies[i].Replies) != len(batch) {
+		if len(replies[i].Replies) != len(args.Args) {
 			replicaErr = errors.New("failure from Replica " + fmt.Sprintf("%d", i))
-			fe.log.Printf("Replica %d gave the wrong number of replies (%d instead of %d)", i, len(replies[i].Replies), len(batch))
+			fe.log.Printf("Replica %d gave the wrong number of replies (%d instead of %d)", i, len(replies[i].Replies), len(args.Args))
 			break
 		}
 	}
@@ -286,18 +415,39 @@ func (fe *Frontend) triggerBatchRead(batch []*readRequest) error {
 		if replicaErr != nil {
 			val.Reply.Err = replicaErr.Error()
 			val.Done <- true
-			break
+			continue
+		}
+		// A replica failing this read alone, such as for a malformed
+		// request, invalidates its response.
+		if err := readError(replies, i); err != "" {
+			val.Reply.Err = err
+			val.Done <- true
+			continue
 		}
 
-		replyLength := len(replies[i].Replies[i].Data)
+		replyLength := len(replies[0].Replies[i].Data)
 		val.Reply.Data = make([]byte, replyLength)
 		for _, rp := range replies {
 			val.Reply.Combine(rp.Replies[i].Data)
 		}
 		val.Reply.GlobalSeqNo = args.SeqNoRange
 		val.Reply.LastInterestSN = lastInterestSN
+		// Epochs of the database match hints, which need a single trust domain.
+		if len(replies) == 1 {
+			val.Reply.Epoch = replies[0].Replies[i].Epoch
+		}
 		val.Done <- true
 	}
 
 	return nil
 }
+
+// readError is the error of any replica for read i of a batch.
+func readError(replies []common.BatchReadReply, i int) string {
+	for _, rp := range replies {
+		if rp.Replies[i].Err != "" {
+			return rp.Replies[i].Err
+		}
+	}
+	return ""
+}
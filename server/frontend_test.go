@@ -1,7 +1,11 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -30,10 +34,30 @@ func (m *mockReplica) BatchRead(args *common.BatchReadRequest, reply *common.Bat
 func (m *mockReplica) GetUpdates(args *common.GetUpdatesArgs, reply *common.GetUpdatesReply) error {
 	return nil
 }
+func (m *mockReplica) GetHint(args *common.GetHintArgs, reply *common.GetHintReply) error {
+	return nil
+}
+
+// hungReplica never answers reads.
+type hungReplica struct {
+	mockReplica
+}
+
+func (h *hungReplica) WriteContext(_ context.Context, args *common.ReplicaWriteArgs, reply *common.ReplicaWriteReply) error {
+	return h.Write(args, reply)
+}
+func (h *hungReplica) BatchReadContext(ctx context.Context, args *common.BatchReadRequest, reply *common.BatchReadReply) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+func (h *hungReplica) GetHintContext(_ context.Context, args *common.GetHintArgs, reply *common.GetHintReply) error {
+	return h.GetHint(args, reply)
+}
 
 func TestFrontendWrite(t *testing.T) {
 	back := new(mockReplica)
 	serverConfig := &Config{
+		Config:        &common.Config{NumBuckets: 8, BucketChoices: 3},
 		WriteInterval: time.Millisecond * 100,
 		ReadInterval:  time.Minute,
 	}
@@ -55,6 +79,19 @@ func TestFrontendWrite(t *testing.T) {
 		t.Fatalf("replica should have been written to (%d calls)", len(back.calls))
 	}
 
+	invalid := []*common.WriteArgs{
+		{Bucket1: 8},
+		{Bucket2: 8},
+		{Buckets: []uint64{8}},
+		{Buckets: []uint64{1, 2}},
+	}
+	for _, args := range invalid {
+		reply := &common.WriteReply{}
+		if err := f.Write(args, reply); err != nil || reply.Err == "" {
+			t.Errorf("write to buckets %d,%d,%v was accepted", args.Bucket1, args.Bucket2, args.Buckets)
+		}
+	}
+
 	time.Sleep(time.Millisecond * 150)
 
 	if len(back.calls) == l {
@@ -90,3 +127,204 @@ func TestFrontendRead(t *testing.T) {
 
 	f.Close()
 }
+
+// rejectingReplica fails the reads of a client key, as replicas fail reads
+// with malformed requests.
+type rejectingReplica struct {
+	mockReplica
+	rejected byte
+}
+
+func (r *rejectingReplica) BatchRead(args *common.BatchReadRequest, reply *common.BatchReadReply) error {
+	reply.Replies = make([]common.ReadReply, len(args.Args))
+	for i, a := range args.Args {
+		if a.ClientKey[0] == r.rejected {
+			reply.Replies[i].Err = "malformed read"
+		} else {
+			reply.Replies[i].Data = []byte{1}
+		}
+	}
+	return nil
+}
+
+func TestFrontendReadError(t *testing.T) {
+	serverConfig := &Config{
+		Config:        &common.Config{},
+		ReadBatch:     2,
+		ReadInterval:  time.Millisecond * 100,
+		WriteInterval: time.Minute,
+	}
+	replicas := []common.ReplicaInterface{&rejectingReplica{rejected: 1}, &rejectingReplica{rejected: 2}}
+	f := NewFrontend("testing", serverConfig, replicas)
+	defer f.Close()
+
+	replies := make([]common.ReadReply, 3)
+	var wg sync.WaitGroup
+	for i := range replies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			args := &common.EncodedReadArgs{}
+			args.ClientKey[0] = byte(i)
+			f.Read(args, &replies[i])
+		}(i)
+		if i == 1 {
+			wg.Wait()
+		}
+	}
+	wg.Wait()
+
+	if replies[0].Err != "" || !bytes.Equal(replies[0].Data, []byte{0}) {
+		t.Errorf("read failed by no replica was not combined: %v %q", replies[0].Data, replies[0].Err)
+	}
+	for _, reply := range replies[1:] {
+		if reply.Err == "" || reply.Data != nil {
+			t.Errorf("read failed by a replica was answered with %v", reply.Data)
+		}
+	}
+}
+
+func TestFrontendReplicaTimeout(t *testing.T) {
+	serverConfig := &Config{
+		Config:         &common.Config{},
+		ReadBatch:      1,
+		ReadInterval:   time.Millisecond * 10,
+		WriteInterval:  time.Minute,
+		ReplicaTimeout: time.Millisecond * 50,
+	}
+
+	f := NewFrontend("testing", serverConfig, []common.ReplicaInterface{new(hungReplica)})
+	defer f.Close()
+
+	done := make(chan *common.ReadReply)
+	go func() {
+		reply := &common.ReadReply{}
+		f.Read(&common.EncodedReadArgs{}, reply)
+		done <- reply
+	}()
+
+	select {
+	case reply := <-done:
+		if reply.Err == "" {
+			t.Errorf("read from hung replica succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("read from hung replica not abandoned")
+	}
+}
+
+func TestFrontendWriteTokens(t *testing.T) {
+	back := new(mockReplica)
+	serverConfig := &Config{
+		Config:         &common.Config{NumBuckets: 8},
+		WriteInterval:  time.Minute,
+		ReadInterval:   time.Minute,
+		WriteTokens:    2,
+		WritesPerToken: 1,
+		TokenEpoch:     time.Hour,
+	}
+	f := NewFrontend("testing", serverConfig, []common.ReplicaInterface{back})
+	defer f.Close()
+	now := time.Now()
+	f.tokens.now = func() time.Time { return now }
+
+	keyReply := &common.GetTokenKeyReply{}
+	if err := f.GetTokenKey(&common.GetTokenKeyArgs{}, keyReply); err != nil || keyReply.Err != "" {
+		t.Fatalf("Failed to get token key: %v %s", err, keyReply.Err)
+	}
+	if keyReply.Tokens != 2 || !keyReply.Expires.After(now) {
+		t.Fatalf("Unexpected token key reply: %v", keyReply)
+	}
+
+	issue := func(requester string, n int) []*common.WriteToken {
+		args := &common.IssueTokensArgs{Epoch: keyReply.Epoch}
+		blinded := make([]*common.BlindedToken, n)
+		for i := range blinded {
+			blinded[i], _ = common.BlindToken(keyReply.PublicKey(), keyReply.Epoch, rand.Reader)
+			args.Blinded = append(args.Blinded, blinded[i].Blinded)
+		}
+		reply := &common.IssueTokensReply{}
+		f.IssueTokensFor(requester, args, reply)
+		tokens := make([]*common.WriteToken, len(reply.Signatures))
+		for i, sig := range reply.Signatures {
+			var err error
+			if tokens[i], err = blinded[i].Unblind(sig); err != nil {
+				t.Fatalf("Failed to unblind issued token: %v", err)
+			}
+		}
+		return tokens
+	}
+	tokens := issue("client", 3)
+	if len(tokens) != 2 {
+		t.Fatalf("Issued %d tokens, expected 2", len(tokens))
+	}
+	if len(issue("client", 1)) != 0 {
+		t.Errorf("Issued tokens beyond the allowance of the epoch")
+	}
+	if len(issue("other", 1)) != 1 {
+		t.Errorf("Allowance of a client limited another")
+	}
+
+	write := func(token *common.WriteToken) string {
+		reply := &common.WriteReply{}
+		if err := f.Write(&common.WriteArgs{Token: token}, reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply.Err
+	}
+	if write(nil) == "" {
+		t.Errorf("Write without token was admitted")
+	}
+	if e := write(tokens[0]); e != "" {
+		t.Errorf("Write with token was rejected: %s", e)
+	}
+	if write(tokens[0]) == "" {
+		t.Errorf("Token was admitted beyond WritesPerToken")
+	}
+
+	// Tokens are admitted in the following epoch, but not after.
+	now = now.Add(time.Hour)
+	if e := write(tokens[1]); e != "" {
+		t.Errorf("Token of the previous epoch was rejected: %s", e)
+	}
+	tokens = issue("client", 1)
+	if len(tokens) != 0 {
+		t.Errorf("Tokens were issued for an old epoch")
+	}
+	keyReply = &common.GetTokenKeyReply{}
+	f.GetTokenKey(&common.GetTokenKeyArgs{}, keyReply)
+	tokens = issue("client", 1)
+	if len(tokens) != 1 {
+		t.Fatalf("Allowance was not renewed in the new epoch")
+	}
+	now = now.Add(2 * time.Hour)
+	if write(tokens[0]) == "" {
+		t.Errorf("Expired token was admitted")
+	}
+}
+
+func TestFrontendServerReplicas(t *testing.T) {
+	serverConfig := &Config{
+		Config:        &common.Config{},
+		WriteInterval: time.Minute,
+		ReadInterval:  time.Minute,
+	}
+	valid := common.NewTrustDomainConfig("valid", "http://localhost:9000", true, false)
+	invalid := common.NewTrustDomainConfig("invalid", "http://localhost:9001", false, false)
+	untrusted := common.NewTrustDomainConfig("untrusted", "https://localhost:9002", true, false)
+	untrusted.Certificate = nil
+
+	if f := NewFrontendServer("testing", serverConfig, []*common.TrustDomainConfig{valid, invalid}); f != nil {
+		f.Frontend.Close()
+		t.Errorf("frontend created with a replica without address")
+	}
+	if f := NewFrontendServer("testing", serverConfig, []*common.TrustDomainConfig{valid, untrusted}); f != nil {
+		f.Frontend.Close()
+		t.Errorf("frontend created with a replica without TLS certificate")
+	}
+	f := NewFrontendServer("testing", serverConfig, []*common.TrustDomainConfig{valid})
+	if f == nil {
+		t.Fatalf("failed to create frontend")
+	}
+	f.Frontend.Close()
+}
@@ -1,13 +1,13 @@
 package server
 
 import (
+	"crypto/tls"
 	"log"
 	"net"
 	"net/http"
 	"os"
 
 	"github.com/gorilla/rpc"
-	"github.com/gorilla/rpc/json"
 	"github.com/privacylab/talek/common"
 )
 
@@ -21,39 +21,83 @@ type FrontendServer struct {
 }
 
 // NewFrontendServer creates a new Frontend implementing HTTP.Handler.
+// It returns nil if any of the replicas can't be reached.
 func NewFrontendServer(name string, serverConfig *Config, replicas []*common.TrustDomainConfig) *FrontendServer {
 	fe := &FrontendServer{}
 	fe.log = log.New(os.Stdout, "[FrontendServer:"+name+"] ", log.Ldate|log.Ltime|log.Lshortfile)
 	fe.name = name
 
+	// Calls to replicas without TLS share a pool of connections. Replicas
+	// reached over TLS each pin their own certificate, and authenticate the
+	// frontend by the certificate of its trust domain.
+	transport := common.NewTransport(serverConfig.ReplicaCallTimeout())
 	rpcs := make([]common.ReplicaInterface, len(replicas))
 	for i, r := range replicas {
-		rpcs[i] = common.NewReplicaRPC(r.Name, r)
+		stub := common.NewReplicaRPC(r.Name, r)
+		if stub == nil {
+			fe.log.Printf("Replica %s has no address", r.Name)
+			return nil
+		}
+		if r.UsesTLS() {
+			tlsConfig, err := r.ClientTLSConfig(serverConfig.TrustDomain)
+			if err != nil {
+				fe.log.Printf("Cannot connect to replica %s over TLS: %v", r.Name, err)
+				return nil
+			}
+			stub.SetTransport(common.NewTLSTransport(serverConfig.ReplicaCallTimeout(), tlsConfig))
+		} else {
+			stub.SetTransport(transport)
+		}
+		rpcs[i] = stub
 	}
 
 	fe.Frontend = NewFrontend(name, serverConfig, rpcs)
+	if fe.Frontend == nil {
+		return nil
+	}
 
 	// Set up the RPC server component.
 	fe.Server = rpc.NewServer()
-	fe.Server.RegisterCodec(&json.Codec{}, "application/json")
+	common.RegisterCodecs(fe.Server)
 	fe.Server.RegisterTCPService(fe.Frontend, "Frontend")
+	fe.Server.RegisterService(&tokenService{fe.Frontend}, "Issuer")
 
 	return fe
 }
 
-// Run begins an HTTP server for the server at a specific address
+// tokenService issues write tokens to clients, identified by their address.
+type tokenService struct {
+	fe *Frontend
+}
+
+// IssueTokens blind signs write tokens for the client of the request.
+func (s *tokenService) IssueTokens(r *http.Request, args *common.IssueTokensArgs, reply *common.IssueTokensReply) error {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return s.fe.IssueTokensFor(host, args, reply)
+}
+
+// Run begins an HTTP server for the server at a specific address. It serves
+// HTTPS if the address of the trust domain of the frontend is https.
 func (fe *FrontendServer) Run(address string) (net.Listener, error) {
 	if fe.Server == nil {
 		fe.Server = rpc.NewServer()
-		fe.Server.RegisterCodec(json.NewCodec(), "application/json")
+		common.RegisterCodecs(fe.Server)
 		fe.Server.RegisterTCPService(fe.Frontend, "Frontend")
+		fe.Server.RegisterService(&tokenService{fe.Frontend}, "Issuer")
 	}
 
-	bindAddr, err := net.ResolveTCPAddr("tcp4", address)
-	if err != nil {
-		return nil, err
+	var tlsConfig *tls.Config
+	if td := fe.Frontend.Config.TrustDomain; td != nil && td.UsesTLS() {
+		var err error
+		if tlsConfig, err = td.ServerTLSConfig(); err != nil {
+			return nil, err
+		}
 	}
-	listener, err := net.ListenTCP("tcp4", bindAddr)
+
+	listener, err := listen(address, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
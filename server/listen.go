@@ -0,0 +1,23 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// listen opens a TCP listener at address, accepting TLS connections if
+// tlsConfig is not nil.
+func listen(address string, tlsConfig *tls.Config) (net.Listener, error) {
+	bindAddr, err := net.ResolveTCPAddr("tcp4", address)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.ListenTCP("tcp4", bindAddr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		return tls.NewListener(listener, tlsConfig), nil
+	}
+	return listener, nil
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"sync"
+)
+
+// replayWindowSize is how far behind the highest accepted sequence number a
+// request may arrive, as concurrent requests of the frontend are reordered.
+const replayWindowSize = 1024
+
+// replayWindow accepts each sequence number once, remembering the numbers in
+// the window below the highest one accepted. Older numbers are rejected.
+type replayWindow struct {
+	lock    sync.Mutex
+	highest uint64
+	seen    [replayWindowSize / 64]uint64 // Bit of seq % replayWindowSize
+}
+
+// accept records seq, returning false if it was seen or is too old.
+func (w *replayWindow) accept(seq uint64) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if seq > w.highest {
+		// Forget the numbers leaving the window.
+		if seq-w.highest >= replayWindowSize {
+			w.seen = [replayWindowSize / 64]uint64{}
+		} else {
+			for s := w.highest + 1; s < seq; s++ {
+				w.clear(s)
+			}
+		}
+		w.highest = seq
+		w.set(seq)
+		return true
+	}
+	if w.highest-seq >= replayWindowSize || w.isSet(seq) {
+		return false
+	}
+	w.set(seq)
+	return true
+}
+
+func (w *replayWindow) set(seq uint64) {
+	i := seq % replayWindowSize
+	w.seen[i/64] |= 1 << (i % 64)
+}
+
+func (w *replayWindow) clear(seq uint64) {
+	i := seq % replayWindowSize
+	w.seen[i/64] &^= 1 << (i % 64)
+}
+
+func (w *replayWindow) isSet(seq uint64) bool {
+	i := seq % replayWindowSize
+	return w.seen[i/64]&(1<<(i%64)) != 0
+}
@@ -1,13 +1,15 @@
 package server
 
 import (
-	"math"
-	"math/rand"
+	"errors"
+	"fmt"
+	"sync"
 	"sync/atomic"
 
+	"github.com/privacylab/talek/bloom"
 	"github.com/privacylab/talek/common"
 	"github.com/privacylab/talek/drbg"
-	"github.com/willscott/bloom"
+	"github.com/privacylab/talek/pir/dpf"
 	"golang.org/x/net/trace"
 )
 
@@ -21,9 +23,17 @@ type Replica struct {
 
 	// Thread-safe
 	config         atomic.Value //Config
-	shard          *Shard
+	shard          replicaShard
 	committedSeqNo uint64 // Use atomic.AddUint64, atomic.LoadUint64
-	interestVector *bloom.Filter
+
+	// Interest vector of the writes in the window, and their locations in
+	// order of writing, to remove them as they leave the window.
+	interestLock   sync.Mutex
+	interestVector *bloom.CountingFilter
+	interestWindow [][]uint64
+
+	// Sequence numbers of the requests accepted from the frontend.
+	replays replayWindow
 
 	// Channels
 	ReadBatch []*common.ReadRequest
@@ -32,15 +42,22 @@ type Replica struct {
 	closeChan chan int
 }
 
+// replicaShard is the component holding the database of a Replica. It is
+// either a local Shard, or a DistributedShard for distributed trust domains.
+type replicaShard interface {
+	Write(args *common.ReplicaWriteArgs) error
+	BatchRead(args *DecodedBatchReadRequest)
+	GetHint() ([]byte, uint64)
+	Close()
+}
+
 // NewReplica creates a new Replica server.
 func NewReplica(name string, backing string, config Config) *Replica {
 	r := &Replica{}
 	r.log = common.NewLogger(name)
 	r.name = name
 
-	bfSize := math.Ceil(math.Log2(float64(config.NumBuckets)))
-	rand := rand.New(rand.NewSource(config.InterestSeed))
-	iv, err := bloom.New(rand, int(bfSize), config.BloomFalsePositive)
+	iv, err := bloom.NewCountingFilter(bloom.KeyFromSeed(config.InterestSeed), config.WindowSize(), config.BloomFalsePositive)
 	if err != nil {
 		r.log.Error.Printf("Failed to initialize interest vector: %v", err)
 		return nil
@@ -49,7 +66,28 @@ func NewReplica(name string, backing string, config Config) *Replica {
 
 	r.config.Store(config)
 
-	r.shard = NewShard(name, backing, config)
+	if config.TrustDomain != nil && config.TrustDomain.IsDistributed {
+		if config.RequestEncoding == common.EncodingLWE {
+			r.log.Error.Printf("Distributed trust domains do not support %s requests", config.RequestEncoding)
+			return nil
+		}
+		if config.StashBuckets > 0 {
+			r.log.Error.Printf("Distributed trust domains do not support a stash")
+			return nil
+		}
+		shards := make([]common.ShardInterface, len(config.ShardAddresses))
+		for i, addr := range config.ShardAddresses {
+			shards[i] = common.NewShardRPC(fmt.Sprintf("%s-%d", name, i), addr)
+		}
+		shard, err := NewDistributedShard(name, config, shards)
+		if err != nil {
+			r.log.Error.Printf("Failed to initialize distributed trust domain: %v", err)
+			return nil
+		}
+		r.shard = shard
+	} else {
+		r.shard = NewShard(name, backing, config)
+	}
 
 	return r
 }
@@ -68,16 +106,33 @@ func (r *Replica) Write(args *common.ReplicaWriteArgs, reply *common.ReplicaWrit
 	tr := trace.New("replica.write", "Write")
 	defer tr.Finish()
 
+	if err := r.authenticate(args.Auth.Sequence, args.Verify); err != nil {
+		reply.Err = err.Error()
+		r.log.Warn.Printf("Write: %v", err)
+		return err
+	}
+
 	// update new global interest vector.
 	if args.InterestFlag {
-		reply.InterestVec = r.interestVector.Delta()
+		r.interestLock.Lock()
+		vector, err := r.interestVector.MarshalVector(true)
+		r.interestLock.Unlock()
+		if err != nil {
+			r.log.Error.Printf("Failed to encode interest vector: %v", err)
+			return err
+		}
+		reply.InterestVec = vector
 		// TODO: sign
 		r.log.Trace.Println("Write-GlobalInterest epoch exit")
 		return nil
 	}
 
-	r.shard.Write(args)
-	r.interestVector.TestAndSet(args.InterestVector)
+	if err := r.shard.Write(args); err != nil {
+		reply.Err = err.Error()
+		r.log.Error.Printf("Write: %v", err)
+		return err
+	}
+	r.addInterest(args.InterestVector)
 
 	atomic.StoreUint64(&r.committedSeqNo, args.GlobalSeqNo)
 	reply.GlobalSeqNo = args.GlobalSeqNo
@@ -85,23 +140,84 @@ func (r *Replica) Write(args *common.ReplicaWriteArgs, reply *common.ReplicaWrit
 	return nil
 }
 
+// addInterest adds a write to the interest vector, removing the oldest writes
+// once the window is full. Writes without an interest vector hold an empty
+// place in the window, so that it counts the same writes as the shard and the
+// commit log of the coordinator.
+func (r *Replica) addInterest(interest []byte) {
+	windowSize := r.config.Load().(Config).WindowSize()
+	r.interestLock.Lock()
+	defer r.interestLock.Unlock()
+	for uint64(len(r.interestWindow)) >= windowSize && len(r.interestWindow) > 0 {
+		if r.interestWindow[0] != nil {
+			r.interestVector.RemoveLocations(r.interestWindow[0])
+		}
+		r.interestWindow = r.interestWindow[1:]
+	}
+	var locations []uint64
+	if len(interest) > 0 {
+		locations = r.interestVector.Add(interest)
+	}
+	r.interestWindow = append(r.interestWindow, locations)
+}
+
+// authenticate checks that a request with sequence number seq was signed by the
+// Frontend of the config, and is not a replay. Without a Frontend, requests
+// are accepted from anyone.
+func (r *Replica) authenticate(seq uint64, verify func(*common.TrustDomainConfig) bool) error {
+	frontend := r.config.Load().(Config).Frontend
+	if frontend == nil {
+		return nil
+	}
+	if !verify(frontend) {
+		return errors.New("request not signed by the frontend")
+	}
+	if !r.replays.accept(seq) {
+		return fmt.Errorf("replayed request %d of the frontend", seq)
+	}
+	return nil
+}
+
 // BatchRead performs a set of reads against the talek database at one logical point in time.
 // BatchRead is replicated to followers with a batching determined by the leader.
 func (r *Replica) BatchRead(args *common.BatchReadRequest, reply *common.BatchReadReply) error {
 	r.log.Trace.Println("BatchRead: enter")
 	tr := trace.New("replica.batchread", "BatchRead")
 	defer tr.Finish()
+
+	if err := r.authenticate(args.Auth.Sequence, args.Verify); err != nil {
+		reply.Err = err.Error()
+		r.log.Warn.Printf("BatchRead: %v", err)
+		return err
+	}
 	// Start local computation
 	config := r.config.Load().(Config)
 
 	localArgs := new(DecodedBatchReadRequest)
 	localArgs.ReplyChan = make(chan *common.BatchReadReply)
-	localArgs.Args = make([]common.PirArgs, config.ReadBatch)
-	for i, val := range args.Args {
+	batchSize := config.BatchSize(len(args.Args))
+	if batchSize == 0 {
+		err := fmt.Errorf("batch of %d reads exceeds ReadBatch", len(args.Args))
+		reply.Err = err.Error()
+		r.log.Error.Println(reply.Err)
+		return err
+	}
+	// Pad to the smallest allowed size, as the PIR server rejects others.
+	localArgs.Args = make([]common.PirArgs, batchSize)
+	// Requests that fail to expand are read as pads, failing only their reply.
+	readErrs := make([]string, len(args.Args))
+	vectorLength := (config.NumBuckets + 7) / 8
+	for i := range localArgs.Args {
+		if i >= len(args.Args) {
+			localArgs.Args[i].PadSeed = make([]byte, drbg.SeedLength)
+			localArgs.Args[i].RequestVector = make([]byte, vectorLength)
+			continue
+		}
+		val := args.Args[i]
 		//Handle pad requests.
 		if len(val.PirArgs) == 0 {
 			localArgs.Args[i].PadSeed = make([]byte, drbg.SeedLength)
-			localArgs.Args[i].RequestVector = make([]byte, config.NumBuckets/8)
+			localArgs.Args[i].RequestVector = make([]byte, vectorLength)
 			continue
 		}
 		pir, err := val.Decode(config.TrustDomainIndex, config.TrustDomain)
@@ -110,6 +226,27 @@ func (r *Replica) BatchRead(args *common.BatchReadRequest, reply *common.BatchRe
 			r.log.Error.Fatalf("Failed to decode part of batch read %v [at index %d]", err, i)
 			return err
 		}
+		if len(pir.DPFKey) > 0 {
+			pir.RequestVector, err = dpf.Expand(pir.DPFKey, config.NumBuckets)
+			if err != nil {
+				readErrs[i] = err.Error()
+				r.log.Warn.Printf("Failed to expand DPF key of batch read %v [at index %d]", err, i)
+				localArgs.Args[i].PadSeed = make([]byte, drbg.SeedLength)
+				localArgs.Args[i].RequestVector = make([]byte, vectorLength)
+				continue
+			}
+			pir.DPFKey = nil
+		} else if len(pir.RequestSeed) > 0 {
+			pir.RequestVector = make([]byte, vectorLength)
+			if err = drbg.Overlay(pir.RequestSeed, pir.RequestVector); err != nil {
+				readErrs[i] = err.Error()
+				r.log.Warn.Printf("Failed to expand request seed of batch read %v [at index %d]", err, i)
+				localArgs.Args[i].PadSeed = make([]byte, drbg.SeedLength)
+				localArgs.Args[i].RequestVector = make([]byte, vectorLength)
+				continue
+			}
+			pir.RequestSeed = nil
+		}
 		localArgs.Args[i] = pir
 	}
 	r.shard.BatchRead(localArgs)
@@ -117,6 +254,16 @@ func (r *Replica) BatchRead(args *common.BatchReadRequest, reply *common.BatchRe
 	// wait for results
 	myReply := <-localArgs.ReplyChan
 
+	if myReply.Err != "" {
+		reply.Err = myReply.Err
+		r.log.Error.Printf("BatchRead: %s", myReply.Err)
+		return errors.New(myReply.Err)
+	}
+	if len(localArgs.Args) > len(myReply.Replies) {
+		r.log.Warn.Println("Shard did not respond to all reads!")
+		return nil
+	}
+
 	// Mutate results
 	for i, val := range localArgs.Args {
 		if myReply.Replies[i].Err == "" {
@@ -125,12 +272,24 @@ func (r *Replica) BatchRead(args *common.BatchReadRequest, reply *common.BatchRe
 			}
 		}
 	}
-
-	if len(args.Args) > len(myReply.Replies) {
-		r.log.Warn.Println("Shard did not respond to all reads!")
-		return nil
+	for i, e := range readErrs {
+		if e != "" {
+			myReply.Replies[i].Err = e
+		}
 	}
+
 	reply.Replies = myReply.Replies[0:len(args.Args)]
 	r.log.Trace.Println("BatchRead: exit")
 	return nil
 }
+
+// GetHint provides the hint of the current database, for the lwe backing.
+func (r *Replica) GetHint(args *common.GetHintArgs, reply *common.GetHintReply) error {
+	reply.Hint, reply.Epoch = r.shard.GetHint()
+	if reply.Hint == nil {
+		reply.Err = "No hint available for the PIR backing of the replica"
+	} else if args.Epoch != 0 && args.Epoch == reply.Epoch {
+		reply.Hint = nil
+	}
+	return nil
+}
@@ -2,9 +2,12 @@ package server
 
 import (
 	"crypto/rand"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/drbg"
 	"github.com/privacylab/talek/libtalek"
 )
 
@@ -35,7 +38,7 @@ func BenchmarkWrite(b *testing.B) {
 	}
 
 	var reply common.ReplicaWriteReply
-	t0 := NewReplica("t0", "cpu.0", Config{&config, 1, 0, 0, nil, 0})
+	t0 := NewReplica("t0", "cpu.0", Config{Config: &config, ReadBatch: 1})
 
 	// Start timing
 	b.ResetTimer()
@@ -44,3 +47,199 @@ func BenchmarkWrite(b *testing.B) {
 	}
 
 }
+
+func TestReplicaAuthentication(t *testing.T) {
+	config := common.Config{}
+	config.NumBuckets = 128
+	config.BucketDepth = 4
+	config.DataSize = 256
+	config.MaxLoadFactor = 0.90
+	config.BloomFalsePositive = 0.1
+	frontend := common.NewTrustDomainConfig("f0", "localhost:8999", true, false)
+	other := common.NewTrustDomainConfig("other", "localhost:8999", true, false)
+
+	r := NewReplica("t0", "cpu.0", Config{Config: &config, ReadBatch: 1, Frontend: frontend})
+	defer r.Close()
+
+	args := &common.ReplicaWriteArgs{EpochFlag: true}
+	if err := r.Write(args, &common.ReplicaWriteReply{}); err == nil {
+		t.Errorf("Replica accepted unsigned write")
+	}
+	args.Sign(other, 1)
+	if err := r.Write(args, &common.ReplicaWriteReply{}); err == nil {
+		t.Errorf("Replica accepted write signed by another trust domain")
+	}
+	args.Sign(frontend, 1)
+	if err := r.Write(args, &common.ReplicaWriteReply{}); err != nil {
+		t.Errorf("Replica rejected write of the frontend: %v", err)
+	}
+	if err := r.Write(args, &common.ReplicaWriteReply{}); err == nil {
+		t.Errorf("Replica accepted replayed write")
+	}
+
+	read := &common.BatchReadRequest{}
+	read.Sign(frontend, 1)
+	if err := r.BatchRead(read, &common.BatchReadReply{}); err == nil {
+		t.Errorf("Replica accepted read with replayed sequence number")
+	}
+	read.Sign(frontend, 2)
+	read.SeqNoRange.End = 1
+	if err := r.BatchRead(read, &common.BatchReadReply{}); err == nil {
+		t.Errorf("Replica accepted modified read")
+	}
+}
+
+func TestReplicaInterestWindow(t *testing.T) {
+	config := common.Config{}
+	config.NumBuckets = 8
+	config.BucketDepth = 2
+	config.DataSize = 256
+	config.MaxLoadFactor = 0.5
+	config.BloomFalsePositive = 0.1
+	r := NewReplica("t0", "cpu.0", Config{Config: &config, ReadBatch: 1})
+	defer r.Close()
+
+	write := func(seq uint64, interest []byte) {
+		args := &common.ReplicaWriteArgs{WriteArgs: common.WriteArgs{
+			Bucket1: seq % 8, Bucket2: (seq + 1) % 8, Data: make([]byte, config.DataSize),
+			GlobalSeqNo: seq, InterestVector: interest}}
+		if err := r.Write(args, &common.ReplicaWriteReply{}); err != nil {
+			t.Fatalf("Write %d failed: %v", seq, err)
+		}
+	}
+	write(1, []byte("interest"))
+	// Writes without interest vectors also move the window along.
+	for seq := uint64(2); seq <= 1+config.WindowSize(); seq++ {
+		write(seq, nil)
+	}
+	if r.interestVector.Test([]byte("interest")) || r.interestVector.Entries() != 0 {
+		t.Errorf("Interest of a write outside the window is still held")
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	w := replayWindow{}
+	for _, seq := range []uint64{5, 3, 4, 1030, 7} {
+		if !w.accept(seq) {
+			t.Errorf("Window rejected new sequence number %d", seq)
+		}
+	}
+	for _, seq := range []uint64{5, 3, 1030, 7, 6} {
+		if w.accept(seq) {
+			t.Errorf("Window accepted sequence number %d", seq)
+		}
+	}
+	if !w.accept(1029) || !w.accept(3000) || w.accept(1030) {
+		t.Errorf("Window failed to advance")
+	}
+}
+
+func TestReplicaMalformedRead(t *testing.T) {
+	config := common.Config{}
+	config.NumBuckets = 128
+	config.BucketDepth = 4
+	config.DataSize = 256
+	config.MaxLoadFactor = 0.90
+	config.BloomFalsePositive = 0.1
+	td := common.NewTrustDomainConfig("t0", "localhost:9000", true, false)
+
+	r := NewReplica("t0", "cpu.0", Config{Config: &config, ReadBatch: 3, TrustDomain: td})
+	defer r.Close()
+
+	reads := []common.ReadArgs{
+		{TD: []common.PirArgs{{DPFKey: []byte{1, 2, 3}, PadSeed: make([]byte, drbg.SeedLength)}}},
+		{TD: []common.PirArgs{{RequestVector: make([]byte, 16), PadSeed: make([]byte, drbg.SeedLength)}}},
+		{TD: []common.PirArgs{{RequestSeed: []byte{1, 2, 3}, PadSeed: make([]byte, drbg.SeedLength)}}},
+	}
+	args := &common.BatchReadRequest{}
+	for _, read := range reads {
+		encoded, err := read.Encode([]*common.TrustDomainConfig{td})
+		if err != nil {
+			t.Fatal(err)
+		}
+		args.Args = append(args.Args, encoded)
+	}
+	reply := &common.BatchReadReply{}
+	if err := r.BatchRead(args, reply); err != nil {
+		t.Fatalf("Malformed read failed the batch: %v", err)
+	}
+	if len(reply.Replies) != 3 {
+		t.Fatalf("Got %d replies, expected 3", len(reply.Replies))
+	}
+	if reply.Replies[0].Err == "" {
+		t.Errorf("Malformed DPF key was read")
+	}
+	if reply.Replies[1].Err != "" {
+		t.Errorf("Read batched with a malformed read failed: %s", reply.Replies[1].Err)
+	}
+	if reply.Replies[2].Err == "" {
+		t.Errorf("Malformed request seed was read")
+	}
+}
+
+func TestFrontendMalformedSeed(t *testing.T) {
+	config := common.Config{}
+	config.NumBuckets = 128
+	config.BucketDepth = 4
+	config.DataSize = 256
+	config.MaxLoadFactor = 0.90
+	config.BloomFalsePositive = 0.1
+	config.RequestEncoding = common.EncodingSeed
+	td := common.NewTrustDomainConfig("t0", "localhost:9000", true, false)
+
+	r := NewReplica("t0", "cpu.0", Config{Config: &config, ReadBatch: 2, TrustDomain: td})
+	defer r.Close()
+	f := NewFrontend("f0", &Config{Config: &config, ReadBatch: 2, ReadInterval: time.Minute, WriteInterval: time.Minute}, []common.ReplicaInterface{r})
+	defer f.Close()
+
+	reads := []common.ReadArgs{
+		{TD: []common.PirArgs{{RequestVector: make([]byte, 16), PadSeed: make([]byte, drbg.SeedLength)}}},
+		{TD: []common.PirArgs{{RequestSeed: []byte{1, 2, 3}, PadSeed: make([]byte, drbg.SeedLength)}}},
+	}
+	replies := make([]common.ReadReply, len(reads))
+	var wg sync.WaitGroup
+	for i, read := range reads {
+		encoded, err := read.Encode([]*common.TrustDomainConfig{td})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f.Read(&encoded, &replies[i])
+		}(i)
+	}
+	wg.Wait()
+
+	if replies[0].Err != "" || len(replies[0].Data) == 0 {
+		t.Errorf("Read batched with a malformed seed failed: %s", replies[0].Err)
+	}
+	if replies[1].Err == "" || replies[1].Data != nil {
+		t.Errorf("Read of a malformed seed was answered without error")
+	}
+}
+
+func TestReplicaHintEpoch(t *testing.T) {
+	conf := testConf()
+	conf.Config.NumBuckets = 64
+	conf.Config.BucketDepth = 2
+	conf.Config.DataSize = 64
+	conf.Config.RequestEncoding = common.EncodingLWE
+	r := NewReplica("t0", "lwe", conf)
+	defer r.Close()
+
+	reply := &common.GetHintReply{}
+	if err := r.GetHint(&common.GetHintArgs{}, reply); err != nil || len(reply.Hint) == 0 {
+		t.Fatalf("Failed to get hint: %v %s", err, reply.Err)
+	}
+	unchanged := &common.GetHintReply{}
+	r.GetHint(&common.GetHintArgs{Epoch: reply.Epoch}, unchanged)
+	if unchanged.Err != "" || len(unchanged.Hint) != 0 || unchanged.Epoch != reply.Epoch {
+		t.Errorf("Hint of an unchanged epoch was sent again")
+	}
+	stale := &common.GetHintReply{}
+	r.GetHint(&common.GetHintArgs{Epoch: reply.Epoch - 1}, stale)
+	if len(stale.Hint) == 0 {
+		t.Errorf("Hint of a newer epoch was not sent")
+	}
+}
@@ -1,13 +1,15 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"log"
 	"net"
 	"net/http"
 	"os"
 
 	"github.com/gorilla/rpc"
-	"github.com/gorilla/rpc/json"
+	"github.com/privacylab/talek/common"
 )
 
 // ReplicaServer is an RPC server for a Replica
@@ -17,6 +19,7 @@ type ReplicaServer struct {
 
 	Replica *Replica
 	*rpc.Server
+	config Config
 }
 
 // NewReplicaServer creates a new Replica served over HTTP
@@ -24,30 +27,43 @@ func NewReplicaServer(name string, backing string, serverConfig Config) *Replica
 	r := &ReplicaServer{}
 	r.log = log.New(os.Stdout, "[ReplicaServer:"+name+"] ", log.Ldate|log.Ltime|log.Lshortfile)
 	r.name = name
+	r.config = serverConfig
 
 	r.Replica = NewReplica(name, backing, serverConfig)
+	if serverConfig.Frontend == nil {
+		r.log.Printf("No frontend configured. Writes and reads are accepted from anyone.")
+	}
 
 	// Set up the RPC server component.
 	r.Server = rpc.NewServer()
-	r.Server.RegisterCodec(json.NewCodec(), "application/json")
+	common.RegisterCodecs(r.Server)
 	r.Server.RegisterTCPService(r.Replica, "Replica")
 
 	return r
 }
 
-// Run begins an HTTP server for the server at a specific address
+// Run begins an HTTP server for the server at a specific address. If the
+// address of the trust domain of the replica is https, it serves HTTPS to the
+// Frontend of its config only.
 func (r *ReplicaServer) Run(address string) (net.Listener, error) {
 	if r.Server == nil {
 		r.Server = rpc.NewServer()
-		r.Server.RegisterCodec(&json.Codec{}, "application/json")
+		common.RegisterCodecs(r.Server)
 		r.Server.RegisterTCPService(r.Replica, "Replica")
 	}
 
-	bindAddr, err := net.ResolveTCPAddr("tcp4", address)
-	if err != nil {
-		return nil, err
+	var tlsConfig *tls.Config
+	if td := r.config.TrustDomain; td != nil && td.UsesTLS() {
+		if r.config.Frontend == nil {
+			return nil, errors.New("replica served over TLS needs the trust domain of its frontend")
+		}
+		var err error
+		if tlsConfig, err = td.ServerTLSConfig(r.config.Frontend); err != nil {
+			return nil, err
+		}
 	}
-	listener, err := net.ListenTCP("tcp4", bindAddr)
+
+	listener, err := listen(address, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -1,12 +1,17 @@
 package server
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"os"
 	"sync/atomic"
+	"time"
 
 	"github.com/privacylab/talek/common"
 	"github.com/privacylab/talek/cuckoo"
 	"github.com/privacylab/talek/pir"
+	"github.com/privacylab/talek/pir/lwe"
 )
 
 // Shard represents a single shard of the PIR database.
@@ -21,16 +26,33 @@ type Shard struct {
 	*pir.Server
 	*pir.DB
 	dead int
+	// The file holding the DB, or empty for DBs held in memory.
+	dataFile string
 
 	Entries []cuckoo.Item
 	*cuckoo.Table
 
 	config atomic.Value // Config
+	hint   atomic.Value // shardHint
+	// The epoch of the DB served, advanced by each SetDB applying writes.
+	// Owned by the read thread.
+	epoch uint64
+	// The stash of the table as of the DB served. Owned by the read thread.
+	stash []byte
+
+	// The range of buckets [bucketStart, bucketEnd) served by this shard.
+	// When the shard does not cover the full database, the cuckoo table only
+	// tracks the layout of item IDs, and the data of items that may be placed
+	// in the range is held in items until it is materialized into the DB.
+	bucketStart uint64
+	bucketEnd   uint64
+	layout      []byte
+	items       map[uint64][]byte
 
 	// Channels
 	writeChan        chan *common.ReplicaWriteArgs
 	readChan         chan *DecodedBatchReadRequest
-	outstandingReads chan chan *common.BatchReadReply
+	outstandingReads chan outstandingRead
 	readReplies      chan []byte
 	syncChan         chan int
 
@@ -46,34 +68,75 @@ type DecodedBatchReadRequest struct {
 	ReplyChan chan *common.BatchReadReply
 }
 
+// outstandingRead is a batch of reads sent to the PIR server, with the stash
+// of the table as of the DB being read.
+type outstandingRead struct {
+	replyChan chan *common.BatchReadReply
+	stash     []byte
+	epoch     uint64
+}
+
+// shardHint is the hint of the DB served in an epoch.
+type shardHint struct {
+	hint  []byte
+	epoch uint64
+}
+
+// layoutItemSize is the size of an item ID in the layout table of a shard
+// holding a partial bucket range.
+const layoutItemSize = 8
+
 // NewShard creates an interface to a PIR daemon at socket, using a given
 // server configuration for sizing and locating data.
 func NewShard(name string, backing string, config Config) *Shard {
+	return NewRangeShard(name, backing, config, 0, config.Config.NumBuckets)
+}
+
+// NewRangeShard creates a shard holding only buckets [bucketStart, bucketEnd)
+// of the database. Range shards are the members of a distributed trust domain.
+func NewRangeShard(name string, backing string, config Config, bucketStart uint64, bucketEnd uint64) *Shard {
 	s := &Shard{}
 	s.log = common.NewLogger(name)
 	s.name = name
 
+	if bucketStart >= bucketEnd || bucketEnd > config.Config.NumBuckets {
+		s.log.Error.Printf("Invalid bucket range [%d, %d) for %d buckets", bucketStart, bucketEnd, config.Config.NumBuckets)
+		return nil
+	}
+	s.bucketStart = bucketStart
+	s.bucketEnd = bucketEnd
+
 	s.config.Store(config)
 	s.writeChan = make(chan *common.ReplicaWriteArgs)
 	s.readChan = make(chan *DecodedBatchReadRequest)
 	s.syncChan = make(chan int)
-	s.outstandingReads = make(chan chan *common.BatchReadReply, 5)
+	s.outstandingReads = make(chan outstandingRead, 5)
 	s.readReplies = make(chan []byte)
 
-	// TODO: per-server config of where the local PIR socket is.
+	// A backing of pir.SocketPrefix and a socket path runs PIR in a talekpird.
 	pirServer, err := pir.NewServer(backing)
 	if err != nil {
 		s.log.Error.Fatalf("Could not connect to pir back end: %v", err)
 		return nil
 	}
 	s.Server = pirServer
-	err = s.Server.Configure(int(config.Config.DataSize*config.Config.BucketDepth), int(config.Config.NumBuckets), config.ReadBatch)
+	err = s.Server.Configure(int(config.Config.DataSize*config.Config.BucketDepth), int(bucketEnd-bucketStart), config.ReadBatch)
 	if err != nil {
 		s.log.Error.Fatalf("Could not start PIR back end with correct parameters: %v", err)
 		return nil
 	}
-
-	db, err := pirServer.GetDB()
+	if config.Config.RequestEncoding == common.EncodingLWE {
+		s.Server.RequestLength = lwe.QueryLength(int(bucketEnd - bucketStart))
+	}
+	s.Server.BatchSizes = config.BatchSizes()
+
+	var db *pir.DB
+	if config.DataFile != "" {
+		s.dataFile = shardDataFile(config, bucketStart, bucketEnd)
+		db, err = pirServer.GetFileDB(s.dataFile)
+	} else {
+		db, err = pirServer.GetDB()
+	}
 	if err != nil {
 		s.log.Error.Fatalf("Could not allocate DB region: %v", err)
 		return nil
@@ -81,10 +144,28 @@ func NewShard(name string, backing string, config Config) *Shard {
 	s.DB = db
 	//Set initial DB
 	s.Server.SetDB(s.DB)
+	// Epochs start from the time, so they differ across restarts.
+	s.epoch = uint64(time.Now().UnixNano())
+	s.hint.Store(shardHint{s.Server.GetHint(), s.epoch})
 
 	// TODO: rand seed
-	s.Table = cuckoo.NewTable(name+"-Table", config.Config.NumBuckets, config.Config.BucketDepth, config.Config.DataSize, db.DB, 0)
+	if s.isPartial() {
+		s.layout = make([]byte, config.Config.NumBuckets*config.Config.BucketDepth*layoutItemSize)
+		s.items = make(map[uint64][]byte)
+		s.Table = cuckoo.NewTable(name+"-Table", config.Config.NumBuckets, config.Config.BucketDepth, layoutItemSize, s.layout, 0)
+	} else {
+		s.Table = cuckoo.NewStashTable(name+"-Table", config.Config.NumBuckets, config.Config.BucketDepth, config.Config.DataSize, db.DB, 0, config.Config.StashBuckets)
+		s.Table.OnWrite(func(bucket uint64) {
+			s.DB.MarkDirty(int(bucket))
+		})
+	}
 	s.Entries = make([]cuckoo.Item, 0, config.Config.NumBuckets*config.Config.BucketDepth)
+	if s.dataFile != "" {
+		if err = s.loadState(); err != nil && !os.IsNotExist(err) {
+			s.log.Warn.Printf("Could not restore the table of %s: %v", s.dataFile, err)
+		}
+	}
+	s.stash = s.stashView()
 
 	//TODO: should be a parameter in globalconfig
 	s.outstandingLimit = int(float32(config.Config.NumBuckets*uint64(config.Config.BucketDepth)) * 0.50)
@@ -108,6 +189,13 @@ func (s *Shard) BatchRead(args *DecodedBatchReadRequest) {
 	s.readChan <- args
 }
 
+// GetHint returns the hint of the database currently served and its epoch,
+// for backings of computational PIR schemes, or nil.
+func (s *Shard) GetHint() ([]byte, uint64) {
+	hint := s.hint.Load().(shardHint)
+	return hint.hint, hint.epoch
+}
+
 // Close shuts down the database.
 func (s *Shard) Close() {
 	s.log.Info.Printf("Graceful shutdown of shard.")
@@ -126,7 +214,7 @@ func (s *Shard) processReads() {
 	// The read thread searializs all access to the underlying DB
 	var batchReadReq *DecodedBatchReadRequest
 
-	defer s.DB.Free()
+	defer s.DB.Close()
 	defer s.Server.Disconnect()
 	conf := s.config.Load().(Config)
 	for {
@@ -139,32 +227,53 @@ func (s *Shard) processReads() {
 			}
 			s.batchRead(batchReadReq, conf)
 			continue
-		case <-s.syncChan:
+		case writes := <-s.syncChan:
 			s.Server.SetDB(s.DB)
+			// The epoch only advances when the DB changed, so hints of
+			// unchanged DBs remain valid.
+			if writes > 0 {
+				s.epoch++
+			}
+			s.hint.Store(shardHint{s.Server.GetHint(), s.epoch})
+			s.stash = s.stashView()
+			// Release the write thread to continue with the new write buffer.
+			s.syncChan <- 1
 		}
 	}
 }
 
 func (s *Shard) processReplies() {
 	var outputChannel chan *common.BatchReadReply
+	var stash []byte
+	var epoch uint64
 	conf := s.config.Load().(Config)
 	itemLength := int(conf.DataSize * conf.BucketDepth)
+	if conf.RequestEncoding == common.EncodingLWE {
+		itemLength = lwe.ResponseLength(itemLength)
+	}
 
 	for {
 		select {
 		case reply := <-s.readReplies:
 			// get the corresponding read request.
-			outputChannel = <-s.outstandingReads
+			outstanding := <-s.outstandingReads
+			outputChannel, stash, epoch = outstanding.replyChan, outstanding.stash, outstanding.epoch
 
-			response := &common.BatchReadReply{Err: "", Replies: make([]common.ReadReply, conf.ReadBatch)}
+			// Batches vary in size, so the size is given by the response.
+			batchSize := len(reply) / itemLength
+			response := &common.BatchReadReply{Err: "", Replies: make([]common.ReadReply, batchSize)}
 
-			if len(reply) < conf.ReadBatch*itemLength {
-				s.log.Error.Printf("PIR Response was of length %d, not %d * %d\n", len(reply), conf.ReadBatch, itemLength)
+			if len(reply) != batchSize*itemLength || conf.BatchSize(batchSize) != batchSize {
+				s.log.Error.Printf("PIR Response was of length %d, not a batch of %d byte items\n", len(reply), itemLength)
 				outputChannel <- response
 				continue
 			}
-			for i := 0; i < conf.ReadBatch; i++ {
+			for i := 0; i < batchSize; i++ {
 				response.Replies[i].Data = reply[i*itemLength : (i+1)*itemLength]
+				response.Replies[i].Epoch = epoch
+				if len(stash) > 0 {
+					response.Replies[i].Data = append(response.Replies[i].Data[:itemLength:itemLength], stash...)
+				}
 				//TODO: reply.GlobalSeqNo
 			}
 			outputChannel <- response
@@ -186,6 +295,9 @@ func (s *Shard) processWrites() {
 			}
 
 			itm := asCuckooItem(&writeReq.WriteArgs)
+			if s.isPartial() {
+				itm = s.asLayoutItem(itm)
+			}
 			s.Entries = append(s.Entries, *itm)
 			ok, evicted := s.Table.Insert(itm)
 			// No longer need this pointer.
@@ -211,8 +323,25 @@ func (s *Shard) processWrites() {
 
 // applyWrites will enque a command to apply any outstanding writes to the
 // database to be seen by subsequent reads.
+// The read thread takes the write buffer of the DB as is, and hands back the
+// other buffer, which subsequent writes go to.
 func (s *Shard) applyWrites() {
-	s.syncChan <- 1
+	if s.isPartial() {
+		s.materialize()
+	}
+	// The read thread is told the number of writes applied.
+	s.syncChan <- s.sinceFlip
+	<-s.syncChan
+	if !s.isPartial() {
+		if err := s.Table.SetData(s.DB.DB); err != nil {
+			s.log.Error.Fatalf("Could not swap DB buffers: %v", err)
+		}
+	}
+	if s.dataFile != "" {
+		if err := s.saveState(); err != nil {
+			s.log.Error.Printf("Could not save the table of %s: %v", s.dataFile, err)
+		}
+	}
 	s.sinceFlip = 0
 }
 
@@ -224,29 +353,102 @@ func (s *Shard) evictOldItems() {
 	}
 	for i := 0; i < toRemove; i++ {
 		s.Table.Remove(&s.Entries[i])
+		if s.items != nil {
+			delete(s.items, s.Entries[i].ID)
+		}
 	}
 	s.Entries = s.Entries[toRemove:]
 }
 
 func asCuckooItem(wa *common.WriteArgs) *cuckoo.Item {
 	//TODO: cuckoo should continue int64 sized buckets if needed.
-	return &cuckoo.Item{ID: wa.GlobalSeqNo, Data: wa.Data, Bucket1: wa.Bucket1, Bucket2: wa.Bucket2}
+	return &cuckoo.Item{ID: wa.GlobalSeqNo, Data: wa.Data, Bucket1: wa.Bucket1, Bucket2: wa.Bucket2, Buckets: wa.Buckets}
+}
+
+// isPartial is true for shards holding less than the full database.
+func (s *Shard) isPartial() bool {
+	conf := s.config.Load().(Config)
+	return s.bucketStart != 0 || s.bucketEnd != conf.Config.NumBuckets
+}
+
+// asLayoutItem keeps the data of an item that may be placed in the range of
+// this shard, and returns the item to place in the layout table instead.
+func (s *Shard) asLayoutItem(itm *cuckoo.Item) *cuckoo.Item {
+	for _, bucket := range itm.Candidates() {
+		if s.inRange(bucket) {
+			s.items[itm.ID] = itm.Data
+			break
+		}
+	}
+	layoutItem := *itm
+	layoutItem.Data = make([]byte, layoutItemSize)
+	binary.LittleEndian.PutUint64(layoutItem.Data, itm.ID)
+	return &layoutItem
+}
+
+func (s *Shard) inRange(bucket uint64) bool {
+	return bucket >= s.bucketStart && bucket < s.bucketEnd
+}
+
+// stashView returns the stash to append to read replies. Replies of all trust
+// domains are combined, so only the first trust domain returns the stash, and
+// the others return zeros of the same length.
+// Must be called while the write thread is paused.
+func (s *Shard) stashView() []byte {
+	conf := s.config.Load().(Config)
+	view := make([]byte, len(s.Table.Stash()))
+	if conf.TrustDomainIndex == 0 {
+		copy(view, s.Table.Stash())
+	}
+	return view
+}
+
+// materialize copies the data of items placed in the range of this shard
+// into the DB, following the current layout table.
+func (s *Shard) materialize() {
+	conf := s.config.Load().(Config)
+	itemSize := conf.Config.DataSize
+	depth := conf.Config.BucketDepth
+	prev := make([]byte, depth*itemSize)
+	for bucket := s.bucketStart; bucket < s.bucketEnd; bucket++ {
+		cell := s.DB.DB[(bucket-s.bucketStart)*depth*itemSize:][:depth*itemSize]
+		copy(prev, cell)
+		for i := uint64(0); i < depth; i++ {
+			slot := bucket*depth + i
+			id := binary.LittleEndian.Uint64(s.layout[slot*layoutItemSize:])
+			dst := cell[i*itemSize:][:itemSize]
+			if data, ok := s.items[id]; ok && id != 0 {
+				n := copy(dst, data)
+				for j := n; j < len(dst); j++ {
+					dst[j] = 0
+				}
+			} else {
+				for j := range dst {
+					dst[j] = 0
+				}
+			}
+		}
+		if !bytes.Equal(prev, cell) {
+			s.DB.MarkDirty(int(bucket - s.bucketStart))
+		}
+	}
 }
 
 func (s *Shard) batchRead(req *DecodedBatchReadRequest, conf Config) {
 	s.log.Trace.Printf("batchRead: enter\n")
 
 	// Run PIR
-	reqlength := int(conf.Config.NumBuckets) / 8
-	pirvector := make([]byte, reqlength*conf.ReadBatch)
+	reqlength := s.Server.RequestLength
+	batchSize := len(req.Args)
+	pirvector := make([]byte, reqlength*batchSize)
 
-	if len(req.Args) != conf.ReadBatch {
+	if conf.BatchSize(batchSize) != batchSize {
 		s.log.Info.Printf("Read operation failed: incorrect number of reads.")
 		req.ReplyChan <- &common.BatchReadReply{Err: fmt.Sprintf("Invalid batch size.")}
 		return
 	}
 
-	for i := 0; i < conf.ReadBatch; i++ {
+	for i := 0; i < batchSize; i++ {
 		reqVector := req.Args[i].RequestVector
 		copy(pirvector[reqlength*i:reqlength*(i+1)], reqVector)
 	}
@@ -256,7 +458,7 @@ func (s *Shard) batchRead(req *DecodedBatchReadRequest, conf Config) {
 		req.ReplyChan <- &common.BatchReadReply{Err: fmt.Sprintf("Failed to read: %v", err)}
 		return
 	}
-	s.outstandingReads <- req.ReplyChan
+	s.outstandingReads <- outstandingRead{req.ReplyChan, s.stash, s.epoch}
 
 	s.log.Trace.Printf("batchRead: exit\n")
 }
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/privacylab/talek/cuckoo"
+)
+
+// shardState is the state of a shard saved alongside its DataFile, so that
+// the cuckoo table indexing the items in the file is restored on restart.
+type shardState struct {
+	Table   []byte
+	Entries []cuckoo.Item
+	// The layout table and item data of a shard holding a partial range.
+	Layout []byte
+	Items  map[uint64][]byte
+}
+
+// shardDataFile is the path of the DataFile of a shard. Shards of other trust
+// domains and of partial bucket ranges built from the same configuration
+// hold different data, so their paths are suffixed with the trust domain and
+// range.
+func shardDataFile(config Config, bucketStart uint64, bucketEnd uint64) string {
+	path := config.DataFile
+	if config.TrustDomainIndex != 0 {
+		path += fmt.Sprintf(".%d", config.TrustDomainIndex)
+	}
+	if bucketStart != 0 || bucketEnd != config.Config.NumBuckets {
+		path += fmt.Sprintf(".%d-%d", bucketStart, bucketEnd)
+	}
+	return path
+}
+
+// stateFile is the path of the saved state of a shard.
+func (s *Shard) stateFile() string {
+	return s.dataFile + ".table"
+}
+
+// saveState writes the state of the shard, replacing the previous state once
+// it is completely written. Must be called from the write thread while the
+// table matches the DB served.
+func (s *Shard) saveState() error {
+	table, err := s.Table.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	state := shardState{Table: table, Entries: s.Entries, Layout: s.layout, Items: s.items}
+	var buf bytes.Buffer
+	if err = gob.NewEncoder(&buf).Encode(&state); err != nil {
+		return err
+	}
+	tmp := s.stateFile() + ".tmp"
+	if err = ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.stateFile())
+}
+
+// loadState restores the state saved by saveState. The shard is left
+// unchanged if the state can't be restored.
+func (s *Shard) loadState() error {
+	data, err := ioutil.ReadFile(s.stateFile())
+	if err != nil {
+		return err
+	}
+	var state shardState
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&state); err != nil {
+		return err
+	}
+	if len(state.Layout) != len(s.layout) {
+		return errors.New("saved layout is not sized for the shard")
+	}
+	if err = s.Table.UnmarshalBinary(state.Table); err != nil {
+		return err
+	}
+	copy(s.layout, state.Layout)
+	for id, data := range state.Items {
+		s.items[id] = data
+	}
+	s.Entries = append(s.Entries[:0], state.Entries...)
+	return nil
+}
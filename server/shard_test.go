@@ -2,14 +2,19 @@ package server
 
 import (
 	"bytes"
+	cryptorand "crypto/rand"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 
 	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/pir/lwe"
 	_ "github.com/privacylab/talek/pir/pircpu"
+	_ "github.com/privacylab/talek/pir/pirlwe"
 )
 
 import "testing"
@@ -63,8 +68,9 @@ func TestShardSanity(t *testing.T) {
 		EpochFlag: false,
 	})
 
-	// Force DB write.
-	shard.syncChan <- 1
+	// Force DB write. The second epoch flag blocks until the first has been applied.
+	shard.Write(&common.ReplicaWriteArgs{EpochFlag: true})
+	shard.Write(&common.ReplicaWriteArgs{EpochFlag: true})
 
 	replychan := make(chan *common.BatchReadReply)
 
@@ -86,6 +92,196 @@ func TestShardSanity(t *testing.T) {
 	shard.Close()
 }
 
+func TestShardLWE(t *testing.T) {
+	conf := testConf()
+	conf.Config.NumBuckets = 64
+	conf.Config.BucketDepth = 2
+	conf.Config.DataSize = 64
+	conf.Config.RequestEncoding = common.EncodingLWE
+	shard := NewShard("Test LWE Shard", "lwe", conf)
+	if shard == nil {
+		t.Fatal("Failed to create shard.")
+	}
+
+	data := make([]byte, conf.Config.DataSize)
+	copy(data, bytes.NewBufferString("Magic").Bytes())
+	shard.Write(&common.ReplicaWriteArgs{
+		WriteArgs: common.WriteArgs{
+			Bucket1: 3,
+			Bucket2: 3,
+			Data:    data,
+		},
+	})
+
+	// Force DB write. The second epoch flag blocks until the first has been applied.
+	shard.Write(&common.ReplicaWriteArgs{EpochFlag: true})
+	shard.Write(&common.ReplicaWriteArgs{EpochFlag: true})
+
+	query, secret, err := lwe.Query(3, conf.Config.NumBuckets, cryptorand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := make([]common.PirArgs, conf.ReadBatch)
+	for i := range reqs {
+		reqs[i] = common.PirArgs{RequestVector: query}
+	}
+	replychan := make(chan *common.BatchReadReply)
+	shard.BatchRead(&DecodedBatchReadRequest{Args: reqs, ReplyChan: replychan})
+	reply := <-replychan
+
+	// The read is served after the hint of the updated DB is stored.
+	hint, epoch := shard.GetHint()
+	if reply.Replies[0].Epoch != epoch {
+		t.Fatalf("Read of epoch %d, while the hint is of epoch %d", reply.Replies[0].Epoch, epoch)
+	}
+	bucket, err := lwe.Recover(hint, secret, reply.Replies[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bucket[:conf.Config.DataSize], data) {
+		t.Fatal("Failed to round-trip a write with an lwe read.")
+	}
+
+	// The hint is updated with the next epoch.
+	shard.Write(&common.ReplicaWriteArgs{
+		WriteArgs: common.WriteArgs{
+			Bucket1:     5,
+			Bucket2:     5,
+			Data:        data,
+			GlobalSeqNo: 1,
+		},
+	})
+	shard.Write(&common.ReplicaWriteArgs{EpochFlag: true})
+	shard.Write(&common.ReplicaWriteArgs{EpochFlag: true})
+	query, secret, _ = lwe.Query(5, conf.Config.NumBuckets, cryptorand.Reader)
+	for i := range reqs {
+		reqs[i] = common.PirArgs{RequestVector: query}
+	}
+	shard.BatchRead(&DecodedBatchReadRequest{Args: reqs, ReplyChan: replychan})
+	reply = <-replychan
+	hint, nextEpoch := shard.GetHint()
+	if nextEpoch == epoch || reply.Replies[0].Epoch != nextEpoch {
+		t.Fatalf("Epochs were not advanced with the DB")
+	}
+	bucket, err = lwe.Recover(hint, secret, reply.Replies[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bucket[:conf.Config.DataSize], data) {
+		t.Fatal("Failed to read a write with the updated hint.")
+	}
+
+	shard.Close()
+}
+
+func TestShardBatchSizes(t *testing.T) {
+	conf := testConf()
+	conf.ReadBatch = 8
+	conf.ReadBatchSizes = []int{4, 1}
+	if sizes := conf.BatchSizes(); len(sizes) != 3 || sizes[0] != 1 || sizes[1] != 4 || sizes[2] != 8 {
+		t.Fatalf("Unexpected batch sizes %v", sizes)
+	}
+	if conf.BatchSize(2) != 4 || conf.BatchSize(4) != 4 || conf.BatchSize(5) != 8 || conf.BatchSize(9) != 0 {
+		t.Fatal("Batch sizes should be rounded up to the smallest allowed size.")
+	}
+
+	shard := NewShard("Test Shard", "cpu.0", conf)
+	if shard == nil {
+		t.Fatal("Failed to create shard.")
+	}
+	replychan := make(chan *common.BatchReadReply)
+	for _, size := range []int{4, 3} {
+		reqs := make([]common.PirArgs, size)
+		for i := range reqs {
+			reqs[i] = common.PirArgs{RequestVector: make([]byte, conf.NumBuckets/8)}
+		}
+		shard.BatchRead(&DecodedBatchReadRequest{Args: reqs, ReplyChan: replychan})
+		reply := <-replychan
+		if size == 4 && (reply.Err != "" || len(reply.Replies) != 4) {
+			t.Fatalf("Read of an allowed batch size failed: %v", reply.Err)
+		} else if size == 3 && reply.Err == "" {
+			t.Fatal("Read of a disallowed batch size should fail.")
+		}
+	}
+	shard.Close()
+}
+
+func TestShardStash(t *testing.T) {
+	conf := testConf()
+	conf.Config.BucketDepth = 1
+	conf.Config.StashBuckets = 1
+	shard := NewShard("Test Shard", "cpu.0", conf)
+	if shard == nil {
+		t.Fatal("Failed to create shard.")
+	}
+
+	// Both items can only go to bucket 3, so one of them is stashed.
+	for i, magic := range []string{"Magic1", "Magic2"} {
+		data := make([]byte, conf.Config.DataSize)
+		copy(data, magic)
+		shard.Write(&common.ReplicaWriteArgs{
+			WriteArgs: common.WriteArgs{Bucket1: 3, Bucket2: 3, Data: data, GlobalSeqNo: uint64(i + 1)},
+		})
+	}
+	shard.Write(&common.ReplicaWriteArgs{EpochFlag: true})
+	shard.Write(&common.ReplicaWriteArgs{EpochFlag: true})
+
+	reqs := make([]common.PirArgs, conf.ReadBatch)
+	for i := range reqs {
+		reqs[i] = common.PirArgs{RequestVector: make([]byte, conf.NumBuckets/8)}
+	}
+	reqs[0].RequestVector[0] = 1 << 3
+	replychan := make(chan *common.BatchReadReply)
+	shard.BatchRead(&DecodedBatchReadRequest{Args: reqs, ReplyChan: replychan})
+	reply := <-replychan
+	if reply.Err != "" {
+		t.Fatalf("Read failed: %v", reply.Err)
+	}
+	data := reply.Replies[0].Data
+	if len(data) != int(2*conf.Config.DataSize) {
+		t.Fatalf("Reply of length %d does not hold the bucket and stash", len(data))
+	}
+	if !bytes.Contains(data, []byte("Magic1")) || !bytes.Contains(data, []byte("Magic2")) {
+		t.Fatal("Reply should hold both the bucket and the stashed item.")
+	}
+	shard.Close()
+}
+
+func TestShardRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "talekshard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := testConf()
+	conf.DataFile = filepath.Join(dir, "db")
+	if shardDataFile(conf, 0, conf.NumBuckets/2) == shardDataFile(conf, conf.NumBuckets/2, conf.NumBuckets) {
+		t.Fatal("Range shards should keep separate files.")
+	}
+
+	shard := NewShard("Test Shard", "cpu.0", conf)
+	data := make([]byte, conf.Config.DataSize)
+	copy(data, "Magic")
+	shard.Write(&common.ReplicaWriteArgs{
+		WriteArgs: common.WriteArgs{Bucket1: 3, Bucket2: 5, Data: data, GlobalSeqNo: 1},
+	})
+	shard.Write(&common.ReplicaWriteArgs{EpochFlag: true})
+	shard.Write(&common.ReplicaWriteArgs{EpochFlag: true})
+	digest := shard.Table.Digest()
+	shard.Close()
+
+	restarted := NewShard("Test Shard", "cpu.0", conf)
+	if restarted.Table.Digest() != digest || len(restarted.Entries) != 1 {
+		t.Fatal("Table should be restored on restart.")
+	}
+	restarted.Close()
+	// The restored item is evicted like any other.
+	restarted.Table.Remove(&restarted.Entries[0])
+	if restarted.Table.Digest() == digest {
+		t.Fatal("Restored item should be removable.")
+	}
+}
+
 func BenchmarkShard(b *testing.B) {
 	fmt.Printf("Benchmark began with N=%d\n", b.N)
 	readsPerWrite := fromEnvOrDefault("READS_PER_WRITE", 20)
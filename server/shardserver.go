@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/rpc"
+	"github.com/privacylab/talek/common"
+)
+
+// RemoteShard serves a Shard over a bucket range to the head of a
+// distributed trust domain.
+type RemoteShard struct {
+	log  *common.Logger
+	name string
+
+	shard *Shard
+}
+
+// NewRemoteShard creates shard `index` of the shards named in
+// config.ShardAddresses.
+func NewRemoteShard(name string, backing string, config Config, index int) (*RemoteShard, error) {
+	r := &RemoteShard{}
+	r.log = common.NewLogger(name)
+	r.name = name
+
+	start, end, err := ShardRange(config.Config.NumBuckets, len(config.ShardAddresses), index)
+	if err != nil {
+		return nil, err
+	}
+	r.shard = NewRangeShard(name, backing, config, start, end)
+	if r.shard == nil {
+		return nil, errors.New("could not create shard")
+	}
+	return r, nil
+}
+
+// Close shuts down the underlying shard.
+func (r *RemoteShard) Close() {
+	r.shard.Close()
+}
+
+/** PUBLIC METHODS (threadsafe) **/
+
+// Write applies a write or epoch advance forwarded by the head.
+func (r *RemoteShard) Write(args *common.ReplicaWriteArgs, reply *common.ReplicaWriteReply) error {
+	r.log.Trace.Println("Write: enter")
+	if args.InterestFlag {
+		return nil
+	}
+	r.shard.Write(args)
+	reply.GlobalSeqNo = args.GlobalSeqNo
+	return nil
+}
+
+// BatchRead computes the partial PIR response of this shard's bucket range.
+func (r *RemoteShard) BatchRead(args *common.ShardReadArgs, reply *common.BatchReadReply) error {
+	r.log.Trace.Println("BatchRead: enter")
+	localArgs := &DecodedBatchReadRequest{
+		Args:      make([]common.PirArgs, len(args.RequestVectors)),
+		ReplyChan: make(chan *common.BatchReadReply),
+	}
+	for i, val := range args.RequestVectors {
+		localArgs.Args[i].RequestVector = val
+	}
+	r.shard.BatchRead(localArgs)
+
+	*reply = *<-localArgs.ReplyChan
+	r.log.Trace.Println("BatchRead: exit")
+	return nil
+}
+
+// ShardServer is an RPC server for a RemoteShard
+type ShardServer struct {
+	log  *log.Logger
+	name string
+
+	Shard *RemoteShard
+	*rpc.Server
+}
+
+// NewShardServer creates a new RemoteShard served over HTTP
+func NewShardServer(name string, backing string, serverConfig Config, index int) (*ShardServer, error) {
+	s := &ShardServer{}
+	s.log = log.New(os.Stdout, "[ShardServer:"+name+"] ", log.Ldate|log.Ltime|log.Lshortfile)
+	s.name = name
+
+	shard, err := NewRemoteShard(name, backing, serverConfig, index)
+	if err != nil {
+		return nil, err
+	}
+	s.Shard = shard
+
+	// Set up the RPC server component.
+	s.Server = rpc.NewServer()
+	common.RegisterCodecs(s.Server)
+	s.Server.RegisterTCPService(s.Shard, "Shard")
+
+	return s, nil
+}
+
+// Run begins an HTTP server for the server at a specific address
+func (s *ShardServer) Run(address string) (net.Listener, error) {
+	bindAddr, err := net.ResolveTCPAddr("tcp4", address)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.ListenTCP("tcp4", bindAddr)
+	if err != nil {
+		return nil, err
+	}
+	go http.Serve(listener, s)
+
+	return listener, nil
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/privacylab/talek/common"
+)
+
+const (
+	// defaultTokenEpoch is the validity of write tokens without a TokenEpoch.
+	defaultTokenEpoch = time.Hour
+	// tokenKeyBits is the size of generated token keys.
+	tokenKeyBits = 2048
+)
+
+// tokenIssuer issues the write tokens of a frontend, at most WriteTokens to
+// each requester per epoch, and admits WritesPerToken writes with each of
+// them. Tokens are spent in their epoch or the following one, so writes made
+// at the end of an epoch are still admitted.
+type tokenIssuer struct {
+	key            *rsa.PrivateKey
+	period         time.Duration
+	tokens         int
+	writesPerToken int
+	now            func() time.Time
+
+	lock      sync.Mutex
+	epoch     uint64
+	issued    map[string]int
+	spent     map[[common.TokenSerialLength]byte]int // Tokens of the epoch
+	prevSpent map[[common.TokenSerialLength]byte]int // Tokens of the previous epoch
+}
+
+// newTokenIssuer creates the tokenIssuer of a config, or nil if the config
+// admits writes without tokens.
+func newTokenIssuer(config *Config) (*tokenIssuer, error) {
+	if config.WriteTokens <= 0 {
+		return nil, nil
+	}
+	t := &tokenIssuer{}
+	t.tokens = config.WriteTokens
+	t.writesPerToken = config.WritesPerToken
+	if t.writesPerToken <= 0 {
+		t.writesPerToken = 1
+	}
+	t.period = config.TokenEpoch
+	if t.period <= 0 {
+		t.period = defaultTokenEpoch
+	}
+	t.now = time.Now
+
+	var err error
+	if config.TokenKeyFile != "" {
+		t.key, err = readTokenKey(config.TokenKeyFile)
+	} else {
+		t.key, err = rsa.GenerateKey(rand.Reader, tokenKeyBits)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Clients pin the modulus of the key, and expect the usual exponent.
+	if t.key.E != 65537 {
+		return nil, fmt.Errorf("token key has exponent %d rather than 65537", t.key.E)
+	}
+	t.key.Precompute()
+	return t, nil
+}
+
+// readTokenKey loads an RSA key in PKCS #1 or PKCS #8 PEM form.
+func readTokenKey(path string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM key in " + path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("token key in " + path + " is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// currentEpoch advances the state of the issuer to the current epoch, and
+// returns it. It is called with the lock held.
+func (t *tokenIssuer) currentEpoch() uint64 {
+	epoch := uint64(t.now().UnixNano() / int64(t.period))
+	if epoch != t.epoch || t.issued == nil {
+		if epoch == t.epoch+1 {
+			t.prevSpent = t.spent
+		} else {
+			t.prevSpent = nil
+		}
+		t.epoch = epoch
+		t.issued = make(map[string]int)
+		t.spent = make(map[[common.TokenSerialLength]byte]int)
+	}
+	return epoch
+}
+
+// describe fills in the current epoch and public key of the tokens.
+func (t *tokenIssuer) describe(reply *common.GetTokenKeyReply) {
+	t.lock.Lock()
+	reply.Epoch = t.currentEpoch()
+	t.lock.Unlock()
+	reply.Expires = time.Unix(0, int64(reply.Epoch+1)*int64(t.period))
+	reply.Modulus = t.key.N.Bytes()
+	reply.Exponent = t.key.E
+	reply.Tokens = t.tokens
+	reply.WritesPerToken = t.writesPerToken
+}
+
+// issue signs the blinded tokens of a requester, up to its remaining tokens
+// for the epoch.
+func (t *tokenIssuer) issue(requester string, args *common.IssueTokensArgs, reply *common.IssueTokensReply) {
+	t.lock.Lock()
+	if args.Epoch != t.currentEpoch() {
+		t.lock.Unlock()
+		reply.Err = fmt.Sprintf("tokens requested for epoch %d in epoch %d", args.Epoch, t.epoch)
+		return
+	}
+	n := len(args.Blinded)
+	if remaining := t.tokens - t.issued[requester]; n > remaining {
+		n = remaining
+	}
+	t.issued[requester] += n
+	t.lock.Unlock()
+
+	if n == 0 {
+		reply.Err = "no tokens remaining in epoch"
+		return
+	}
+	reply.Signatures = make([][]byte, n)
+	for i := range reply.Signatures {
+		sig, err := common.SignBlindedToken(t.key, args.Blinded[i])
+		if err != nil {
+			reply.Err = err.Error()
+			reply.Signatures = nil
+			return
+		}
+		reply.Signatures[i] = sig
+	}
+}
+
+// spend admits a write with token, if it is valid and not used up.
+func (t *tokenIssuer) spend(token *common.WriteToken) error {
+	if token == nil {
+		return errors.New("write requires a token")
+	}
+	if !common.VerifyToken(&t.key.PublicKey, token) {
+		return errors.New("invalid write token")
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	spent := t.spent
+	if epoch := t.currentEpoch(); token.Epoch+1 == epoch {
+		spent = t.prevSpent
+	} else if token.Epoch != epoch {
+		return errors.New("expired write token")
+	}
+	if spent == nil || spent[token.Serial] >= t.writesPerToken {
+		return errors.New("write token used up")
+	}
+	spent[token.Serial]++
+	return nil
+}